@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"jenkins-monitor/internal/adhoc"
 	"jenkins-monitor/internal/analyze"
 	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/format"
+	"jenkins-monitor/internal/history"
 	"jenkins-monitor/internal/monitor"
 	"jenkins-monitor/internal/utils"
 )
@@ -33,6 +38,11 @@ func main() {
 	if err != nil {
 		utils.Fatal(fmt.Sprintf("Failed to load configuration: %v", err))
 	}
+	if cfg.OutputFile != "" {
+		defaultCSVPath = cfg.OutputFile
+	} else if cfg.Storage == config.StorageTSDB {
+		defaultCSVPath = strings.TrimSuffix(defaultCSVPath, filepath.Ext(defaultCSVPath)) + history.StoreExt
+	}
 
 	// After global flag parsing, flag.Args() contains the non-flag arguments.
 	// flag.Args()[0] should be the subcommand.
@@ -44,40 +54,139 @@ func main() {
 	switch flag.Args()[0] {
 	case "monitor":
 		monitorCmd := flag.NewFlagSet("monitor", flag.ContinueOnError)
-		outputFile := monitorCmd.String("output", defaultCSVPath, "Path to the output CSV file")
+		outputFile := monitorCmd.String("output", defaultCSVPath, "Path to the output CSV or store file")
+		interval := monitorCmd.Duration("interval", 0, "Sampling interval, e.g. 10s (overrides interval in the config file)")
 		monitorCmd.Usage = func() {
 			fmt.Fprintf(os.Stderr, "Usage of %s monitor:\n", os.Args[0])
-			fmt.Fprintf(os.Stderr, "  Monitors Jenkins processes and logs CPU/memory usage to a CSV file.\n")
+			fmt.Fprintf(os.Stderr, "  Monitors Jenkins processes and logs CPU/memory usage to a CSV or store file (see storage in the config file).\n")
 			fmt.Fprintf(os.Stderr, "  Prometheus metrics are exposed based on the configuration file.\n")
 			monitorCmd.PrintDefaults()
 		}
 		if err := monitorCmd.Parse(flag.Args()[1:]); err != nil {
 			utils.Fatal(fmt.Sprintf("Error parsing monitor command flags: %v", err))
 		}
+		if *interval != 0 {
+			cfg.Interval = *interval
+			if err := cfg.Validate(); err != nil {
+				utils.Fatal(fmt.Sprintf("Invalid --interval: %v", err))
+			}
+		}
 		monitor.RunMonitor(*outputFile, cfg)
 	case "analyze":
 		analyzeCmd := flag.NewFlagSet("analyze", flag.ContinueOnError)
-		inputFile := analyzeCmd.String("input", defaultCSVPath, "Path to the input CSV file")
+		var inputs stringList
+		analyzeCmd.Var(&inputs, "input", "Input CSV or store file, glob or directory; may be repeated (default: the output file and its rotated copies)")
+		rotated := analyzeCmd.Bool("rotated", false, "Also read the rotated copies of each --input file")
+		top := analyzeCmd.Int("top", 5, "Number of jobs to list in each report")
+		since := analyzeCmd.String("since", "", "Only analyze samples at or after this time (RFC 3339, YYYY-MM-DD, or a duration like 24h ago)")
+		until := analyzeCmd.String("until", "", "Only analyze samples at or before this time (RFC 3339, YYYY-MM-DD, or a duration like 1h ago)")
+		jobPattern := analyzeCmd.String("job", "", "Only analyze jobs whose name matches this regular expression")
+		analyzeFormat := analyzeCmd.String("format", "table", "Output format: table, json, csv or markdown")
+		by := analyzeCmd.String("by", "build", "Report per build, per pipeline stage of each job (stage), or the largest workspaces (workspace)")
 		analyzeCmd.Usage = func() {
 			fmt.Fprintf(os.Stderr, "Usage of %s analyze:\n", os.Args[0])
-			fmt.Fprintf(os.Stderr, "  Analyzes a CSV file generated by the monitor command to report peak CPU and memory usage.\n")
+			fmt.Fprintf(os.Stderr, "  Analyzes CSV and store files generated by the monitor command to report peak, mean and percentile CPU and memory usage per job.\n")
+			fmt.Fprintf(os.Stderr, "  Inputs may also be given as arguments; .gz files are decompressed transparently.\n")
 			analyzeCmd.PrintDefaults()
 		}
 		if err := analyzeCmd.Parse(flag.Args()[1:]); err != nil {
 			utils.Fatal(fmt.Sprintf("Error parsing analyze command flags: %v", err))
 		}
-		analyze.RunAnalyzer(*inputFile)
+		opts := analyze.Options{Top: *top, Progress: os.Stderr}
+		if opts.Format, err = format.Parse(*analyzeFormat); err != nil {
+			utils.Fatal(fmt.Sprintf("Invalid --format: %v", err))
+		}
+		if opts.By, err = analyze.ParseGroup(*by); err != nil {
+			utils.Fatal(fmt.Sprintf("Invalid --by: %v", err))
+		}
+		now := time.Now()
+		if opts.Since, err = analyze.ParseTimeArg(*since, now); err != nil {
+			utils.Fatal(fmt.Sprintf("Invalid --since: %v", err))
+		}
+		if opts.Until, err = analyze.ParseTimeArg(*until, now); err != nil {
+			utils.Fatal(fmt.Sprintf("Invalid --until: %v", err))
+		}
+		if *jobPattern != "" {
+			if opts.Job, err = regexp.Compile(*jobPattern); err != nil {
+				utils.Fatal(fmt.Sprintf("Invalid --job: %v", err))
+			}
+		}
+		if opts.Top < 1 {
+			utils.Fatal("--top must be at least 1")
+		}
+		inputs = append(inputs, analyzeCmd.Args()...)
+		if len(inputs) == 0 {
+			inputs = stringList{defaultCSVPath}
+			*rotated = true
+		}
+		discover := history.Discover
+		if opts.By == analyze.GroupWorkspace {
+			// Workspace sizes are kept in the workspace samples files next to the data files
+			discover = history.DiscoverWorkspaces
+		}
+		inputFiles, err := discover(inputs, *rotated)
+		if err != nil {
+			utils.Fatal(fmt.Sprintf("Failed to resolve input files: %v", err))
+		}
+		if len(inputFiles) == 0 {
+			utils.Fatal(fmt.Sprintf("No input files found for %s", strings.Join(inputs, ", ")))
+		}
+		analyze.RunAnalyzer(inputFiles, opts)
+	case "convert":
+		convertCmd := flag.NewFlagSet("convert", flag.ContinueOnError)
+		var inputs stringList
+		convertCmd.Var(&inputs, "input", "Input CSV file, glob or directory; may be repeated")
+		rotated := convertCmd.Bool("rotated", false, "Also convert the rotated copies of each --input file")
+		outputFile := convertCmd.String("output", "", "Path to the store file to write (appended to if it exists)")
+		convertCmd.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage of %s convert:\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "  Converts CSV files written by the monitor command into a store file.\n")
+			convertCmd.PrintDefaults()
+		}
+		if err := convertCmd.Parse(flag.Args()[1:]); err != nil {
+			utils.Fatal(fmt.Sprintf("Error parsing convert command flags: %v", err))
+		}
+		inputs = append(inputs, convertCmd.Args()...)
+		if len(inputs) == 0 || *outputFile == "" {
+			convertCmd.Usage()
+			os.Exit(2)
+		}
+		inputFiles, err := history.Discover(inputs, *rotated)
+		if err != nil {
+			utils.Fatal(fmt.Sprintf("Failed to resolve input files: %v", err))
+		}
+		written, skipped, err := history.ConvertToStore(inputFiles, *outputFile)
+		if err != nil {
+			utils.Fatal(fmt.Sprintf("Failed to convert: %v", err))
+		}
+		utils.Info(fmt.Sprintf("Converted %d records from %d files to %s (%d malformed rows skipped)", written, len(inputFiles), *outputFile, skipped))
 	case "adhoc":
 		adhocCmd := flag.NewFlagSet("adhoc", flag.ContinueOnError)
+		showProcesses := adhocCmd.Bool("processes", false, "Show the per-PID process tree under each build")
+		sampleWindow := adhocCmd.Duration("sample", time.Second, "Interval to measure CPU usage over (0 reports lifetime averages)")
+		adhocFormat := adhocCmd.String("format", "table", "Output format: table, json, csv or markdown")
+		workspaces := adhocCmd.Bool("workspaces", !cfg.Workspaces.Disable, "Measure the size of each build's workspace (within workspaces.scan_budget)")
 		adhocCmd.Usage = func() {
 			fmt.Fprintf(os.Stderr, "Usage of %s adhoc:\n", os.Args[0])
-			fmt.Fprintf(os.Stderr, "  Performs an immediate scan of running Jenkins processes and displays their CPU and memory usage.\n")
+			fmt.Fprintf(os.Stderr, "  Performs an immediate scan of running Jenkins processes and displays CPU and memory usage per build.\n")
 			adhocCmd.PrintDefaults()
 		}
 		if err := adhocCmd.Parse(flag.Args()[1:]); err != nil {
 			utils.Fatal(fmt.Sprintf("Error parsing adhoc command flags: %v", err))
 		}
-		adhoc.RunAdhoc()
+		outputFormat, err := format.Parse(*adhocFormat)
+		if err != nil {
+			utils.Fatal(fmt.Sprintf("Invalid --format: %v", err))
+		}
+		adhoc.RunAdhoc(adhoc.Options{
+			ShowProcesses:   *showProcesses,
+			SampleWindow:    *sampleWindow,
+			Format:          outputFormat,
+			Workspaces:      *workspaces,
+			WorkspaceBudget: cfg.Workspaces.ScanBudget,
+			Cgroups:         !cfg.Cgroups.Disable,
+			CgroupRoot:      cfg.Cgroups.Root,
+		})
 	default:
 		printUsage()
 	}
@@ -86,8 +195,21 @@ func main() {
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "\nCommands:\n")
-	fmt.Fprintf(os.Stderr, "  monitor   Continuously monitors Jenkins processes and logs data to a CSV file. Exposes Prometheus metrics based on config.\n")
-	fmt.Fprintf(os.Stderr, "  analyze   Analyzes a CSV file to report peak CPU and memory usage.\n")
+	fmt.Fprintf(os.Stderr, "  monitor   Continuously monitors Jenkins processes and logs data to a CSV or store file. Exposes Prometheus metrics based on config.\n")
+	fmt.Fprintf(os.Stderr, "  analyze   Analyzes one or more CSV or store files to report peak CPU and memory usage.\n")
+	fmt.Fprintf(os.Stderr, "  convert   Converts CSV files into a store file.\n")
 	fmt.Fprintf(os.Stderr, "  adhoc     Performs an immediate scan of Jenkins processes.\n")
 	fmt.Fprintf(os.Stderr, "\nUse \"%s <command> -h\" for more information about a command.\n", os.Args[0])
 }
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -4,56 +4,205 @@ import (
 	"fmt"
 	"os"
 	"sort"
-	"strings"
-	"text/tabwriter"
-
-	gopsutil "github.com/shirou/gopsutil/v3/process"
+	"time"
 
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/format"
 	"jenkins-monitor/internal/process"
 	"jenkins-monitor/internal/utils"
+	"jenkins-monitor/internal/workspace"
 )
 
-func RunAdhoc() {
-	processes, err := process.GetJenkinsProcesses()
+// Options controls what RunAdhoc measures and prints
+type Options struct {
+	// ShowProcesses prints the per-PID process tree under each build
+	ShowProcesses bool
+	// SampleWindow is the interval CPU usage is measured over; zero reports lifetime averages
+	SampleWindow time.Duration
+	// Format selects the output format
+	Format format.Format
+	// Workspaces measures the size of each build's workspace, visiting at most
+	// WorkspaceBudget files and directories in total (zero is unlimited)
+	Workspaces      bool
+	WorkspaceBudget int
+	// Cgroups reads the cgroup accounting of each process from the cgroup
+	// filesystem mounted at CgroupRoot
+	Cgroups    bool
+	CgroupRoot string
+}
+
+// RunAdhoc prints the running Jenkins builds, optionally followed by the
+// per-PID process tree of each build.
+func RunAdhoc(opts Options) {
+	collector := process.NewCollector()
+	if opts.Cgroups {
+		collector.UseCgroups(cgroup.NewSampler(cgroup.DefaultProcRoot, opts.CgroupRoot))
+	}
+	processes, err := collector.Collect()
 	if err != nil {
 		utils.Fatal(fmt.Sprintf("Error getting Jenkins processes: %v", err))
 	}
+	if opts.SampleWindow > 0 && len(processes) > 0 {
+		time.Sleep(opts.SampleWindow)
+		processes, err = collector.Collect()
+		if err != nil {
+			utils.Fatal(fmt.Sprintf("Error getting Jenkins processes: %v", err))
+		}
+	}
 
-	if len(processes) == 0 {
+	if len(processes) == 0 && opts.Format == format.Table {
 		utils.Info("No processes with JOB_NAME found")
 		fmt.Println("No processes with JOB_NAME found")
 		return
 	}
 
+	builds := process.AggregateBuilds(processes)
+	var measured []workspace.Usage
+	if opts.Workspaces {
+		var paths []string
+		for _, b := range builds {
+			paths = append(paths, b.WorkSpace)
+		}
+		measured, err = workspace.NewScanner(0, opts.WorkspaceBudget).Scan(paths, time.Now())
+		if err != nil {
+			// Logs go to stdout, which must hold only the document in the other formats
+			if opts.Format == format.Table {
+				utils.Error(fmt.Sprintf("Failed to measure workspaces: %v", err))
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to measure workspaces: %v\n", err)
+			}
+		}
+	}
+
 	// Sort by CPU usage (descending)
-	sort.Slice(processes, func(i, j int) bool {
-		return processes[i].CPU > processes[j].CPU
+	sort.SliceStable(builds, func(i, j int) bool {
+		return builds[i].CPU > builds[j].CPU
 	})
 
-	fmt.Println()
-	fmt.Println("🔍 Scanning processes for Jenkins Jobs...")
-	fmt.Println()
+	snapshot := newSnapshot(builds, opts, time.Now())
+	snapshot.addWorkspaces(measured)
+	if err := snapshot.Write(os.Stdout, opts.Format); err != nil {
+		utils.Fatal(fmt.Sprintf("Failed to write output: %v", err))
+	}
+}
+
+// newSnapshot converts aggregated builds into the output schema
+func newSnapshot(builds []process.BuildInfo, opts Options, now time.Time) Snapshot {
+	s := Snapshot{
+		GeneratedAt:         now,
+		SampleWindowSeconds: opts.SampleWindow.Seconds(),
+		Builds:              []BuildReport{},
+		Workspaces:          []WorkspaceReport{},
+	}
+
+	for _, b := range builds {
+		s.Builds = append(s.Builds, NewBuildReport(b, opts.ShowProcesses))
+		s.ProcessCount += len(b.Processes)
+	}
+	s.BuildCount = len(s.Builds)
+
+	return s
+}
 
-	// Create tabwriter for aligned columns
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+// NewBuildReport converts an aggregated build into the output schema, with
+// its process tree when processes is set
+func NewBuildReport(b process.BuildInfo, processes bool) BuildReport {
+	br := BuildReport{
+		Job:               b.BuildJobName,
+		BuildID:           b.BuildId,
+		Workspace:         b.WorkSpace,
+		NodeName:          b.NodeName,
+		Stages:            b.Stages,
+		StartTime:         b.StartTime,
+		CPUPercent:        b.CPU,
+		CPUMachinePercent: b.CPUMachine,
+		MemPercent:        float64(b.Mem),
+		RSSBytes:          b.RSS,
+		Threads:           b.NumThreads,
+		OpenFDs:           b.NumFDs,
+		FDLimitPercent:    b.FDPercent,
+		ReadBytes:         b.ReadBytes,
+		WriteBytes:        b.WriteBytes,
+		VoluntaryCtx:      b.VoluntaryCtxSwitches,
+		InvoluntaryCtx:    b.InvoluntaryCtxSwitches,
+		ProcessCount:      len(b.Processes),
+		WorkspaceBytes:    b.WorkspaceBytes,
+		Cgroup:            newCgroupReport(b.Cgroup),
+	}
+	if br.Stages == nil {
+		br.Stages = []string{}
+	}
+	if processes {
+		for _, p := range b.Processes {
+			br.Processes = append(br.Processes, NewProcessReport(p))
+		}
+	}
+	return br
+}
 
-	fmt.Fprintf(w, "%-10s\t%-8s\t%-8s\t%-20s\t%-35s\t%-15s\t%-40s\t%-10s\n",
-		"PID", "CPU%", "MEM%", "PROCESS", "JOB_NAME", "BUILD_ID", "WORKSPACE", "STAGE_NAME")
-	fmt.Fprintln(w, strings.Repeat("-", 160))
+// NewProcessReport converts a process of a build into the output schema
+func NewProcessReport(p process.BuildProcess) ProcessReport {
+	return ProcessReport{
+		PID:               p.PID,
+		PPID:              p.PPID,
+		Depth:             p.Depth,
+		Name:              processName(p.ProcessInfo),
+		Stage:             p.StageName,
+		CPUPercent:        p.CPU,
+		CPUMachinePercent: p.CPUMachine,
+		MemPercent:        float64(p.Mem),
+		RSSBytes:          p.RSS,
+		Threads:           p.NumThreads,
+		OpenFDs:           p.NumFDs,
+		FDLimit:           p.FDLimit,
+		FDLimitPercent:    p.FDPercent(),
+		ReadBytes:         p.ReadBytes,
+		WriteBytes:        p.WriteBytes,
+		VoluntaryCtx:      p.VoluntaryCtxSwitches,
+		InvoluntaryCtx:    p.InvoluntaryCtxSwitches,
+		Cgroup:            newCgroupReport(p.Cgroup),
+	}
+}
 
-	for _, p := range processes {
-		name := "unknown"
-		if proc, err := gopsutil.NewProcess(p.PID); err == nil {
-			if n, err := proc.Name(); err == nil {
-				name = n
+// addWorkspaces sets the workspace size of each build and lists the measured
+// workspaces, largest first
+func (s *Snapshot) addWorkspaces(measured []workspace.Usage) {
+	for _, u := range measured {
+		wr := WorkspaceReport{Workspace: u.Path, SizeBytes: u.Bytes, Files: u.Files, Complete: u.Complete, Builds: []string{}}
+		for i := range s.Builds {
+			b := &s.Builds[i]
+			if b.Workspace == u.Path {
+				b.WorkspaceBytes = u.Bytes
+				wr.Builds = append(wr.Builds, b.Job+" #"+b.BuildID)
 			}
 		}
+		s.Workspaces = append(s.Workspaces, wr)
+	}
+	sort.SliceStable(s.Workspaces, func(i, j int) bool {
+		return s.Workspaces[i].SizeBytes > s.Workspaces[j].SizeBytes
+	})
+}
 
-		fmt.Fprintf(w, "%-10d\t%8.1f\t%8.1f\t%-20s\t%-35s\t%-15s\t%-40s\t%-10s\n",
-			p.PID, p.CPU, p.Mem, name, p.BuildJobName, p.BuildId, p.WorkSpace, p.StageName)
+func processName(p process.ProcessInfo) string {
+	if p.Name == "" {
+		return "unknown"
 	}
+	return p.Name
+}
 
-	w.Flush()
-	fmt.Println(strings.Repeat("-", 160))
-	fmt.Printf("✅ Total processes found: %d\n", len(processes))
+// newCgroupReport converts cgroup accounting into the output schema; nil when
+// the cgroup could not be read
+func newCgroupReport(st *cgroup.Stats) *CgroupReport {
+	if st == nil {
+		return nil
+	}
+	return &CgroupReport{
+		Path:             st.Path,
+		Version:          st.Version,
+		MemoryLimit:      st.MemoryLimit,
+		MemoryUsage:      st.MemoryUsage,
+		MemoryPercent:    st.MemoryPercent(),
+		OOMKills:         st.OOMKills,
+		ThrottledPercent: st.ThrottledPercent,
+	}
 }
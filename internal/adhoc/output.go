@@ -0,0 +1,275 @@
+package adhoc
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"jenkins-monitor/internal/format"
+	"jenkins-monitor/internal/utils"
+)
+
+// Snapshot is the result of an adhoc scan. Its JSON form is the documented,
+// stable schema of `adhoc --format json`.
+type Snapshot struct {
+	GeneratedAt         time.Time     `json:"generated_at"`
+	SampleWindowSeconds float64       `json:"sample_window_seconds"`
+	BuildCount          int           `json:"build_count"`
+	ProcessCount        int           `json:"process_count"`
+	Builds              []BuildReport `json:"builds"`
+	// Workspaces lists the measured workspaces, largest first
+	Workspaces []WorkspaceReport `json:"workspaces"`
+}
+
+// BuildReport is one running build, summed over its process tree
+type BuildReport struct {
+	Job               string    `json:"job"`
+	BuildID           string    `json:"build_id"`
+	Workspace         string    `json:"workspace"`
+	NodeName          string    `json:"node_name"`
+	Stages            []string  `json:"stages"`
+	StartTime         time.Time `json:"start_time"`
+	CPUPercent        float64   `json:"cpu_percent"`
+	CPUMachinePercent float64   `json:"cpu_machine_percent"`
+	MemPercent        float64   `json:"mem_percent"`
+	RSSBytes          uint64    `json:"rss_bytes"`
+	Threads           int32     `json:"threads"`
+	OpenFDs           int32     `json:"open_fds"`
+	FDLimitPercent    float64   `json:"fd_limit_percent"`
+	ReadBytes         uint64    `json:"read_bytes"`
+	WriteBytes        uint64    `json:"write_bytes"`
+	VoluntaryCtx      int64     `json:"voluntary_ctx_switches"`
+	InvoluntaryCtx    int64     `json:"involuntary_ctx_switches"`
+	ProcessCount      int       `json:"process_count"`
+	WorkspaceBytes    uint64    `json:"workspace_bytes"`
+	// Cgroup is the most constrained cgroup of the build's processes
+	Cgroup    *CgroupReport   `json:"cgroup,omitempty"`
+	Processes []ProcessReport `json:"processes,omitempty"`
+}
+
+// CgroupReport is the accounting of a cgroup. MemoryLimit is 0 when neither
+// the cgroup nor its ancestors have a memory limit.
+type CgroupReport struct {
+	Path             string  `json:"path"`
+	Version          int     `json:"version"`
+	MemoryLimit      uint64  `json:"memory_limit_bytes"`
+	MemoryUsage      uint64  `json:"memory_usage_bytes"`
+	MemoryPercent    float64 `json:"memory_percent"`
+	OOMKills         uint64  `json:"oom_kills"`
+	ThrottledPercent float64 `json:"throttled_percent"`
+}
+
+// memPercent renders the memory usage relative to the limit for the text
+// formats, "-" when there is no cgroup or no limit
+func (c *CgroupReport) memPercent() string {
+	if c == nil || c.MemoryLimit == 0 {
+		return "-"
+	}
+	return formatFloat(c.MemoryPercent)
+}
+
+// throttledPercent renders the throttled share for the text formats, "-" when there is no cgroup
+func (c *CgroupReport) throttledPercent() string {
+	if c == nil {
+		return "-"
+	}
+	return formatFloat(c.ThrottledPercent)
+}
+
+// csvFields renders the cgroup columns of the CSV format, empty when there is no cgroup
+func (c *CgroupReport) csvFields() []string {
+	if c == nil {
+		return []string{"", "", "", "", ""}
+	}
+	return []string{
+		c.Path, strconv.FormatUint(c.MemoryLimit, 10), strconv.FormatUint(c.MemoryUsage, 10),
+		strconv.FormatUint(c.OOMKills, 10), formatFloat(c.ThrottledPercent),
+	}
+}
+
+// WorkspaceReport is the measured size of one workspace. Complete is false
+// when the scan budget ran out, so the size is a lower bound.
+type WorkspaceReport struct {
+	Workspace string   `json:"workspace"`
+	SizeBytes uint64   `json:"size_bytes"`
+	Files     int      `json:"files"`
+	Complete  bool     `json:"complete"`
+	Builds    []string `json:"builds"`
+}
+
+// size renders the size, marking lower bounds
+func (w WorkspaceReport) size() string {
+	if w.Complete {
+		return utils.FormatBytes(w.SizeBytes)
+	}
+	return ">" + utils.FormatBytes(w.SizeBytes)
+}
+
+// ProcessReport is one process of a build; only present with --processes
+type ProcessReport struct {
+	PID               int32         `json:"pid"`
+	PPID              int32         `json:"ppid"`
+	Depth             int           `json:"depth"`
+	Name              string        `json:"name"`
+	Stage             string        `json:"stage"`
+	CPUPercent        float64       `json:"cpu_percent"`
+	CPUMachinePercent float64       `json:"cpu_machine_percent"`
+	MemPercent        float64       `json:"mem_percent"`
+	RSSBytes          uint64        `json:"rss_bytes"`
+	Threads           int32         `json:"threads"`
+	OpenFDs           int32         `json:"open_fds"`
+	FDLimit           uint64        `json:"fd_limit"`
+	FDLimitPercent    float64       `json:"fd_limit_percent"`
+	ReadBytes         uint64        `json:"read_bytes"`
+	WriteBytes        uint64        `json:"write_bytes"`
+	VoluntaryCtx      int64         `json:"voluntary_ctx_switches"`
+	InvoluntaryCtx    int64         `json:"involuntary_ctx_switches"`
+	Cgroup            *CgroupReport `json:"cgroup,omitempty"`
+}
+
+// Write renders the snapshot in the requested format
+func (s *Snapshot) Write(w io.Writer, f format.Format) error {
+	switch f {
+	case format.JSON:
+		return format.WriteJSON(w, s)
+	case format.CSV:
+		return s.writeCSV(w)
+	case format.Markdown:
+		s.writeMarkdown(w)
+		return nil
+	default:
+		s.writeTable(w)
+		return nil
+	}
+}
+
+func (s *Snapshot) writeTable(w io.Writer) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "🔍 Scanning processes for Jenkins Jobs...")
+	fmt.Fprintln(w)
+
+	// Create tabwriter for aligned columns
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%-35s\t%-15s\t%6s\t%8s\t%8s\t%8s\t%10s\t%8s\t%6s\t%6s\t%10s\t%10s\t%10s\t%8s\t%6s\t%-40s\t%-20s\n",
+		"JOB_NAME", "BUILD_ID", "PROCS", "CPU%", "HOST%", "MEM%", "RSS", "THREADS", "FDS", "FD%", "READ", "WRITE", "CTXSW", "CG MEM%", "THR%", "WORKSPACE", "STAGES")
+	fmt.Fprintln(tw, strings.Repeat("-", 220))
+
+	for _, b := range s.Builds {
+		fmt.Fprintf(tw, "%-35s\t%-15s\t%6d\t%8.1f\t%8.1f\t%8.1f\t%10s\t%8d\t%6d\t%6.1f\t%10s\t%10s\t%10d\t%8s\t%6s\t%-40s\t%-20s\n",
+			b.Job, b.BuildID, b.ProcessCount, b.CPUPercent, b.CPUMachinePercent, b.MemPercent, utils.FormatBytes(b.RSSBytes),
+			b.Threads, b.OpenFDs, b.FDLimitPercent, utils.FormatBytes(b.ReadBytes), utils.FormatBytes(b.WriteBytes),
+			b.VoluntaryCtx+b.InvoluntaryCtx, b.Cgroup.memPercent(), b.Cgroup.throttledPercent(), b.Workspace, strings.Join(b.Stages, ", "))
+
+		for _, p := range b.Processes {
+			label := fmt.Sprintf("%s└─ %d %s", strings.Repeat("   ", p.Depth), p.PID, p.Name)
+			fmt.Fprintf(tw, "%-35s\t%-15s\t%6s\t%8.1f\t%8.1f\t%8.1f\t%10s\t%8d\t%6d\t%6.1f\t%10s\t%10s\t%10d\t%8s\t%6s\t%-40s\t%-20s\n",
+				label, "", "", p.CPUPercent, p.CPUMachinePercent, p.MemPercent, utils.FormatBytes(p.RSSBytes), p.Threads, p.OpenFDs,
+				p.FDLimitPercent, utils.FormatBytes(p.ReadBytes), utils.FormatBytes(p.WriteBytes), p.VoluntaryCtx+p.InvoluntaryCtx,
+				p.Cgroup.memPercent(), p.Cgroup.throttledPercent(), "", p.Stage)
+		}
+	}
+
+	tw.Flush()
+	fmt.Fprintln(w, strings.Repeat("-", 220))
+
+	if len(s.Workspaces) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Workspaces by size:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%10s\t%8s\t%-60s\t%s\n", "SIZE", "FILES", "WORKSPACE", "BUILDS")
+		for _, ws := range s.Workspaces {
+			fmt.Fprintf(tw, "%10s\t%8d\t%-60s\t%s\n", ws.size(), ws.Files, ws.Workspace, strings.Join(ws.Builds, ", "))
+		}
+		tw.Flush()
+		fmt.Fprintln(w, strings.Repeat("-", 220))
+	}
+	fmt.Fprintf(w, "✅ Total builds found: %d (%d processes)\n", s.BuildCount, s.ProcessCount)
+}
+
+func (s *Snapshot) writeMarkdown(w io.Writer) {
+	header := []string{
+		"Job", "Build", "Processes", "CPU %", "Host CPU %", "Mem %", "RSS", "Threads", "FDs", "FD limit %",
+		"Read", "Write", "Context switches", "Stages", "Cgroup mem %", "Throttled %",
+	}
+	var rows [][]string
+	for _, b := range s.Builds {
+		rows = append(rows, []string{
+			"`" + b.Job + "`", b.BuildID, strconv.Itoa(b.ProcessCount), formatFloat(b.CPUPercent), formatFloat(b.CPUMachinePercent),
+			formatFloat(b.MemPercent), utils.FormatBytes(b.RSSBytes), strconv.Itoa(int(b.Threads)), strconv.Itoa(int(b.OpenFDs)),
+			formatFloat(b.FDLimitPercent), utils.FormatBytes(b.ReadBytes), utils.FormatBytes(b.WriteBytes),
+			strconv.FormatInt(b.VoluntaryCtx+b.InvoluntaryCtx, 10), strings.Join(b.Stages, ", "),
+			b.Cgroup.memPercent(), b.Cgroup.throttledPercent(),
+		})
+	}
+	format.WriteMarkdownTable(w, header, rows)
+
+	var procRows [][]string
+	for _, b := range s.Builds {
+		for _, p := range b.Processes {
+			procRows = append(procRows, []string{
+				"`" + b.Job + "`", b.BuildID, strconv.Itoa(int(p.PID)), strconv.Itoa(int(p.PPID)), p.Name, p.Stage,
+				formatFloat(p.CPUPercent), formatFloat(p.MemPercent), utils.FormatBytes(p.RSSBytes),
+			})
+		}
+	}
+	if len(procRows) > 0 {
+		fmt.Fprintln(w)
+		format.WriteMarkdownTable(w, []string{"Job", "Build", "PID", "PPID", "Process", "Stage", "CPU %", "Mem %", "RSS"}, procRows)
+	}
+
+	var wsRows [][]string
+	for _, ws := range s.Workspaces {
+		wsRows = append(wsRows, []string{"`" + ws.Workspace + "`", ws.size(), strconv.Itoa(ws.Files), strings.Join(ws.Builds, ", ")})
+	}
+	if len(wsRows) > 0 {
+		fmt.Fprintln(w)
+		format.WriteMarkdownTable(w, []string{"Workspace", "Size", "Files", "Builds"}, wsRows)
+	}
+
+	fmt.Fprintf(w, "\n_%d builds, %d processes, scanned at %s_\n", s.BuildCount, s.ProcessCount, s.GeneratedAt.Format(time.RFC1123))
+}
+
+// writeCSV writes one "build" row per build, followed by a "process" row per
+// process when --processes is set; build-only columns are empty on process rows
+// and vice versa
+func (s *Snapshot) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"level", "job", "build_id", "pid", "ppid", "depth", "name", "stages", "workspace",
+		"cpu_percent", "cpu_machine_percent", "mem_percent", "rss_bytes", "threads", "open_fds", "process_count",
+		"workspace_bytes", "fd_limit", "fd_limit_percent", "read_bytes", "write_bytes",
+		"voluntary_ctx_switches", "involuntary_ctx_switches",
+		"cgroup", "cgroup_memory_limit_bytes", "cgroup_memory_usage_bytes", "cgroup_oom_kills", "cgroup_throttled_percent",
+	})
+	for _, b := range s.Builds {
+		cw.Write(append([]string{
+			"build", b.Job, b.BuildID, "", "", "", "", strings.Join(b.Stages, ";"), b.Workspace,
+			formatFloat(b.CPUPercent), formatFloat(b.CPUMachinePercent), formatFloat(b.MemPercent),
+			strconv.FormatUint(b.RSSBytes, 10), strconv.Itoa(int(b.Threads)), strconv.Itoa(int(b.OpenFDs)),
+			strconv.Itoa(b.ProcessCount), strconv.FormatUint(b.WorkspaceBytes, 10),
+			"", formatFloat(b.FDLimitPercent), strconv.FormatUint(b.ReadBytes, 10), strconv.FormatUint(b.WriteBytes, 10),
+			strconv.FormatInt(b.VoluntaryCtx, 10), strconv.FormatInt(b.InvoluntaryCtx, 10),
+		}, b.Cgroup.csvFields()...))
+		for _, p := range b.Processes {
+			cw.Write(append([]string{
+				"process", b.Job, b.BuildID, strconv.Itoa(int(p.PID)), strconv.Itoa(int(p.PPID)), strconv.Itoa(p.Depth),
+				p.Name, p.Stage, "",
+				formatFloat(p.CPUPercent), formatFloat(p.CPUMachinePercent), formatFloat(p.MemPercent),
+				strconv.FormatUint(p.RSSBytes, 10), strconv.Itoa(int(p.Threads)), strconv.Itoa(int(p.OpenFDs)), "", "",
+				strconv.FormatUint(p.FDLimit, 10), formatFloat(p.FDLimitPercent), strconv.FormatUint(p.ReadBytes, 10),
+				strconv.FormatUint(p.WriteBytes, 10), strconv.FormatInt(p.VoluntaryCtx, 10), strconv.FormatInt(p.InvoluntaryCtx, 10),
+			}, p.Cgroup.csvFields()...))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
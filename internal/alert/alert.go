@@ -0,0 +1,363 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/exits"
+	"jenkins-monitor/internal/host"
+	"jenkins-monitor/internal/process"
+	"jenkins-monitor/internal/utils"
+)
+
+// Alert types
+const (
+	CPUHigh      = "CPU_HIGH"
+	MemHigh      = "MEM_HIGH"
+	DurationHigh = "DURATION_HIGH"
+	// Workspace alerts compare bytes and bytes per second
+	WorkspaceSizeHigh   = "WORKSPACE_SIZE_HIGH"
+	WorkspaceGrowthHigh = "WORKSPACE_GROWTH_HIGH"
+	// Process resource alerts compare bytes, counts and a percentage of the open file limit
+	RSSHigh     = "RSS_HIGH"
+	ThreadsHigh = "THREADS_HIGH"
+	FDsHigh     = "FDS_HIGH"
+	FDLimitHigh = "FD_LIMIT_HIGH"
+	// Cgroup alerts compare percentages of the cgroup memory limit and of throttled CPU periods
+	CgroupMemHigh   = "CGROUP_MEM_HIGH"
+	CgroupThrottled = "CGROUP_THROTTLED"
+	// Exit alerts report a build process that was OOM-killed or crashed, with
+	// its last resident memory as the value
+	OOMKilled      = "OOM_KILLED"
+	ProcessCrashed = "PROCESS_CRASHED"
+	// Remediation events report an action taken on a build because of another alert
+	Remediation = "REMEDIATION"
+)
+
+// bytesPerMB converts the MB limits of the memory and workspace thresholds to bytes
+const bytesPerMB = 1024 * 1024
+
+// State is the lifecycle state carried by an alert event
+type State string
+
+const (
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+// Observation is the result of checking one alert type against one build in a sample
+type Observation struct {
+	Type      string
+	Build     process.BuildInfo
+	Rule      config.ThresholdRule // effective thresholds the build was checked against
+	Value     float64
+	Threshold float64
+	Breached  bool
+}
+
+// Key identifies the alert an observation belongs to
+func (o *Observation) Key() string {
+	return alertKey(o.Build.Key(), o.Type)
+}
+
+// Event is a notification-worthy transition produced by the Manager
+type Event struct {
+	Type      string
+	State     State
+	Build     process.BuildInfo
+	Rule      config.ThresholdRule
+	Value     float64
+	Threshold float64
+	StartedAt time.Time // when the alert first fired
+	Time      time.Time
+	Repeat    bool // a reminder for an alert that is still firing
+	Exited    bool // resolved because the build's processes are gone
+	// Host is the state of the agent when the event was raised, if sampled
+	Host *host.Snapshot
+	// Exit is the process exit an OOM_KILLED or PROCESS_CRASHED event reports
+	Exit *exits.Exit
+	// Action is the remediation action a REMEDIATION event reports; the
+	// value and threshold are those of the alert that triggered it
+	Action *Action
+}
+
+// Action is a remediation action taken on the processes of a build because of
+// an alert, or only recorded in dry-run mode. Its JSON form is the documented
+// schema of the remediation audit log.
+type Action struct {
+	Time time.Time `json:"time"`
+	// Action is terminate, kill (the escalation of terminate), renice or hook
+	Action  string  `json:"action"`
+	Alert   string  `json:"alert"`
+	Rule    string  `json:"rule"`
+	JobName string  `json:"job_name"`
+	BuildID string  `json:"build_id"`
+	PIDs    []int32 `json:"pids"`
+	DryRun  bool    `json:"dry_run"`
+	// Result is ok, failed, dry_run or not_allowed
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+}
+
+// Key identifies the alert an event belongs to
+func (e *Event) Key() string {
+	if e.Exit != nil {
+		return fmt.Sprintf("%s/%d", alertKey(e.Build.Key(), e.Type), e.Exit.Process.PID)
+	}
+	if e.Action != nil {
+		return fmt.Sprintf("%s/%s/%s", alertKey(e.Build.Key(), e.Type), e.Action.Alert, e.Action.Action)
+	}
+	return alertKey(e.Build.Key(), e.Type)
+}
+
+// ExitEvent turns an abnormal process exit into a firing event. Exits are
+// one-off, so they bypass the Manager and are never resolved. The threshold
+// is the memory limit of the process's cgroup, 0 when unknown.
+func ExitEvent(e exits.Exit, t config.ThresholdsConfig) Event {
+	b := e.Build
+	ev := Event{
+		Type:      ProcessCrashed,
+		State:     Firing,
+		Build:     b,
+		Rule:      t.Match(b.BuildJobName, b.Stages, b.AgentLabels()),
+		Value:     float64(e.Process.RSS),
+		StartedAt: e.Time,
+		Time:      e.Time,
+		Exit:      &e,
+	}
+	if e.Cause == exits.OOMKill {
+		ev.Type = OOMKilled
+	}
+	if e.Process.Cgroup != nil {
+		ev.Threshold = float64(e.Process.Cgroup.MemoryLimit)
+	}
+	return ev
+}
+
+func alertKey(buildKey, alertType string) string {
+	return buildKey + "/" + alertType
+}
+
+// alertState is the per job/build/type state tracked between samples
+type alertState struct {
+	last         Observation
+	consecutive  int
+	firing       bool
+	firedAt      time.Time
+	lastNotified time.Time
+	resolvedAt   time.Time
+}
+
+// Manager turns a stream of per-sample observations into deduplicated
+// firing and resolved events.
+type Manager struct {
+	cfg    config.AlertingConfig
+	alerts map[string]*alertState
+	now    func() time.Time
+}
+
+// NewManager creates a Manager using the given alerting settings
+func NewManager(cfg config.AlertingConfig) *Manager {
+	return &Manager{
+		cfg:    cfg,
+		alerts: make(map[string]*alertState),
+		now:    time.Now,
+	}
+}
+
+// Observe checks every build against the first threshold rule that matches it,
+// falling back to the global thresholds
+func Observe(builds []process.BuildInfo, t config.ThresholdsConfig, now time.Time) []Observation {
+	var observations []Observation
+	for _, b := range builds {
+		rule := t.Match(b.BuildJobName, b.Stages, b.AgentLabels())
+
+		if rule.CPUPercent > 0 {
+			cpu := b.CPU
+			if t.CPUMode == config.CPUModeMachine {
+				cpu = b.CPUMachine
+			}
+			observations = append(observations, Observation{
+				Type: CPUHigh, Build: b, Rule: rule, Value: cpu, Threshold: rule.CPUPercent,
+				Breached: cpu >= rule.CPUPercent,
+			})
+		}
+		if rule.MemPercent > 0 {
+			observations = append(observations, Observation{
+				Type: MemHigh, Build: b, Rule: rule, Value: float64(b.Mem), Threshold: rule.MemPercent,
+				Breached: float64(b.Mem) >= rule.MemPercent,
+			})
+		}
+		if rule.MaxDuration > 0 && !b.StartTime.IsZero() {
+			running := now.Sub(b.StartTime)
+			observations = append(observations, Observation{
+				Type: DurationHigh, Build: b, Rule: rule, Value: running.Seconds(), Threshold: rule.MaxDuration.Seconds(),
+				Breached: running >= rule.MaxDuration,
+			})
+		}
+		if rule.RSSMB > 0 {
+			limit := rule.RSSMB * bytesPerMB
+			observations = append(observations, Observation{
+				Type: RSSHigh, Build: b, Rule: rule, Value: float64(b.RSS), Threshold: limit,
+				Breached: float64(b.RSS) >= limit,
+			})
+		}
+		if rule.MaxThreads > 0 {
+			observations = append(observations, Observation{
+				Type: ThreadsHigh, Build: b, Rule: rule, Value: float64(b.NumThreads), Threshold: float64(rule.MaxThreads),
+				Breached: int(b.NumThreads) >= rule.MaxThreads,
+			})
+		}
+		if rule.MaxOpenFDs > 0 {
+			observations = append(observations, Observation{
+				Type: FDsHigh, Build: b, Rule: rule, Value: float64(b.NumFDs), Threshold: float64(rule.MaxOpenFDs),
+				Breached: int(b.NumFDs) >= rule.MaxOpenFDs,
+			})
+		}
+		if rule.FDPercent > 0 {
+			observations = append(observations, Observation{
+				Type: FDLimitHigh, Build: b, Rule: rule, Value: b.FDPercent, Threshold: rule.FDPercent,
+				Breached: b.FDPercent >= rule.FDPercent,
+			})
+		}
+		if cg := b.Cgroup; cg != nil {
+			if rule.CgroupMemPercent > 0 && cg.MemoryLimit > 0 {
+				observations = append(observations, Observation{
+					Type: CgroupMemHigh, Build: b, Rule: rule, Value: cg.MemoryPercent(), Threshold: rule.CgroupMemPercent,
+					Breached: cg.MemoryPercent() >= rule.CgroupMemPercent,
+				})
+			}
+			if rule.CgroupThrottledPercent > 0 {
+				observations = append(observations, Observation{
+					Type: CgroupThrottled, Build: b, Rule: rule, Value: cg.ThrottledPercent, Threshold: rule.CgroupThrottledPercent,
+					Breached: cg.ThrottledPercent >= rule.CgroupThrottledPercent,
+				})
+			}
+		}
+		if !b.WorkspaceMeasured {
+			continue
+		}
+		if rule.WorkspaceSizeMB > 0 {
+			limit := rule.WorkspaceSizeMB * bytesPerMB
+			observations = append(observations, Observation{
+				Type: WorkspaceSizeHigh, Build: b, Rule: rule, Value: float64(b.WorkspaceBytes), Threshold: limit,
+				Breached: float64(b.WorkspaceBytes) >= limit,
+			})
+		}
+		if rule.WorkspaceGrowthMBPerMin > 0 {
+			limit := rule.WorkspaceGrowthMBPerMin * bytesPerMB / 60
+			observations = append(observations, Observation{
+				Type: WorkspaceGrowthHigh, Build: b, Rule: rule, Value: b.WorkspaceGrowth, Threshold: limit,
+				Breached: b.WorkspaceGrowth >= limit,
+			})
+		}
+	}
+	return observations
+}
+
+// FormatValue renders an observed value or threshold in the unit of its alert type
+func FormatValue(alertType string, v float64) string {
+	switch alertType {
+	case DurationHigh:
+		return (time.Duration(v) * time.Second).Round(time.Second).String()
+	case WorkspaceSizeHigh, RSSHigh, OOMKilled, ProcessCrashed:
+		return utils.FormatBytes(uint64(v))
+	case ThreadsHigh, FDsHigh:
+		return fmt.Sprintf("%.0f", v)
+	case WorkspaceGrowthHigh:
+		return utils.FormatBytes(uint64(max(v, 0)*60)) + "/min"
+	}
+	return fmt.Sprintf("%.2f%%", v)
+}
+
+// Evaluate feeds one sample's observations into the state machine and returns
+// the events that should be notified. Alerts whose build no longer appears in
+// the sample are resolved as exited.
+func (m *Manager) Evaluate(observations []Observation) []Event {
+	now := m.now()
+	seen := make(map[string]bool, len(observations))
+	var events []Event
+
+	for _, o := range observations {
+		key := o.Key()
+		seen[key] = true
+
+		st, ok := m.alerts[key]
+		if !ok {
+			st = &alertState{}
+			m.alerts[key] = st
+		}
+		st.last = o
+
+		if !o.Breached {
+			st.consecutive = 0
+			if st.firing {
+				st.firing = false
+				st.resolvedAt = now
+				events = m.appendResolved(events, st, now, false)
+			}
+			continue
+		}
+
+		st.consecutive++
+		switch {
+		case !st.firing:
+			if st.consecutive < m.cfg.ConsecutiveSamples {
+				continue
+			}
+			if !st.resolvedAt.IsZero() && now.Sub(st.resolvedAt) < m.cfg.Cooldown {
+				continue
+			}
+			st.firing = true
+			st.firedAt = now
+			st.lastNotified = now
+			events = append(events, newEvent(st, Firing, now))
+		case m.cfg.RepeatInterval > 0 && now.Sub(st.lastNotified) >= m.cfg.RepeatInterval:
+			st.lastNotified = now
+			ev := newEvent(st, Firing, now)
+			ev.Repeat = true
+			events = append(events, ev)
+		}
+	}
+
+	var gone []string
+	for key := range m.alerts {
+		if !seen[key] {
+			gone = append(gone, key)
+		}
+	}
+	sort.Strings(gone)
+	for _, key := range gone {
+		st := m.alerts[key]
+		if st.firing {
+			events = m.appendResolved(events, st, now, true)
+		}
+		delete(m.alerts, key)
+	}
+
+	return events
+}
+
+func (m *Manager) appendResolved(events []Event, st *alertState, now time.Time, exited bool) []Event {
+	if m.cfg.DisableResolved {
+		return events
+	}
+	ev := newEvent(st, Resolved, now)
+	ev.Exited = exited
+	return append(events, ev)
+}
+
+func newEvent(st *alertState, state State, now time.Time) Event {
+	return Event{
+		Type:      st.last.Type,
+		State:     state,
+		Build:     st.last.Build,
+		Rule:      st.last.Rule,
+		Value:     st.last.Value,
+		Threshold: st.last.Threshold,
+		StartedAt: st.firedAt,
+		Time:      now,
+	}
+}
@@ -0,0 +1,279 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/exits"
+	"jenkins-monitor/internal/process"
+)
+
+// fakeClock lets tests advance the Manager's notion of time
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestManager(cfg config.AlertingConfig) (*Manager, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewManager(cfg)
+	m.now = clock.now
+	return m, clock
+}
+
+func cpuObservation(cpu float64) []Observation {
+	build := process.BuildInfo{BuildJobName: "app", BuildId: "1", CPU: cpu}
+	return Observe([]process.BuildInfo{build}, config.ThresholdsConfig{CPUPercent: 80}, time.Now())
+}
+
+func states(events []Event) []State {
+	var s []State
+	for _, e := range events {
+		s = append(s, e.State)
+	}
+	return s
+}
+
+func TestManagerFiresOnceAfterConsecutiveSamples(t *testing.T) {
+	m, clock := newTestManager(config.AlertingConfig{ConsecutiveSamples: 2})
+
+	steps := []struct {
+		cpu  float64
+		want []State
+	}{
+		{cpu: 90, want: nil},
+		{cpu: 50, want: nil},
+		{cpu: 90, want: nil},
+		{cpu: 95, want: []State{Firing}},
+		{cpu: 99, want: nil},
+		{cpu: 10, want: []State{Resolved}},
+	}
+
+	for i, step := range steps {
+		got := states(m.Evaluate(cpuObservation(step.cpu)))
+		if len(got) != len(step.want) || (len(got) > 0 && got[0] != step.want[0]) {
+			t.Fatalf("step %d (cpu %.0f): events %v, want %v", i, step.cpu, got, step.want)
+		}
+		clock.advance(30 * time.Second)
+	}
+}
+
+func TestManagerRepeatAndCooldown(t *testing.T) {
+	m, clock := newTestManager(config.AlertingConfig{
+		ConsecutiveSamples: 1,
+		RepeatInterval:     time.Minute,
+		Cooldown:           5 * time.Minute,
+	})
+
+	if got := m.Evaluate(cpuObservation(90)); len(got) != 1 || got[0].Repeat {
+		t.Fatalf("first breach: %+v, want one initial firing event", got)
+	}
+	clock.advance(30 * time.Second)
+	if got := m.Evaluate(cpuObservation(90)); len(got) != 0 {
+		t.Fatalf("within repeat interval: %+v, want none", got)
+	}
+	clock.advance(30 * time.Second)
+	if got := m.Evaluate(cpuObservation(90)); len(got) != 1 || !got[0].Repeat {
+		t.Fatalf("after repeat interval: %+v, want one repeat event", got)
+	}
+
+	clock.advance(30 * time.Second)
+	if got := states(m.Evaluate(cpuObservation(10))); len(got) != 1 || got[0] != Resolved {
+		t.Fatalf("back under threshold: %v, want [resolved]", got)
+	}
+	clock.advance(time.Minute)
+	if got := m.Evaluate(cpuObservation(90)); len(got) != 0 {
+		t.Fatalf("within cooldown: %+v, want none", got)
+	}
+	clock.advance(5 * time.Minute)
+	if got := states(m.Evaluate(cpuObservation(90))); len(got) != 1 || got[0] != Firing {
+		t.Fatalf("after cooldown: %v, want [firing]", got)
+	}
+}
+
+func TestManagerResolvesExitedBuilds(t *testing.T) {
+	m, _ := newTestManager(config.AlertingConfig{ConsecutiveSamples: 1})
+
+	m.Evaluate(cpuObservation(90))
+	got := m.Evaluate(nil)
+	if len(got) != 1 || got[0].State != Resolved || !got[0].Exited {
+		t.Fatalf("build exit: %+v, want one exited resolved event", got)
+	}
+	if len(m.alerts) != 0 {
+		t.Errorf("state for exited build was not cleaned up")
+	}
+}
+
+func TestManagerDisableResolved(t *testing.T) {
+	m, _ := newTestManager(config.AlertingConfig{ConsecutiveSamples: 1, DisableResolved: true})
+
+	m.Evaluate(cpuObservation(90))
+	if got := m.Evaluate(cpuObservation(10)); len(got) != 0 {
+		t.Fatalf("resolved notifications disabled: %+v, want none", got)
+	}
+}
+
+func TestObserveWorkspace(t *testing.T) {
+	thresholds := config.ThresholdsConfig{WorkspaceSizeMB: 100, WorkspaceGrowthMBPerMin: 60}
+	tests := []struct {
+		name  string
+		build process.BuildInfo
+		want  map[string]bool // breached per alert type
+	}{
+		{
+			name:  "not measured yet",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1"},
+			want:  map[string]bool{},
+		},
+		{
+			name:  "small and steady",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1", WorkspaceMeasured: true, WorkspaceBytes: 10 << 20},
+			want:  map[string]bool{WorkspaceSizeHigh: false, WorkspaceGrowthHigh: false},
+		},
+		{
+			name:  "large and growing 2 MiB/s",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1", WorkspaceMeasured: true, WorkspaceBytes: 200 << 20, WorkspaceGrowth: 2 << 20},
+			want:  map[string]bool{WorkspaceSizeHigh: true, WorkspaceGrowthHigh: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]bool)
+			for _, o := range Observe([]process.BuildInfo{tt.build}, thresholds, time.Now()) {
+				got[o.Type] = o.Breached
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Observe() = %v, want %v", got, tt.want)
+			}
+			for typ, breached := range tt.want {
+				if got[typ] != breached {
+					t.Errorf("%s breached = %v, want %v", typ, got[typ], breached)
+				}
+			}
+		})
+	}
+
+	if got := FormatValue(WorkspaceGrowthHigh, 1<<20); got != "60.0MiB/min" {
+		t.Errorf("FormatValue() = %q, want 60.0MiB/min", got)
+	}
+}
+
+func TestObserveProcessResources(t *testing.T) {
+	thresholds := config.ThresholdsConfig{RSSMB: 1024, MaxThreads: 1000, MaxOpenFDs: 5000, FDPercent: 90}
+	tests := []struct {
+		name  string
+		build process.BuildInfo
+		want  map[string]bool // breached per alert type
+	}{
+		{
+			name:  "within limits",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1", RSS: 512 << 20, NumThreads: 200, NumFDs: 300, FDPercent: 30},
+			want:  map[string]bool{RSSHigh: false, ThreadsHigh: false, FDsHigh: false, FDLimitHigh: false},
+		},
+		{
+			name:  "thread leak near the descriptor limit",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1", RSS: 2 << 30, NumThreads: 4000, NumFDs: 1000, FDPercent: 97.6},
+			want:  map[string]bool{RSSHigh: true, ThreadsHigh: true, FDsHigh: false, FDLimitHigh: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]bool)
+			for _, o := range Observe([]process.BuildInfo{tt.build}, thresholds, time.Now()) {
+				got[o.Type] = o.Breached
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Observe() = %v, want %v", got, tt.want)
+			}
+			for typ, breached := range tt.want {
+				if got[typ] != breached {
+					t.Errorf("%s breached = %v, want %v", typ, got[typ], breached)
+				}
+			}
+		})
+	}
+
+	if got := FormatValue(ThreadsHigh, 4000); got != "4000" {
+		t.Errorf("FormatValue() = %q, want 4000", got)
+	}
+}
+
+func TestObserveCgroup(t *testing.T) {
+	thresholds := config.ThresholdsConfig{CgroupMemPercent: 90, CgroupThrottledPercent: 50}
+	tests := []struct {
+		name  string
+		build process.BuildInfo
+		want  map[string]bool // breached per alert type
+	}{
+		{
+			name:  "no cgroup",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1"},
+			want:  map[string]bool{},
+		},
+		{
+			name:  "unlimited cgroup is only checked for throttling",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1", Cgroup: &cgroup.Stats{MemoryUsage: 1 << 30, ThrottledPercent: 10}},
+			want:  map[string]bool{CgroupThrottled: false},
+		},
+		{
+			name: "close to the limit and throttled",
+			build: process.BuildInfo{BuildJobName: "app", BuildId: "1", Cgroup: &cgroup.Stats{
+				MemoryLimit: 4 << 30, MemoryUsage: 3900 << 20, ThrottledPercent: 75,
+			}},
+			want: map[string]bool{CgroupMemHigh: true, CgroupThrottled: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]bool)
+			for _, o := range Observe([]process.BuildInfo{tt.build}, thresholds, time.Now()) {
+				got[o.Type] = o.Breached
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Observe() = %v, want %v", got, tt.want)
+			}
+			for typ, breached := range tt.want {
+				if got[typ] != breached {
+					t.Errorf("%s breached = %v, want %v", typ, got[typ], breached)
+				}
+			}
+		})
+	}
+}
+
+func TestExitEvent(t *testing.T) {
+	thresholds := config.ThresholdsConfig{
+		Severity: config.SeverityWarning,
+		Rules:    []config.ThresholdRule{{Name: "release", Job: "release-*", Severity: config.SeverityCritical}},
+	}
+	b := process.BuildInfo{BuildJobName: "release-app", BuildId: "9"}
+	now := time.Now()
+
+	ev := ExitEvent(exits.Exit{
+		Cause: exits.OOMKill, Build: b, Time: now,
+		Process: process.ProcessInfo{PID: 42, RSS: 3 << 30, Cgroup: &cgroup.Stats{MemoryLimit: 4 << 30}},
+	}, thresholds)
+	if ev.Type != OOMKilled || ev.State != Firing || ev.Value != 3<<30 || ev.Threshold != 4<<30 {
+		t.Errorf("ExitEvent() = %+v, want a firing OOM_KILLED event", ev)
+	}
+	if ev.Rule.Name != "release" || ev.Rule.Severity != config.SeverityCritical {
+		t.Errorf("rule = %s (%s), want release (critical)", ev.Rule.Name, ev.Rule.Severity)
+	}
+	// Every exit is an alert of its own
+	if got := ev.Key(); got != "release-app#9/OOM_KILLED/42" {
+		t.Errorf("Key() = %q", got)
+	}
+
+	ev = ExitEvent(exits.Exit{Cause: exits.Crash, Build: b, Time: now, Process: process.ProcessInfo{PID: 43}}, thresholds)
+	if ev.Type != ProcessCrashed || ev.Threshold != 0 {
+		t.Errorf("ExitEvent() = %+v, want PROCESS_CRASHED without threshold", ev)
+	}
+}
@@ -1,103 +1,220 @@
 package analyze
 
 import (
-	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
 	"sort"
 	"time"
 
+	"jenkins-monitor/internal/format"
+	"jenkins-monitor/internal/history"
 	"jenkins-monitor/internal/utils"
 )
 
-func RunAnalyzer(inputFile string) {
-	file, err := os.Open(inputFile)
-	if err != nil {
-		utils.Fatal(fmt.Sprintf("Failed to open input file: %v", err))
-	}
-	defer file.Close()
+// Options controls which samples are analyzed and how much is reported
+type Options struct {
+	// Top is the number of jobs listed per report
+	Top int
+	// Since and Until bound the sample timestamps; zero values leave the range open
+	Since time.Time
+	Until time.Time
+	// Job restricts the analysis to jobs whose name matches; nil matches all jobs
+	Job *regexp.Regexp
+	// Format selects the output format
+	Format format.Format
+	// By selects whether builds, the stages of each job or workspaces are reported
+	By Group
+	// Progress receives progress lines while large inputs are read; nil disables them
+	Progress io.Writer
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		utils.Fatal(fmt.Sprintf("Failed to read CSV file: %v", err))
+// RunAnalyzer merges the samples of every input file into one report. The
+// files are streamed once, and memory grows with the number of builds rather
+// than the number of samples.
+func RunAnalyzer(inputFiles []string, opts Options) {
+	if opts.By == GroupWorkspace {
+		runWorkspaceReport(inputFiles, opts)
+		return
+	}
+	a := newAnalyzer()
+	a.byStage = opts.By == GroupStage
+	if err := a.readFiles(inputFiles, opts); err != nil {
+		utils.Fatal(err.Error())
 	}
 
-	if len(records) <= 1 {
+	if len(a.builds) == 0 && opts.Format == format.Table {
 		fmt.Println("No data to analyze.")
+		if a.skipped > 0 {
+			fmt.Printf("Skipped malformed rows: %d\n", a.skipped)
+		}
 		return
 	}
 
-	// Skip header
-	records = records[1:]
-
-	type JobStats struct {
-		PeakCPU     float64
-		PeakCPUTime string
-		PeakMem     float64
-		PeakMemTime string
+	if a.byStage {
+		report := newStageReport(a, opts)
+		if err := report.Write(os.Stdout, opts.Format); err != nil {
+			utils.Fatal(fmt.Sprintf("Failed to write report: %v", err))
+		}
+		return
 	}
 
-	jobStats := make(map[string]*JobStats)
-
-	for _, record := range records {
-		cpu, _ := utils.ParseFloat(record[2])
-		mem, _ := utils.ParseFloat(record[3])
-		buildPath := record[4]
-		timestamp := record[0]
-
-		if _, ok := jobStats[buildPath]; !ok {
-			jobStats[buildPath] = &JobStats{}
-		}
+	report := a.report(opts)
+	if err := report.Write(os.Stdout, opts.Format); err != nil {
+		utils.Fatal(fmt.Sprintf("Failed to write report: %v", err))
+	}
+}
 
-		if cpu > jobStats[buildPath].PeakCPU {
-			jobStats[buildPath].PeakCPU = cpu
-			jobStats[buildPath].PeakCPUTime = timestamp
-		}
+// Analyze merges the samples of every input file into the report of the top
+// jobs by CPU and memory peak, as RunAnalyzer prints it without --by
+func Analyze(inputFiles []string, opts Options) (Report, error) {
+	a := newAnalyzer()
+	if err := a.readFiles(inputFiles, opts); err != nil {
+		return Report{}, err
+	}
+	return a.report(opts), nil
+}
 
-		if mem > jobStats[buildPath].PeakMem {
-			jobStats[buildPath].PeakMem = mem
-			jobStats[buildPath].PeakMemTime = timestamp
+// readFiles adds the samples of every input file selected by opts
+func (a *analyzer) readFiles(inputFiles []string, opts Options) error {
+	progress := newProgressReporter(opts.Progress, inputFiles)
+	q := history.Query{Since: opts.Since, Until: opts.Until, Job: opts.Job}
+	for _, inputFile := range inputFiles {
+		if err := a.readFile(inputFile, q, progress.file(inputFile)); err != nil {
+			return err
 		}
 	}
-
-	type JobPeak struct {
-		BuildPath string
-		Value     float64
-		Timestamp string
+	progress.finish()
+	if a.skipped > 0 && opts.Format != format.Table && opts.Progress != nil {
+		fmt.Fprintf(opts.Progress, "Skipped %d malformed rows\n", a.skipped)
 	}
+	return nil
+}
 
-	var cpuPeaks []JobPeak
-	var memPeaks []JobPeak
+// report ranks the analyzed builds by CPU and memory peak
+func (a *analyzer) report(opts Options) Report {
+	var jobs []JobStats
+	for _, acc := range a.builds {
+		jobs = append(jobs, acc.stats())
+	}
 
-	for buildPath, stats := range jobStats {
-		cpuPeaks = append(cpuPeaks, JobPeak{BuildPath: buildPath, Value: stats.PeakCPU, Timestamp: stats.PeakCPUTime})
-		memPeaks = append(memPeaks, JobPeak{BuildPath: buildPath, Value: stats.PeakMem, Timestamp: stats.PeakMemTime})
+	report := Report{
+		GeneratedAt:  time.Now(),
+		JobsAnalyzed: len(jobs),
+		SkippedRows:  a.skipped,
+		TopCPU:       []JobReport{},
+		TopMemory:    []JobReport{},
+	}
+	if !opts.Since.IsZero() {
+		report.Since = &opts.Since
+	}
+	if !opts.Until.IsZero() {
+		report.Until = &opts.Until
 	}
 
 	// Sort by CPU peak
-	sort.Slice(cpuPeaks, func(i, j int) bool {
-		return cpuPeaks[i].Value > cpuPeaks[j].Value
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CPU.Peak > jobs[j].CPU.Peak
 	})
+	for i := 0; i < opts.Top && i < len(jobs); i++ {
+		report.TopCPU = append(report.TopCPU, newJobReport(jobs[i]))
+	}
 
 	// Sort by memory peak
-	sort.Slice(memPeaks, func(i, j int) bool {
-		return memPeaks[i].Value > memPeaks[j].Value
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Mem.Peak > jobs[j].Mem.Peak
 	})
+	for i := 0; i < opts.Top && i < len(jobs); i++ {
+		report.TopMemory = append(report.TopMemory, newJobReport(jobs[i]))
+	}
+	return report
+}
+
+// buildKey identifies a build, or a stage of a build with --by stage, in the analyzed files
+type buildKey struct {
+	Job     string
+	BuildID string
+	Stage   string
+}
+
+// analyzer folds records into per-build statistics. Each monitor tick writes
+// one row per PID with a shared timestamp, and the rows of a tick are
+// contiguous, so the rows are summed per tick to get the usage of the build's
+// whole process tree before the tick is added to the build's statistics.
+type analyzer struct {
+	builds   map[buildKey]*jobAccumulator
+	tick     map[buildKey]*Sample
+	tickTime int64
+	skipped  int
+	byStage  bool // keep the stages of a build apart
+}
 
-	fmt.Println("Top 5 Jobs by Peak CPU Usage:")
-	fmt.Println("-----------------------------------------------------------------")
-	for i := 0; i < 5 && i < len(cpuPeaks); i++ {
-		fmt.Printf("%-45s %6.2f%%  (at %s)\n", cpuPeaks[i].BuildPath, cpuPeaks[i].Value, cpuPeaks[i].Timestamp)
+func newAnalyzer() *analyzer {
+	return &analyzer{
+		builds: make(map[buildKey]*jobAccumulator),
+		tick:   make(map[buildKey]*Sample),
 	}
-	fmt.Println()
+}
 
-	fmt.Println("Top 5 Jobs by Peak Memory Usage:")
-	fmt.Println("-----------------------------------------------------------------")
-	for i := 0; i < 5 && i < len(memPeaks); i++ {
-		fmt.Printf("%-45s %6.2f%%  (at %s)\n", memPeaks[i].BuildPath, memPeaks[i].Value, memPeaks[i].Timestamp)
+// readFile adds the records of a data file that match q. Malformed rows are
+// counted and skipped.
+func (a *analyzer) readFile(inputFile string, q history.Query, progress history.Progress) error {
+	skipped, err := history.ReadFile(inputFile, q, a.add, progress)
+	a.skipped += skipped
+	a.flushTick()
+	if err != nil {
+		return fmt.Errorf("Failed to read input file: %v", err)
 	}
-	fmt.Println()
+	return nil
+}
 
-	fmt.Printf("Stats generated at: %s\n", time.Now().Format(time.RFC1123))
+// add sums one record into the current tick
+func (a *analyzer) add(rec history.Record) {
+	if t := rec.Time.Unix(); t != a.tickTime {
+		a.flushTick()
+		a.tickTime = t
+	}
+	key := buildKey{Job: rec.JobName, BuildID: rec.BuildID}
+	if a.byStage {
+		key.Stage = rec.Stage
+	}
+	s, ok := a.tick[key]
+	if !ok {
+		s = &Sample{Time: rec.Time}
+		a.tick[key] = s
+	}
+	s.CPU += rec.CPU
+	s.Mem += rec.Mem
+}
+
+// flushTick adds the summed samples of the current tick to their builds
+func (a *analyzer) flushTick() {
+	for key, s := range a.tick {
+		acc, ok := a.builds[key]
+		if !ok {
+			acc = newJobAccumulator(key.Job, key.BuildID)
+			a.builds[key] = acc
+		}
+		acc.add(*s)
+	}
+	clear(a.tick)
+}
+
+// ParseTimeArg parses a --since/--until value: an RFC 3339 timestamp, a date
+// (YYYY-MM-DD, local time), or a duration such as 24h meaning that long before now
+func ParseTimeArg(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339, YYYY-MM-DD or a duration like 24h", value)
 }
@@ -0,0 +1,116 @@
+package analyze
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"jenkins-monitor/internal/history"
+)
+
+func TestAnalyzerStreamsTicks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes.csv")
+	data := "# jenkins-monitor schema=2\n" +
+		"timestamp,host,job_name,build_id,stage,workspace,pid,command,cpu,mem,rss_bytes,threads\n" +
+		"2024-06-01T10:00:00Z,agent,app,41,Build,/ws,1,java,50,10,0,1\n" +
+		"2024-06-01T10:00:00Z,agent,app,41,Build,/ws,2,sh,30,5,0,1\n" +
+		"2024-06-01T10:00:00Z,agent,app,42,Build,/ws,3,java,5,1,0,1\n" +
+		"2024-06-01T10:00:30Z,agent,app,41,Build,/ws,1,java,20,10,0,1\n" +
+		"2024-06-01T10:00:30Z,agent,app,41,Build,/ws,2,sh,x,5,0,1\n" +
+		"2024-06-01T10:01:00Z,agent,app,41,Build,/ws,1,ja"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAnalyzer()
+	if err := a.readFile(path, history.Query{}, nil); err != nil {
+		t.Fatalf("readFile() error = %v", err)
+	}
+
+	if a.skipped != 2 {
+		t.Errorf("skipped = %d, want 2 (one bad value, one truncated row)", a.skipped)
+	}
+	if len(a.builds) != 2 {
+		t.Fatalf("got %d builds, want 2", len(a.builds))
+	}
+
+	got := a.builds[buildKey{Job: "app", BuildID: "41"}].stats()
+	if got.Samples != 2 {
+		t.Errorf("Samples = %d, want 2", got.Samples)
+	}
+	// The rows of the first tick are summed over the process tree
+	if got.CPU.Peak != 80 || got.Mem.Peak != 15 {
+		t.Errorf("peaks = %.0f CPU, %.0f mem, want 80 and 15", got.CPU.Peak, got.Mem.Peak)
+	}
+	// 30s at 20%
+	if got.CPUSeconds != 6 {
+		t.Errorf("CPUSeconds = %.1f, want 6", got.CPUSeconds)
+	}
+}
+
+func TestStageReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes.csv")
+	data := "# jenkins-monitor schema=2\n" +
+		"timestamp,host,job_name,build_id,stage,workspace,pid,command,cpu,mem,rss_bytes,threads\n" +
+		"2024-06-01T10:00:00Z,agent,app,41,Build,/ws,1,java,50,10,0,1\n" +
+		"2024-06-01T10:00:00Z,agent,app,41,Build,/ws,2,sh,30,5,0,1\n" +
+		"2024-06-01T10:00:30Z,agent,app,41,Build,/ws,1,java,100,10,0,1\n" +
+		"2024-06-01T10:00:30Z,agent,app,41,Test,/ws,3,java,40,30,0,1\n" +
+		"2024-06-01T10:01:00Z,agent,app,41,Test,/ws,3,java,40,20,0,1\n" +
+		"2024-06-01T11:00:00Z,agent,app,42,Build,/ws,4,java,10,40,0,1\n" +
+		"2024-06-01T11:00:30Z,agent,app,42,Build,/ws,4,java,10,40,0,1\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAnalyzer()
+	a.byStage = true
+	if err := a.readFile(path, history.Query{}, nil); err != nil {
+		t.Fatalf("readFile() error = %v", err)
+	}
+	report := newStageReport(a, Options{Top: 5})
+
+	if len(report.Jobs) != 1 || report.Jobs[0].Builds != 2 {
+		t.Fatalf("Jobs = %+v, want app with 2 builds", report.Jobs)
+	}
+	stages := report.Jobs[0].Stages
+	if len(stages) != 2 || stages[0].Stage != "Build" || stages[1].Stage != "Test" {
+		t.Fatalf("Stages = %+v, want Build then Test", stages)
+	}
+	// Build: 80% then 100% in #41 and 10% in #42; 30s at 100% plus 30s at 10%
+	if stages[0].PeakCPU != 100 || stages[0].PeakMemory != 40 || stages[0].CPUSeconds != 33 || stages[0].Builds != 2 {
+		t.Errorf("Build = %+v, want peak CPU 100, peak memory 40, 33 CPU-seconds over 2 builds", stages[0])
+	}
+	if stages[1].PeakMemory != 30 || stages[1].CPUSeconds != 12 || stages[1].Builds != 1 {
+		t.Errorf("Test = %+v, want peak memory 30 and 12 CPU-seconds in 1 build", stages[1])
+	}
+}
+
+func TestWorkspaceReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes.workspaces.csv")
+	data := "# jenkins-monitor workspace-schema=1\n" +
+		"timestamp,host,job_name,build_id,workspace,size_bytes,files,complete,growth_bytes_per_second\n" +
+		"2024-06-01T10:00:00Z,agent,app,41,/ws/app,1000,10,true,0.00\n" +
+		"2024-06-01T10:05:00Z,agent,app,41,/ws/app,31000,12,true,100.00\n" +
+		"2024-06-01T10:10:00Z,agent,app,42,/ws/app,5000,12,true,0.00\n" +
+		"2024-06-01T10:00:00Z,agent,lib,7,/ws/lib,9000,3,false,0.00\n" +
+		"2024-06-01T10:05:00Z,agent,lib,7,/ws/lib,x,3,true,0.00\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := newWorkspaceReport([]string{path}, Options{Top: 5})
+	if err != nil {
+		t.Fatalf("newWorkspaceReport() error = %v", err)
+	}
+	if report.WorkspacesAnalyzed != 2 || report.SkippedRows != 1 {
+		t.Fatalf("analyzed %d workspaces, skipped %d rows; want 2 and 1", report.WorkspacesAnalyzed, report.SkippedRows)
+	}
+	app := report.TopSize[0]
+	if app.Workspace != "/ws/app" || app.PeakBytes != 31000 || app.LastBytes != 5000 || app.Builds != 2 || app.PeakGrowth != 100 {
+		t.Errorf("TopSize[0] = %+v, want /ws/app peaking at 31000 bytes over 2 builds", app)
+	}
+	if len(report.TopGrowth) != 1 || report.TopGrowth[0].Workspace != "/ws/app" {
+		t.Errorf("TopGrowth = %+v, want only /ws/app", report.TopGrowth)
+	}
+}
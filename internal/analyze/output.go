@@ -0,0 +1,183 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"jenkins-monitor/internal/format"
+)
+
+// Report is the result of an analysis. Its JSON form is the documented,
+// stable schema of `analyze --format json`.
+type Report struct {
+	GeneratedAt  time.Time   `json:"generated_at"`
+	Since        *time.Time  `json:"since,omitempty"`
+	Until        *time.Time  `json:"until,omitempty"`
+	JobsAnalyzed int         `json:"jobs_analyzed"`
+	SkippedRows  int         `json:"skipped_rows"`
+	TopCPU       []JobReport `json:"top_cpu"`
+	TopMemory    []JobReport `json:"top_memory"`
+}
+
+// JobReport is the statistics of one job in a Report
+type JobReport struct {
+	Job             string       `json:"job"`
+	BuildID         string       `json:"build_id,omitempty"`
+	Samples         int          `json:"samples"`
+	CPU             MetricReport `json:"cpu"`
+	Memory          MetricReport `json:"memory"`
+	CPUSeconds      float64      `json:"cpu_seconds"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	FirstSeen       time.Time    `json:"first_seen"`
+	LastSeen        time.Time    `json:"last_seen"`
+}
+
+// MetricReport is the distribution of one metric, in percent
+type MetricReport struct {
+	Peak     float64   `json:"peak"`
+	PeakTime time.Time `json:"peak_time"`
+	Mean     float64   `json:"mean"`
+	P50      float64   `json:"p50"`
+	P95      float64   `json:"p95"`
+	P99      float64   `json:"p99"`
+}
+
+func newJobReport(s JobStats) JobReport {
+	return JobReport{
+		Job:             s.Job,
+		BuildID:         s.BuildID,
+		Samples:         s.Samples,
+		CPU:             newMetricReport(s.CPU),
+		Memory:          newMetricReport(s.Mem),
+		CPUSeconds:      s.CPUSeconds,
+		DurationSeconds: s.Duration.Seconds(),
+		FirstSeen:       s.First,
+		LastSeen:        s.Last,
+	}
+}
+
+// name is the job and build number shown by the text formats
+func (j JobReport) name() string {
+	if j.BuildID == "" {
+		return j.Job
+	}
+	return j.Job + " #" + j.BuildID
+}
+
+func newMetricReport(d Distribution) MetricReport {
+	return MetricReport{Peak: d.Peak, PeakTime: d.PeakTime, Mean: d.Mean, P50: d.P50, P95: d.P95, P99: d.P99}
+}
+
+// section is one ranked list of a Report as rendered by the text formats
+type section struct {
+	name   string // "cpu" or "memory" in CSV output
+	title  string
+	jobs   []JobReport
+	metric func(JobReport) MetricReport
+}
+
+func (r *Report) sections() []section {
+	return []section{
+		{name: "cpu", title: fmt.Sprintf("Top %d Jobs by Peak CPU Usage", len(r.TopCPU)), jobs: r.TopCPU, metric: func(j JobReport) MetricReport { return j.CPU }},
+		{name: "memory", title: fmt.Sprintf("Top %d Jobs by Peak Memory Usage", len(r.TopMemory)), jobs: r.TopMemory, metric: func(j JobReport) MetricReport { return j.Memory }},
+	}
+}
+
+// reportColumns are the column names shared by the table, CSV and Markdown formats
+var reportColumns = []string{"JOB", "PEAK%", "MEAN%", "P50%", "P95%", "P99%", "SAMPLES", "CPU-SECONDS", "DURATION", "PEAK AT"}
+
+// Write renders the report in the requested format
+func (r *Report) Write(w io.Writer, f format.Format) error {
+	switch f {
+	case format.JSON:
+		return format.WriteJSON(w, r)
+	case format.CSV:
+		return r.writeCSV(w)
+	case format.Markdown:
+		r.writeMarkdown(w)
+		return nil
+	default:
+		r.writeTable(w)
+		return nil
+	}
+}
+
+func (r *Report) writeTable(w io.Writer) {
+	for _, s := range r.sections() {
+		fmt.Fprintln(w, s.title+":")
+		fmt.Fprintln(w, strings.Repeat("-", 150))
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%-45s\t%8s\t%8s\t%8s\t%8s\t%8s\t%8s\t%12s\t%10s\t%s\n",
+			reportColumns[0], reportColumns[1], reportColumns[2], reportColumns[3], reportColumns[4],
+			reportColumns[5], reportColumns[6], reportColumns[7], reportColumns[8], reportColumns[9])
+		for _, j := range s.jobs {
+			d := s.metric(j)
+			fmt.Fprintf(tw, "%-45s\t%8.2f\t%8.2f\t%8.2f\t%8.2f\t%8.2f\t%8d\t%12.1f\t%10s\t%s\n",
+				j.name(), d.Peak, d.Mean, d.P50, d.P95, d.P99, j.Samples,
+				j.CPUSeconds, formatSeconds(j.DurationSeconds), d.PeakTime.Format(time.RFC3339))
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "Jobs analyzed: %d\n", r.JobsAnalyzed)
+	if r.SkippedRows > 0 {
+		fmt.Fprintf(w, "Skipped malformed rows: %d\n", r.SkippedRows)
+	}
+	fmt.Fprintf(w, "Stats generated at: %s\n", r.GeneratedAt.Format(time.RFC1123))
+}
+
+func (r *Report) writeMarkdown(w io.Writer) {
+	for _, s := range r.sections() {
+		fmt.Fprintf(w, "### %s\n\n", s.title)
+		var rows [][]string
+		for _, j := range s.jobs {
+			d := s.metric(j)
+			rows = append(rows, []string{
+				"`" + j.name() + "`", formatFloat(d.Peak), formatFloat(d.Mean), formatFloat(d.P50), formatFloat(d.P95),
+				formatFloat(d.P99), strconv.Itoa(j.Samples), strconv.FormatFloat(j.CPUSeconds, 'f', 1, 64),
+				formatSeconds(j.DurationSeconds), d.PeakTime.Format(time.RFC3339),
+			})
+		}
+		format.WriteMarkdownTable(w, reportColumns, rows)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "_%d jobs analyzed, %d malformed rows skipped, generated at %s_\n", r.JobsAnalyzed, r.SkippedRows, r.GeneratedAt.Format(time.RFC1123))
+}
+
+// writeCSV writes one row per job and report; the header is stable and documented
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"report", "rank", "job", "build_id", "peak", "peak_time", "mean", "p50", "p95", "p99",
+		"samples", "cpu_seconds", "duration_seconds", "first_seen", "last_seen",
+	})
+	for _, s := range r.sections() {
+		for i, j := range s.jobs {
+			d := s.metric(j)
+			cw.Write([]string{
+				s.name, strconv.Itoa(i + 1), j.Job, j.BuildID, formatFloat(d.Peak), d.PeakTime.Format(time.RFC3339),
+				formatFloat(d.Mean), formatFloat(d.P50), formatFloat(d.P95), formatFloat(d.P99),
+				strconv.Itoa(j.Samples), strconv.FormatFloat(j.CPUSeconds, 'f', 1, 64),
+				strconv.FormatFloat(j.DurationSeconds, 'f', 0, 64),
+				j.FirstSeen.Format(time.RFC3339), j.LastSeen.Format(time.RFC3339),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func formatSeconds(s float64) string {
+	return (time.Duration(s) * time.Second).String()
+}
@@ -0,0 +1,79 @@
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"jenkins-monitor/internal/history"
+	"jenkins-monitor/internal/utils"
+)
+
+// progressMinBytes is the total input size from which progress is reported
+const progressMinBytes = 64 << 20
+
+// progressInterval is the minimum time between two progress lines
+const progressInterval = 2 * time.Second
+
+// progressReporter writes how much of the input has been read. A nil
+// reporter reports nothing, so small inputs stay quiet.
+type progressReporter struct {
+	w     io.Writer
+	sizes map[string]int64
+	total int64
+	done  int64 // bytes of the files already read
+	size  int64 // size of the file being read
+	last  time.Time
+	now   func() time.Time
+}
+
+// newProgressReporter returns a reporter for the given files, or nil if w is
+// nil or the files are too small to be worth reporting on
+func newProgressReporter(w io.Writer, files []string) *progressReporter {
+	if w == nil {
+		return nil
+	}
+	p := &progressReporter{w: w, sizes: make(map[string]int64), now: time.Now}
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			p.sizes[f] = info.Size()
+			p.total += info.Size()
+		}
+	}
+	if p.total < progressMinBytes {
+		return nil
+	}
+	p.last = p.now()
+	return p
+}
+
+// file returns the callback reporting progress through one input file
+func (p *progressReporter) file(path string) history.Progress {
+	if p == nil {
+		return nil
+	}
+	// The previous file is finished once the next one starts
+	p.done += p.size
+	p.size = p.sizes[path]
+	return func(read int64) {
+		if now := p.now(); now.Sub(p.last) >= progressInterval {
+			p.last = now
+			p.print(path, p.done+read)
+		}
+	}
+}
+
+// finish reports that every input has been read
+func (p *progressReporter) finish() {
+	if p == nil {
+		return
+	}
+	fmt.Fprintf(p.w, "Read %s of input\n", utils.FormatBytes(uint64(p.total)))
+}
+
+func (p *progressReporter) print(path string, read int64) {
+	fmt.Fprintf(p.w, "Reading %s: %s of %s (%.0f%%)\n", filepath.Base(path),
+		utils.FormatBytes(uint64(read)), utils.FormatBytes(uint64(p.total)), float64(read)/float64(p.total)*100)
+}
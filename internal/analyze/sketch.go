@@ -0,0 +1,82 @@
+package analyze
+
+import (
+	"math"
+	"sort"
+)
+
+// exactLimit is how many values a quantileSketch keeps verbatim; small jobs
+// get exact percentiles and only long histories are bucketed
+const exactLimit = 1024
+
+// sketchAccuracy is the relative error of percentiles once values are bucketed
+const sketchAccuracy = 0.01
+
+// sketchGamma is the ratio between the bounds of consecutive buckets
+var sketchGamma = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+
+// quantileSketch estimates percentiles in bounded memory. Values are stored
+// exactly up to exactLimit, then in logarithmically spaced buckets, so every
+// percentile is within sketchAccuracy of a value of the same rank. Usage
+// percentages span a few orders of magnitude, which keeps the number of
+// buckets in the hundreds.
+type quantileSketch struct {
+	exact   []float64
+	buckets map[int]int
+	zeros   int // values too small to bucket
+	count   int
+}
+
+func (s *quantileSketch) add(v float64) {
+	s.count++
+	if s.buckets == nil {
+		s.exact = append(s.exact, v)
+		if len(s.exact) <= exactLimit {
+			return
+		}
+		s.buckets = make(map[int]int)
+		for _, e := range s.exact {
+			s.bucket(e)
+		}
+		s.exact = nil
+		return
+	}
+	s.bucket(v)
+}
+
+func (s *quantileSketch) bucket(v float64) {
+	if v < 1e-9 {
+		s.zeros++
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(v)/math.Log(sketchGamma)))]++
+}
+
+// quantile returns the nearest-rank percentile p
+func (s *quantileSketch) quantile(p float64) float64 {
+	if s.buckets == nil {
+		sorted := append([]float64(nil), s.exact...)
+		sort.Float64s(sorted)
+		return percentile(sorted, p)
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(s.count)))
+	if rank <= s.zeros {
+		return 0
+	}
+	keys := make([]int, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	seen := s.zeros
+	for _, k := range keys {
+		seen += s.buckets[k]
+		if seen >= rank {
+			// The midpoint of the bucket in relative terms
+			return 2 * math.Pow(sketchGamma, float64(k)) / (sketchGamma + 1)
+		}
+	}
+	return 2 * math.Pow(sketchGamma, float64(keys[len(keys)-1])) / (sketchGamma + 1)
+}
@@ -0,0 +1,50 @@
+package analyze
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantileSketch(t *testing.T) {
+	testCases := []struct {
+		name  string
+		count int
+	}{
+		{name: "Exact below the limit", count: exactLimit},
+		{name: "Bucketed above the limit", count: 50000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			var s quantileSketch
+			values := make([]float64, tc.count)
+			for i := range values {
+				// Mostly idle with bursts, like CPU usage; a tenth of the samples are zero
+				v := rng.ExpFloat64() * 40
+				if i%10 == 0 {
+					v = 0
+				}
+				values[i] = v
+				s.add(v)
+			}
+			sort.Float64s(values)
+
+			for _, p := range []float64{50, 95, 99} {
+				want := percentile(values, p)
+				got := s.quantile(p)
+				if tc.count <= exactLimit && got != want {
+					t.Errorf("p%.0f = %v, want exactly %v", p, got, want)
+				}
+				if math.Abs(got-want) > want*sketchAccuracy {
+					t.Errorf("p%.0f = %v, want %v within %.0f%%", p, got, want, sketchAccuracy*100)
+				}
+			}
+			if tc.count > exactLimit && len(s.buckets) > 2000 {
+				t.Errorf("sketch uses %d buckets", len(s.buckets))
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"jenkins-monitor/internal/format"
+)
+
+// Group selects what analyze reports on
+type Group string
+
+// Supported --by values
+const (
+	GroupBuild     Group = "build"
+	GroupStage     Group = "stage"
+	GroupWorkspace Group = "workspace"
+)
+
+// ParseGroup validates a --by value
+func ParseGroup(s string) (Group, error) {
+	switch g := Group(strings.ToLower(s)); g {
+	case GroupBuild, GroupStage, GroupWorkspace:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown grouping %q: expected build, stage or workspace", s)
+	}
+}
+
+// noStage is how the text formats show processes that ran outside any stage
+const noStage = "(no stage)"
+
+// StageReport is the result of `analyze --by stage`: the usage of every
+// pipeline stage of the top jobs. Its JSON form is the documented, stable
+// schema of `analyze --by stage --format json`.
+type StageReport struct {
+	GeneratedAt  time.Time        `json:"generated_at"`
+	Since        *time.Time       `json:"since,omitempty"`
+	Until        *time.Time       `json:"until,omitempty"`
+	JobsAnalyzed int              `json:"jobs_analyzed"`
+	SkippedRows  int              `json:"skipped_rows"`
+	Jobs         []JobStageReport `json:"jobs"`
+}
+
+// JobStageReport is the stage breakdown of one job, merged over its builds
+type JobStageReport struct {
+	Job        string       `json:"job"`
+	Builds     int          `json:"builds"`
+	CPUSeconds float64      `json:"cpu_seconds"`
+	Stages     []StageStats `json:"stages"`
+}
+
+// StageStats is the usage of one stage of a job. Peaks are the highest of
+// any build, CPU-seconds and duration the totals over all builds.
+type StageStats struct {
+	Stage           string    `json:"stage"`
+	Builds          int       `json:"builds"`
+	Samples         int       `json:"samples"`
+	PeakCPU         float64   `json:"peak_cpu"`
+	PeakCPUTime     time.Time `json:"peak_cpu_time"`
+	MeanCPU         float64   `json:"mean_cpu"`
+	PeakMemory      float64   `json:"peak_memory"`
+	PeakMemoryTime  time.Time `json:"peak_memory_time"`
+	MeanMemory      float64   `json:"mean_memory"`
+	CPUSeconds      float64   `json:"cpu_seconds"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	FirstSeen       time.Time `json:"first_seen"`
+}
+
+// name is the stage shown by the text formats
+func (s StageStats) name() string {
+	if s.Stage == "" {
+		return noStage
+	}
+	return s.Stage
+}
+
+// add merges the statistics of the stage in one build
+func (s *StageStats) add(st JobStats) {
+	if s.Builds == 0 || st.First.Before(s.FirstSeen) {
+		s.FirstSeen = st.First
+	}
+	if s.Builds == 0 || st.CPU.Peak > s.PeakCPU {
+		s.PeakCPU, s.PeakCPUTime = st.CPU.Peak, st.CPU.PeakTime
+	}
+	if s.Builds == 0 || st.Mem.Peak > s.PeakMemory {
+		s.PeakMemory, s.PeakMemoryTime = st.Mem.Peak, st.Mem.PeakTime
+	}
+	// Means are weighted by the number of samples of each build
+	total := float64(s.Samples + st.Samples)
+	s.MeanCPU = (s.MeanCPU*float64(s.Samples) + st.CPU.Mean*float64(st.Samples)) / total
+	s.MeanMemory = (s.MeanMemory*float64(s.Samples) + st.Mem.Mean*float64(st.Samples)) / total
+
+	s.Builds++
+	s.Samples += st.Samples
+	s.CPUSeconds += st.CPUSeconds
+	s.DurationSeconds += st.Duration.Seconds()
+}
+
+// newStageReport merges the per-build stage statistics of the analyzer into
+// one breakdown per job, keeping the jobs with the most CPU-seconds
+func newStageReport(a *analyzer, opts Options) *StageReport {
+	type jobStages struct {
+		report JobStageReport
+		stages map[string]*StageStats
+		builds map[string]bool
+	}
+	byJob := make(map[string]*jobStages)
+	for key, acc := range a.builds {
+		j, ok := byJob[key.Job]
+		if !ok {
+			j = &jobStages{
+				report: JobStageReport{Job: key.Job},
+				stages: make(map[string]*StageStats),
+				builds: make(map[string]bool),
+			}
+			byJob[key.Job] = j
+		}
+		s, ok := j.stages[key.Stage]
+		if !ok {
+			s = &StageStats{Stage: key.Stage}
+			j.stages[key.Stage] = s
+		}
+		st := acc.stats()
+		s.add(st)
+		j.builds[key.BuildID] = true
+		j.report.CPUSeconds += st.CPUSeconds
+	}
+
+	report := &StageReport{
+		GeneratedAt:  time.Now(),
+		JobsAnalyzed: len(byJob),
+		SkippedRows:  a.skipped,
+		Jobs:         []JobStageReport{},
+	}
+	if !opts.Since.IsZero() {
+		report.Since = &opts.Since
+	}
+	if !opts.Until.IsZero() {
+		report.Until = &opts.Until
+	}
+
+	for _, j := range byJob {
+		j.report.Builds = len(j.builds)
+		for _, s := range j.stages {
+			j.report.Stages = append(j.report.Stages, *s)
+		}
+		// Stages in pipeline order, as far as the first build seen tells
+		sort.Slice(j.report.Stages, func(a, b int) bool {
+			sa, sb := j.report.Stages[a], j.report.Stages[b]
+			if !sa.FirstSeen.Equal(sb.FirstSeen) {
+				return sa.FirstSeen.Before(sb.FirstSeen)
+			}
+			return sa.Stage < sb.Stage
+		})
+		report.Jobs = append(report.Jobs, j.report)
+	}
+	sort.Slice(report.Jobs, func(a, b int) bool {
+		if report.Jobs[a].CPUSeconds != report.Jobs[b].CPUSeconds {
+			return report.Jobs[a].CPUSeconds > report.Jobs[b].CPUSeconds
+		}
+		return report.Jobs[a].Job < report.Jobs[b].Job
+	})
+	if len(report.Jobs) > opts.Top {
+		report.Jobs = report.Jobs[:opts.Top]
+	}
+	return report
+}
+
+// stageColumns are the column names shared by the table, CSV and Markdown formats
+var stageColumns = []string{"STAGE", "BUILDS", "PEAK CPU%", "MEAN CPU%", "PEAK MEM%", "MEAN MEM%", "CPU-SECONDS", "DURATION", "PEAK MEM AT"}
+
+// Write renders the report in the requested format
+func (r *StageReport) Write(w io.Writer, f format.Format) error {
+	switch f {
+	case format.JSON:
+		return format.WriteJSON(w, r)
+	case format.CSV:
+		return r.writeCSV(w)
+	case format.Markdown:
+		r.writeMarkdown(w)
+		return nil
+	default:
+		r.writeTable(w)
+		return nil
+	}
+}
+
+func (r *StageReport) writeTable(w io.Writer) {
+	for _, j := range r.Jobs {
+		fmt.Fprintf(w, "Stages of %s (%d builds, %.1f CPU-seconds):\n", j.Job, j.Builds, j.CPUSeconds)
+		fmt.Fprintln(w, strings.Repeat("-", 130))
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%-35s\t%6s\t%9s\t%9s\t%9s\t%9s\t%12s\t%10s\t%s\n",
+			stageColumns[0], stageColumns[1], stageColumns[2], stageColumns[3], stageColumns[4],
+			stageColumns[5], stageColumns[6], stageColumns[7], stageColumns[8])
+		for _, s := range j.Stages {
+			fmt.Fprintf(tw, "%-35s\t%6d\t%9.2f\t%9.2f\t%9.2f\t%9.2f\t%12.1f\t%10s\t%s\n",
+				s.name(), s.Builds, s.PeakCPU, s.MeanCPU, s.PeakMemory, s.MeanMemory,
+				s.CPUSeconds, formatSeconds(s.DurationSeconds), s.PeakMemoryTime.Format(time.RFC3339))
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "Jobs analyzed: %d\n", r.JobsAnalyzed)
+	if r.SkippedRows > 0 {
+		fmt.Fprintf(w, "Skipped malformed rows: %d\n", r.SkippedRows)
+	}
+	fmt.Fprintf(w, "Stats generated at: %s\n", r.GeneratedAt.Format(time.RFC1123))
+}
+
+func (r *StageReport) writeMarkdown(w io.Writer) {
+	for _, j := range r.Jobs {
+		fmt.Fprintf(w, "### Stages of `%s` (%d builds, %.1f CPU-seconds)\n\n", j.Job, j.Builds, j.CPUSeconds)
+		var rows [][]string
+		for _, s := range j.Stages {
+			rows = append(rows, []string{
+				s.name(), strconv.Itoa(s.Builds), formatFloat(s.PeakCPU), formatFloat(s.MeanCPU),
+				formatFloat(s.PeakMemory), formatFloat(s.MeanMemory), strconv.FormatFloat(s.CPUSeconds, 'f', 1, 64),
+				formatSeconds(s.DurationSeconds), s.PeakMemoryTime.Format(time.RFC3339),
+			})
+		}
+		format.WriteMarkdownTable(w, stageColumns, rows)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "_%d jobs analyzed, %d malformed rows skipped, generated at %s_\n", r.JobsAnalyzed, r.SkippedRows, r.GeneratedAt.Format(time.RFC1123))
+}
+
+// writeCSV writes one row per job and stage; the header is stable and documented
+func (r *StageReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"job", "stage", "builds", "samples", "peak_cpu", "peak_cpu_time", "mean_cpu", "peak_memory",
+		"peak_memory_time", "mean_memory", "cpu_seconds", "duration_seconds", "first_seen",
+	})
+	for _, j := range r.Jobs {
+		for _, s := range j.Stages {
+			cw.Write([]string{
+				j.Job, s.Stage, strconv.Itoa(s.Builds), strconv.Itoa(s.Samples),
+				formatFloat(s.PeakCPU), s.PeakCPUTime.Format(time.RFC3339), formatFloat(s.MeanCPU),
+				formatFloat(s.PeakMemory), s.PeakMemoryTime.Format(time.RFC3339), formatFloat(s.MeanMemory),
+				strconv.FormatFloat(s.CPUSeconds, 'f', 1, 64), strconv.FormatFloat(s.DurationSeconds, 'f', 0, 64),
+				s.FirstSeen.Format(time.RFC3339),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
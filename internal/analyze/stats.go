@@ -0,0 +1,141 @@
+package analyze
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// maxSampleGap is the longest gap between two samples of a job that is still
+// counted as the job running; longer gaps are treated as separate runs
+const maxSampleGap = 5 * time.Minute
+
+// Sample is the usage of one job's whole process tree at one monitor tick
+type Sample struct {
+	Time time.Time
+	CPU  float64
+	Mem  float64
+}
+
+// Distribution summarises one metric of a job
+type Distribution struct {
+	Peak     float64
+	PeakTime time.Time
+	Mean     float64
+	P50      float64
+	P95      float64
+	P99      float64
+}
+
+// JobStats holds the statistics of one build reported by analyze. Files
+// written before build IDs were recorded yield one entry per job with an
+// empty BuildID.
+type JobStats struct {
+	Job        string
+	BuildID    string
+	Samples    int
+	CPU        Distribution
+	Mem        Distribution
+	CPUSeconds float64       // CPU time integrated over the observed samples
+	Duration   time.Duration // observed wall-clock time the job was running
+	First      time.Time
+	Last       time.Time
+}
+
+// computeStats derives the statistics of a build from its samples
+func computeStats(job, buildID string, samples []Sample) JobStats {
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+
+	acc := newJobAccumulator(job, buildID)
+	for _, s := range samples {
+		acc.add(s)
+	}
+	return acc.stats()
+}
+
+// jobAccumulator computes the statistics of a build in one pass over its
+// samples, which are expected in time order, without keeping them
+type jobAccumulator struct {
+	summary JobStats
+	cpu     distributionAccumulator
+	mem     distributionAccumulator
+	prev    time.Time
+}
+
+func newJobAccumulator(job, buildID string) *jobAccumulator {
+	return &jobAccumulator{summary: JobStats{Job: job, BuildID: buildID}}
+}
+
+// add accounts for the next sample of the build
+func (a *jobAccumulator) add(s Sample) {
+	st := &a.summary
+	if st.Samples == 0 || s.Time.Before(st.First) {
+		st.First = s.Time
+	}
+	if st.Samples == 0 || s.Time.After(st.Last) {
+		st.Last = s.Time
+	}
+	st.Samples++
+	a.cpu.add(s.CPU, s.Time)
+	a.mem.add(s.Mem, s.Time)
+
+	// Each sample stands for the usage since the previous one
+	if !a.prev.IsZero() {
+		gap := s.Time.Sub(a.prev)
+		if gap > 0 && gap <= maxSampleGap {
+			st.Duration += gap
+			st.CPUSeconds += s.CPU / 100 * gap.Seconds()
+		}
+	}
+	a.prev = s.Time
+}
+
+// stats returns the statistics of the samples added so far
+func (a *jobAccumulator) stats() JobStats {
+	st := a.summary
+	if st.Samples > 0 {
+		st.CPU = a.cpu.distribution()
+		st.Mem = a.mem.distribution()
+	}
+	return st
+}
+
+// distributionAccumulator tracks the peak, mean and percentiles of one metric
+type distributionAccumulator struct {
+	peak     float64
+	peakTime time.Time
+	sum      float64
+	sketch   quantileSketch
+}
+
+func (d *distributionAccumulator) add(v float64, t time.Time) {
+	if d.sketch.count == 0 || v > d.peak {
+		d.peak = v
+		d.peakTime = t
+	}
+	d.sum += v
+	d.sketch.add(v)
+}
+
+func (d *distributionAccumulator) distribution() Distribution {
+	return Distribution{
+		Peak:     d.peak,
+		PeakTime: d.peakTime,
+		Mean:     d.sum / float64(d.sketch.count),
+		P50:      d.sketch.quantile(50),
+		P95:      d.sketch.quantile(95),
+		P99:      d.sketch.quantile(99),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
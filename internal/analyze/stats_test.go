@@ -0,0 +1,72 @@
+package analyze
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	samples := []Sample{
+		{Time: at(60 * time.Second), CPU: 100, Mem: 20},
+		{Time: at(0), CPU: 10, Mem: 10},
+		{Time: at(30 * time.Second), CPU: 200, Mem: 30},
+		// A gap longer than maxSampleGap starts a new run and adds no time
+		{Time: at(time.Hour), CPU: 50, Mem: 40},
+	}
+
+	got := computeStats("app", "", samples)
+
+	if got.Samples != 4 {
+		t.Errorf("Samples = %d, want 4", got.Samples)
+	}
+	if got.CPU.Peak != 200 || !got.CPU.PeakTime.Equal(at(30*time.Second)) {
+		t.Errorf("CPU peak = %.0f at %s, want 200 at +30s", got.CPU.Peak, got.CPU.PeakTime)
+	}
+	if got.CPU.Mean != 90 {
+		t.Errorf("CPU mean = %.2f, want 90", got.CPU.Mean)
+	}
+	if got.CPU.P50 != 50 || got.CPU.P95 != 200 || got.CPU.P99 != 200 {
+		t.Errorf("CPU percentiles = %.0f/%.0f/%.0f, want 50/200/200", got.CPU.P50, got.CPU.P95, got.CPU.P99)
+	}
+	if got.Mem.Peak != 40 {
+		t.Errorf("Mem peak = %.0f, want 40", got.Mem.Peak)
+	}
+	// 30s at 200% + 30s at 100%
+	if got.CPUSeconds != 90 {
+		t.Errorf("CPUSeconds = %.1f, want 90", got.CPUSeconds)
+	}
+	if got.Duration != time.Minute {
+		t.Errorf("Duration = %s, want 1m0s", got.Duration)
+	}
+}
+
+func TestParseTimeArg(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "", want: time.Time{}},
+		{value: "2024-05-01T08:00:00Z", want: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)},
+		{value: "2024-05-01", want: time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{value: "24h", want: now.Add(-24 * time.Hour)},
+		{value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTimeArg(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeArg(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseTimeArg(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"jenkins-monitor/internal/format"
+	"jenkins-monitor/internal/history"
+	"jenkins-monitor/internal/process"
+	"jenkins-monitor/internal/utils"
+	"jenkins-monitor/internal/workspace"
+)
+
+// WorkspaceReport is the result of `analyze --by workspace`: the largest and
+// fastest growing workspaces in the workspace samples files. Its JSON form is
+// the documented, stable schema of `analyze --by workspace --format json`.
+type WorkspaceReport struct {
+	GeneratedAt        time.Time        `json:"generated_at"`
+	Since              *time.Time       `json:"since,omitempty"`
+	Until              *time.Time       `json:"until,omitempty"`
+	WorkspacesAnalyzed int              `json:"workspaces_analyzed"`
+	SkippedRows        int              `json:"skipped_rows"`
+	TopSize            []WorkspaceStats `json:"top_size"`
+	TopGrowth          []WorkspaceStats `json:"top_growth"`
+}
+
+// WorkspaceStats is the history of one workspace on one agent
+type WorkspaceStats struct {
+	Host      string `json:"host"`
+	Workspace string `json:"workspace"`
+	// LastJob is the job of the last build seen using the workspace
+	LastJob        string    `json:"last_job"`
+	Builds         int       `json:"builds"`
+	Samples        int       `json:"samples"`
+	PeakBytes      uint64    `json:"peak_bytes"`
+	PeakTime       time.Time `json:"peak_time"`
+	LastBytes      uint64    `json:"last_bytes"`
+	PeakGrowth     float64   `json:"peak_growth_bytes_per_second"`
+	PeakGrowthTime time.Time `json:"peak_growth_time"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+
+	builds map[string]bool
+}
+
+// add folds one sample into the statistics
+func (s *WorkspaceStats) add(sample workspace.Sample) {
+	if s.Samples == 0 || sample.Time.Before(s.FirstSeen) {
+		s.FirstSeen = sample.Time
+	}
+	if s.Samples == 0 || !sample.Time.Before(s.LastSeen) {
+		s.LastSeen, s.LastBytes, s.LastJob = sample.Time, sample.Bytes, sample.JobName
+	}
+	if s.Samples == 0 || sample.Bytes > s.PeakBytes {
+		s.PeakBytes, s.PeakTime = sample.Bytes, sample.Time
+	}
+	if sample.Growth > s.PeakGrowth {
+		s.PeakGrowth, s.PeakGrowthTime = sample.Growth, sample.Time
+	}
+	s.builds[process.BuildKey(sample.JobName, sample.BuildID)] = true
+	s.Builds = len(s.builds)
+	s.Samples++
+}
+
+// runWorkspaceReport reads workspace samples files and prints the top workspaces
+func runWorkspaceReport(inputFiles []string, opts Options) {
+	report, err := newWorkspaceReport(inputFiles, opts)
+	if err != nil {
+		utils.Fatal(err.Error())
+	}
+	if report.WorkspacesAnalyzed == 0 && opts.Format == format.Table {
+		fmt.Println("No workspace samples to analyze.")
+		return
+	}
+	if err := report.Write(os.Stdout, opts.Format); err != nil {
+		utils.Fatal(fmt.Sprintf("Failed to write report: %v", err))
+	}
+}
+
+// newWorkspaceReport reads the samples of every input file that match opts
+// and ranks the workspaces by peak size and peak growth
+func newWorkspaceReport(inputFiles []string, opts Options) (*WorkspaceReport, error) {
+	q := history.Query{Since: opts.Since, Until: opts.Until, Job: opts.Job}
+	byWorkspace := make(map[string]*WorkspaceStats)
+	report := &WorkspaceReport{GeneratedAt: time.Now(), TopSize: []WorkspaceStats{}, TopGrowth: []WorkspaceStats{}}
+
+	for _, path := range inputFiles {
+		skipped, err := readWorkspaceSamples(path, func(s workspace.Sample) {
+			if !q.Matches(history.Record{Time: s.Time, JobName: s.JobName}) {
+				return
+			}
+			key := s.Host + "\xff" + s.Path
+			st, ok := byWorkspace[key]
+			if !ok {
+				st = &WorkspaceStats{Host: s.Host, Workspace: s.Path, builds: make(map[string]bool)}
+				byWorkspace[key] = st
+			}
+			st.add(s)
+		})
+		report.SkippedRows += skipped
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read input file: %v", err)
+		}
+	}
+
+	if !opts.Since.IsZero() {
+		report.Since = &opts.Since
+	}
+	if !opts.Until.IsZero() {
+		report.Until = &opts.Until
+	}
+	var all []WorkspaceStats
+	for _, st := range byWorkspace {
+		all = append(all, *st)
+	}
+	report.WorkspacesAnalyzed = len(all)
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].PeakBytes != all[j].PeakBytes {
+			return all[i].PeakBytes > all[j].PeakBytes
+		}
+		return all[i].Workspace < all[j].Workspace
+	})
+	report.TopSize = append(report.TopSize, all[:min(opts.Top, len(all))]...)
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].PeakGrowth != all[j].PeakGrowth {
+			return all[i].PeakGrowth > all[j].PeakGrowth
+		}
+		return all[i].Workspace < all[j].Workspace
+	})
+	for _, st := range all {
+		// Workspaces that never grew are not worth listing
+		if st.PeakGrowth <= 0 || len(report.TopGrowth) == opts.Top {
+			break
+		}
+		report.TopGrowth = append(report.TopGrowth, st)
+	}
+	return report, nil
+}
+
+// readWorkspaceSamples calls fn for every sample of a workspace samples file
+// and returns the number of malformed rows skipped
+func readWorkspaceSamples(path string, fn func(workspace.Sample)) (int, error) {
+	f, err := history.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r, err := workspace.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	skipped := 0
+	for {
+		s, err := r.Read()
+		if err == io.EOF {
+			return skipped, nil
+		}
+		if errors.Is(err, workspace.ErrMalformed) {
+			skipped++
+			continue
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("%s: %w", path, err)
+		}
+		fn(s)
+	}
+}
+
+// workspaceColumns are the column names shared by the table, CSV and Markdown formats
+var workspaceColumns = []string{"WORKSPACE", "HOST", "LAST JOB", "BUILDS", "PEAK SIZE", "LAST SIZE", "PEAK GROWTH", "PEAK AT"}
+
+// workspaceSection is one ranked list of a WorkspaceReport as rendered by the text formats
+type workspaceSection struct {
+	name  string // "size" or "growth" in CSV output
+	title string
+	stats []WorkspaceStats
+}
+
+func (r *WorkspaceReport) sections() []workspaceSection {
+	return []workspaceSection{
+		{name: "size", title: fmt.Sprintf("Top %d Workspaces by Peak Size", len(r.TopSize)), stats: r.TopSize},
+		{name: "growth", title: fmt.Sprintf("Top %d Workspaces by Peak Growth", len(r.TopGrowth)), stats: r.TopGrowth},
+	}
+}
+
+// row renders the statistics for the text formats
+func (s WorkspaceStats) row() []string {
+	return []string{
+		s.Workspace, s.Host, s.LastJob, strconv.Itoa(s.Builds), utils.FormatBytes(s.PeakBytes),
+		utils.FormatBytes(s.LastBytes), formatGrowth(s.PeakGrowth), s.PeakTime.Format(time.RFC3339),
+	}
+}
+
+// formatGrowth renders a growth rate in bytes per second as a size per minute,
+// the unit of the workspace_growth_mb_per_min threshold
+func formatGrowth(bytesPerSecond float64) string {
+	return utils.FormatBytes(uint64(max(bytesPerSecond, 0)*60)) + "/min"
+}
+
+// Write renders the report in the requested format
+func (r *WorkspaceReport) Write(w io.Writer, f format.Format) error {
+	switch f {
+	case format.JSON:
+		return format.WriteJSON(w, r)
+	case format.CSV:
+		return r.writeCSV(w)
+	case format.Markdown:
+		r.writeMarkdown(w)
+		return nil
+	default:
+		r.writeTable(w)
+		return nil
+	}
+}
+
+func (r *WorkspaceReport) writeTable(w io.Writer) {
+	for _, s := range r.sections() {
+		fmt.Fprintln(w, s.title+":")
+		fmt.Fprintln(w, strings.Repeat("-", 150))
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(workspaceColumns, "\t"))
+		for _, st := range s.stats {
+			fmt.Fprintln(tw, strings.Join(st.row(), "\t"))
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "Workspaces analyzed: %d\n", r.WorkspacesAnalyzed)
+	if r.SkippedRows > 0 {
+		fmt.Fprintf(w, "Skipped malformed rows: %d\n", r.SkippedRows)
+	}
+	fmt.Fprintf(w, "Stats generated at: %s\n", r.GeneratedAt.Format(time.RFC1123))
+}
+
+func (r *WorkspaceReport) writeMarkdown(w io.Writer) {
+	for _, s := range r.sections() {
+		fmt.Fprintf(w, "### %s\n\n", s.title)
+		var rows [][]string
+		for _, st := range s.stats {
+			row := st.row()
+			row[0] = "`" + row[0] + "`"
+			rows = append(rows, row)
+		}
+		format.WriteMarkdownTable(w, workspaceColumns, rows)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "_%d workspaces analyzed, %d malformed rows skipped, generated at %s_\n", r.WorkspacesAnalyzed, r.SkippedRows, r.GeneratedAt.Format(time.RFC1123))
+}
+
+// writeCSV writes one row per workspace and ranking; the header is stable and documented
+func (r *WorkspaceReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"report", "rank", "workspace", "host", "last_job", "builds", "samples", "peak_bytes", "peak_time",
+		"last_bytes", "peak_growth_bytes_per_second", "peak_growth_time", "first_seen", "last_seen",
+	})
+	for _, s := range r.sections() {
+		for i, st := range s.stats {
+			cw.Write([]string{
+				s.name, strconv.Itoa(i + 1), st.Workspace, st.Host, st.LastJob, strconv.Itoa(st.Builds), strconv.Itoa(st.Samples),
+				strconv.FormatUint(st.PeakBytes, 10), st.PeakTime.Format(time.RFC3339), strconv.FormatUint(st.LastBytes, 10),
+				formatFloat(st.PeakGrowth), st.PeakGrowthTime.Format(time.RFC3339),
+				st.FirstSeen.Format(time.RFC3339), st.LastSeen.Format(time.RFC3339),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/history"
+	"jenkins-monitor/internal/process"
+)
+
+func newBuild(job, id string, procs ...process.ProcessInfo) process.BuildInfo {
+	b := process.BuildInfo{BuildJobName: job, BuildId: id}
+	for _, p := range procs {
+		p.BuildJobName, p.BuildId = job, id
+		b.Processes = append(b.Processes, process.BuildProcess{ProcessInfo: p})
+		b.CPU += p.CPU
+		b.RSS += p.RSS
+	}
+	return b
+}
+
+// get serves one request and decodes the JSON response into v
+func get(t *testing.T, mux *http.ServeMux, url string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("GET %s: Content-Type = %q", url, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: decode %q: %v", url, rec.Body.String(), err)
+	}
+	return rec.Code
+}
+
+func TestServer(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	state := NewState(config.APIConfig{RecentAlerts: 2, HistoryWindow: time.Hour}, start)
+	srv := NewServer(state, "")
+	srv.now = func() time.Time { return now }
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	var health Health
+	if code := get(t, mux, "/readyz", &health); code != http.StatusServiceUnavailable || health.Status != "not_ready" {
+		t.Errorf("readyz before the first collection = %d %+v, want not ready", code, health)
+	}
+	if code := get(t, mux, "/healthz", &health); code != http.StatusOK || health.Status != "ok" || health.LastCollection != nil {
+		t.Errorf("healthz = %d %+v, want ok without a collection", code, health)
+	}
+
+	app := newBuild("app", "1",
+		process.ProcessInfo{PID: 10, Name: "java", CPU: 20, RSS: 100},
+		process.ProcessInfo{PID: 11, Name: "javac", CPU: 150, RSS: 50})
+	web := newBuild("web", "4", process.ProcessInfo{PID: 20, Name: "node", CPU: 60, RSS: 10})
+	state.Collected([]process.BuildInfo{web, app}, 10*time.Second, now)
+	now = now.Add(10 * time.Second)
+	state.Collected([]process.BuildInfo{web, app}, 10*time.Second, now)
+	for i, typ := range []string{alert.CPUHigh, alert.MemHigh, alert.RSSHigh} {
+		state.AddAlert(alert.Event{Type: typ, State: alert.Firing, Build: app, Time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	if code := get(t, mux, "/readyz", &health); code != http.StatusOK || health.Builds != 2 || health.Processes != 3 {
+		t.Errorf("readyz = %d %+v, want ready with 2 builds and 3 processes", code, health)
+	}
+
+	var builds struct {
+		BuildCount int `json:"build_count"`
+		Builds     []struct {
+			Job       string            `json:"job"`
+			Processes []json.RawMessage `json:"processes"`
+		} `json:"builds"`
+	}
+	get(t, mux, "/api/v1/builds", &builds)
+	if builds.BuildCount != 2 || builds.Builds[0].Job != "app" || builds.Builds[0].Processes != nil {
+		t.Errorf("builds = %+v, want app first without processes", builds)
+	}
+	get(t, mux, "/api/v1/builds?job=web&processes=true", &builds)
+	if builds.BuildCount != 1 || len(builds.Builds[0].Processes) != 1 {
+		t.Errorf("builds of web = %+v, want one build with its process", builds)
+	}
+
+	var procs Processes
+	get(t, mux, "/api/v1/processes?limit=2", &procs)
+	if procs.ProcessCount != 2 || procs.Processes[0].PID != 11 || procs.Processes[1].PID != 20 || procs.Processes[0].Job != "app" {
+		t.Errorf("processes = %+v, want javac then node", procs)
+	}
+
+	var alerts Alerts
+	get(t, mux, "/api/v1/alerts", &alerts)
+	if len(alerts.Alerts) != 2 || alerts.Alerts[0].Type != alert.RSSHigh || alerts.Alerts[1].Type != alert.MemHigh {
+		t.Errorf("alerts = %+v, want the last two, newest first", alerts)
+	}
+
+	var hist History
+	get(t, mux, "/api/v1/history?job=app&since=1h", &hist)
+	if hist.Source != SourceMemory || len(hist.Builds) != 1 || len(hist.Builds[0].Points) != 2 || hist.Builds[0].Points[0].CPUPercent != 170 {
+		t.Errorf("history = %+v, want two in-memory points of app #1", hist)
+	}
+
+	var errResp map[string]string
+	for _, url := range []string{"/api/v1/history", "/api/v1/history?job=app&since=yesterday", "/api/v1/history?job=app&source=output_files",
+		"/api/v1/processes?limit=-1", "/api/v1/builds?processes=maybe"} {
+		if code := get(t, mux, url, &errResp); code != http.StatusBadRequest || errResp["error"] == "" {
+			t.Errorf("GET %s = %d %v, want a 400 with an error", url, code, errResp)
+		}
+	}
+
+	if code := get(t, mux, "/api/v1/report", &errResp); code != http.StatusNotFound {
+		t.Errorf("report without data files = %d %v, want 404", code, errResp)
+	}
+
+	now = now.Add(31 * time.Second)
+	if code := get(t, mux, "/readyz", &health); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz after three missed intervals = %d, want 503", code)
+	}
+}
+
+func TestStateHistoryWindow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	state := NewState(config.APIConfig{HistoryWindow: time.Hour}, start)
+	app := newBuild("app", "1", process.ProcessInfo{PID: 10, CPU: 50})
+	web := newBuild("web", "2", process.ProcessInfo{PID: 20, CPU: 10})
+
+	state.Collected([]process.BuildInfo{app, web}, time.Minute, start)
+	state.Collected([]process.BuildInfo{app}, time.Minute, start.Add(30*time.Minute))
+	state.Collected([]process.BuildInfo{app}, time.Minute, start.Add(70*time.Minute))
+
+	if _, ok := state.history[web.Key()]; ok {
+		t.Error("history of a build without samples in the window was kept")
+	}
+	if h := state.history[app.Key()]; h == nil || len(h.points) != 2 || !h.points[0].Time.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("history of app = %+v, want the last two samples", h)
+	}
+}
+
+// writeDataFile writes records to a CSV data file
+func writeDataFile(t *testing.T, records []history.Record) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "processes.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := history.WriteHeader(file); err != nil {
+		t.Fatal(err)
+	}
+	w := csv.NewWriter(file)
+	for _, rec := range records {
+		w.Write(rec.Fields())
+	}
+	w.Flush()
+	file.Close()
+	return path
+}
+
+func TestHistoryFromFiles(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	path := writeDataFile(t, []history.Record{
+		{Time: start, JobName: "app", BuildID: "1", PID: 10, CPU: 20, Mem: 1, RSS: 100},
+		{Time: start, JobName: "app", BuildID: "1", PID: 11, CPU: 30, Mem: 2, RSS: 200},
+		{Time: start, JobName: "app-lint", BuildID: "1", PID: 12, CPU: 5},
+		{Time: start.Add(time.Minute), JobName: "app", BuildID: "1", PID: 10, CPU: 40, RSS: 100},
+		{Time: start.Add(time.Minute), JobName: "app", BuildID: "2", PID: 30, CPU: 90, RSS: 50},
+		{Time: start.Add(2 * time.Hour), JobName: "app", BuildID: "2", PID: 30, CPU: 90},
+	})
+
+	h, err := historyFromFiles(path, HistoryQuery{Job: "app", Since: start, Until: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("historyFromFiles() error = %v", err)
+	}
+	if h.Source != SourceFiles || len(h.Builds) != 2 {
+		t.Fatalf("history = %+v, want builds 1 and 2 of app", h)
+	}
+	b1 := h.Builds[0]
+	if b1.BuildID != "1" || len(b1.Points) != 2 || b1.Points[0].CPUPercent != 50 || b1.Points[0].RSSBytes != 300 || b1.Points[0].Processes != 2 {
+		t.Errorf("build 1 = %+v, want two samples summing both processes", b1)
+	}
+	if b2 := h.Builds[1]; b2.BuildID != "2" || len(b2.Points) != 1 {
+		t.Errorf("build 2 = %+v, want the sample within the range", b2)
+	}
+
+	h, err = historyFromFiles(path, HistoryQuery{Job: "app", BuildID: "2", Since: start})
+	if err != nil || len(h.Builds) != 1 || len(h.Builds[0].Points) != 2 {
+		t.Errorf("history of build 2 = %+v, %v, want both of its samples", h, err)
+	}
+}
+
+func TestDataFiles(t *testing.T) {
+	dir := t.TempDir()
+	since := time.Date(2024, 6, 10, 12, 0, 0, 0, time.Local)
+	current := filepath.Join(dir, "processes.csv")
+	files := map[string]time.Time{
+		current: since.Add(time.Hour),
+		// Named after a day that ended before since, compressed later
+		filepath.Join(dir, "processes.2024-06-09.csv.gz"): since.Add(time.Hour),
+		// Last written before since
+		filepath.Join(dir, "processes.2024-06-10.csv"):   since.Add(-time.Minute),
+		filepath.Join(dir, "processes.2024-06-10.1.csv"): since.Add(time.Minute),
+	}
+	for path, modTime := range files {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := dataFiles(current, since)
+	if err != nil {
+		t.Fatalf("dataFiles() error = %v", err)
+	}
+	want := []string{filepath.Join(dir, "processes.2024-06-10.1.csv"), current}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("dataFiles() = %v, want %v", got, want)
+	}
+	if got, _ := dataFiles(current, time.Time{}); len(got) != 4 {
+		t.Errorf("dataFiles() without since = %v, want every file", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	path := writeDataFile(t, []history.Record{
+		{Time: now.Add(-2 * time.Minute), JobName: "app", BuildID: "1", PID: 10, CPU: 80, Mem: 5},
+		{Time: now.Add(-time.Minute), JobName: "app", BuildID: "1", PID: 10, CPU: 60, Mem: 5},
+		{Time: now.Add(-2 * time.Minute), JobName: "web", BuildID: "3", PID: 20, CPU: 10, Mem: 40},
+		{Time: now.Add(-48 * time.Hour), JobName: "old", BuildID: "9", PID: 30, CPU: 99, Mem: 99},
+	})
+	srv := NewServer(NewState(config.APIConfig{}, now), path)
+	mux := http.NewServeMux()
+	srv.Register(mux)
+
+	var report struct {
+		JobsAnalyzed int `json:"jobs_analyzed"`
+		TopCPU       []struct {
+			Job string `json:"job"`
+		} `json:"top_cpu"`
+		TopMemory []struct {
+			Job string `json:"job"`
+		} `json:"top_memory"`
+	}
+	if code := get(t, mux, "/api/v1/report?top=1", &report); code != http.StatusOK {
+		t.Fatalf("report = %d", code)
+	}
+	if report.JobsAnalyzed != 2 || len(report.TopCPU) != 1 || report.TopCPU[0].Job != "app" || report.TopMemory[0].Job != "web" {
+		t.Errorf("report = %+v, want app and web of the last day, top one each", report)
+	}
+	if len(srv.reports.entries) != 1 {
+		t.Errorf("cached reports = %d, want 1", len(srv.reports.entries))
+	}
+
+	var errResp map[string]string
+	for _, url := range []string{"/api/v1/report?top=0", "/api/v1/report?job=(", "/api/v1/report?until=soon"} {
+		if code := get(t, mux, url, &errResp); code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d %v, want 400", url, code, errResp)
+		}
+	}
+}
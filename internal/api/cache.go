@@ -0,0 +1,71 @@
+package api
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"jenkins-monitor/internal/history"
+)
+
+// fileCache serialises the queries of one endpoint that read the data files
+// and reuses their results for the same parameters, so clients polling the
+// endpoint cannot pile up reads of every data file
+type fileCache[T any] struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedResult[T]
+}
+
+type cachedResult[T any] struct {
+	value T
+	at    time.Time
+}
+
+func newFileCache[T any](ttl time.Duration) *fileCache[T] {
+	return &fileCache[T]{ttl: ttl, entries: make(map[string]cachedResult[T])}
+}
+
+// get returns the result cached under key, or builds and caches it. Relative
+// ranges move with time, so keys are the raw query parameters.
+func (c *fileCache[T]) get(key string, now time.Time, build func() (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if now.Sub(e.at) >= c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	if e, ok := c.entries[key]; ok {
+		return e.value, nil
+	}
+	v, err := build()
+	if err != nil {
+		return v, err
+	}
+	c.entries[key] = cachedResult[T]{value: v, at: now}
+	return v, nil
+}
+
+// dataFiles returns the data file and those of its rotated copies that may
+// hold samples at or after since: a copy is skipped when the day in its name
+// ended, or it was last written, before since
+func dataFiles(dataFile string, since time.Time) ([]string, error) {
+	files, err := history.Discover([]string{dataFile}, true)
+	if err != nil || since.IsZero() {
+		return files, err
+	}
+	kept := files[:0]
+	for _, file := range files {
+		if file != dataFile {
+			if day, ok := history.RotatedDay(dataFile, file); ok && !day.AddDate(0, 0, 1).After(since) {
+				continue
+			}
+			if info, err := os.Stat(file); err == nil && info.ModTime().Before(since) {
+				continue
+			}
+		}
+		kept = append(kept, file)
+	}
+	return kept, nil
+}
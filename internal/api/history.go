@@ -0,0 +1,142 @@
+package api
+
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	"jenkins-monitor/internal/history"
+)
+
+// HistoryQuery selects the samples of /api/v1/history
+type HistoryQuery struct {
+	Job     string
+	BuildID string // empty for every build of the job
+	Since   time.Time
+	Until   time.Time // zero leaves the range open
+}
+
+func (q HistoryQuery) includes(buildID string, t time.Time) bool {
+	if q.BuildID != "" && buildID != q.BuildID {
+		return false
+	}
+	return !t.Before(q.Since) && (q.Until.IsZero() || !t.After(q.Until))
+}
+
+// BuildHistory is the usage of one build over the queried range
+type BuildHistory struct {
+	BuildID string  `json:"build_id"`
+	Points  []Point `json:"points"`
+}
+
+// History is the body of /api/v1/history
+type History struct {
+	Job   string     `json:"job"`
+	Since time.Time  `json:"since"`
+	Until *time.Time `json:"until,omitempty"`
+	// Source is output_files when the monitor's data files were read and
+	// memory when only the samples kept in memory were available
+	Source      string         `json:"source"`
+	SkippedRows int            `json:"skipped_rows"`
+	Builds      []BuildHistory `json:"builds"`
+}
+
+// History sources
+const (
+	SourceFiles  = "output_files"
+	SourceMemory = "memory"
+)
+
+func newHistory(q HistoryQuery, source string) History {
+	h := History{Job: q.Job, Since: q.Since, Source: source, Builds: []BuildHistory{}}
+	if !q.Until.IsZero() {
+		h.Until = &q.Until
+	}
+	return h
+}
+
+// historyFromFiles reads the job's samples from the data file and those of its
+// rotated copies that may hold the range, summing the processes of each build
+// per sample
+func historyFromFiles(dataFile string, q HistoryQuery) (History, error) {
+	files, err := dataFiles(dataFile, q.Since)
+	if err != nil {
+		return History{}, err
+	}
+	h := newHistory(q, SourceFiles)
+	hq := history.Query{Since: q.Since, Until: q.Until, Job: regexp.MustCompile("^" + regexp.QuoteMeta(q.Job) + "$")}
+
+	type sampleKey struct {
+		buildID string
+		time    int64
+	}
+	samples := make(map[sampleKey]*Point)
+	for _, file := range files {
+		skipped, err := history.ReadFile(file, hq, func(rec history.Record) {
+			if !q.includes(rec.BuildID, rec.Time) {
+				return
+			}
+			key := sampleKey{rec.BuildID, rec.Time.Unix()}
+			p, ok := samples[key]
+			if !ok {
+				p = &Point{Time: rec.Time}
+				samples[key] = p
+			}
+			p.CPUPercent += rec.CPU
+			p.MemPercent += rec.Mem
+			p.RSSBytes += rec.RSS
+			p.Processes++
+		}, nil)
+		h.SkippedRows += skipped
+		if err != nil {
+			return History{}, err
+		}
+	}
+
+	byBuild := make(map[string][]Point)
+	for key, p := range samples {
+		byBuild[key.buildID] = append(byBuild[key.buildID], *p)
+	}
+	for buildID, points := range byBuild {
+		h.Builds = append(h.Builds, BuildHistory{BuildID: buildID, Points: points})
+	}
+	sortHistory(h.Builds)
+	return h, nil
+}
+
+// historyFromMemory returns the job's samples kept in memory
+func (s *State) historyFromMemory(q HistoryQuery) History {
+	h := newHistory(q, SourceMemory)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, bh := range s.history {
+		if bh.job != q.Job {
+			continue
+		}
+		var points []Point
+		for _, p := range bh.points {
+			if q.includes(bh.buildID, p.Time) {
+				points = append(points, p)
+			}
+		}
+		if len(points) > 0 {
+			h.Builds = append(h.Builds, BuildHistory{BuildID: bh.buildID, Points: points})
+		}
+	}
+	sortHistory(h.Builds)
+	return h
+}
+
+// sortHistory orders the points of each build by time and the builds by their first point
+func sortHistory(builds []BuildHistory) {
+	for _, b := range builds {
+		sort.Slice(b.Points, func(i, j int) bool { return b.Points[i].Time.Before(b.Points[j].Time) })
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		ti, tj := builds[i].Points[0].Time, builds[j].Points[0].Time
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return builds[i].BuildID < builds[j].BuildID
+	})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"jenkins-monitor/internal/analyze"
+	"jenkins-monitor/internal/utils"
+)
+
+// Report defaults and limits
+const (
+	defaultReportTop   = 5
+	defaultReportRange = 24 * time.Hour
+	// reportCacheTTL is how long a report is reused for the same parameters,
+	// as building one reads every data file in its range
+	reportCacheTTL = time.Minute
+	// historyCacheTTL is shorter, as history is polled for recent samples
+	historyCacheTTL = 10 * time.Second
+)
+
+// report returns the analyze report of the top jobs by CPU and memory peak
+// over the monitor's data files
+func (s *Server) report(w http.ResponseWriter, r *http.Request) {
+	if s.dataFile == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no data files to analyze: collection is disabled"))
+		return
+	}
+	q := r.URL.Query()
+	now := s.now()
+	opts := analyze.Options{Top: defaultReportTop}
+	if v := q.Get("top"); v != "" {
+		top, err := strconv.Atoi(v)
+		if err != nil || top < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("top must be a positive integer"))
+			return
+		}
+		opts.Top = top
+	}
+	var err error
+	if opts.Since, err = analyze.ParseTimeArg(q.Get("since"), now); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("since: %w", err))
+		return
+	}
+	if opts.Since.IsZero() {
+		opts.Since = now.Add(-defaultReportRange)
+	}
+	if opts.Until, err = analyze.ParseTimeArg(q.Get("until"), now); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("until: %w", err))
+		return
+	}
+	if job := q.Get("job"); job != "" {
+		if opts.Job, err = regexp.Compile(job); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("job: %w", err))
+			return
+		}
+	}
+
+	report, err := s.reports.get(q.Encode(), now, func() (analyze.Report, error) {
+		files, err := dataFiles(s.dataFile, opts.Since)
+		if err != nil {
+			return analyze.Report{}, err
+		}
+		return analyze.Analyze(files, opts)
+	})
+	if err != nil {
+		utils.Error(fmt.Sprintf("API report failed: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"jenkins-monitor/internal/adhoc"
+	"jenkins-monitor/internal/analyze"
+	"jenkins-monitor/internal/notifier"
+	"jenkins-monitor/internal/utils"
+)
+
+// staleIntervals is how many sampling intervals may pass without a successful
+// collection before the monitor reports itself as not ready
+const staleIntervals = 3
+
+// defaultHistoryRange is the history returned when no since is given
+const defaultHistoryRange = time.Hour
+
+// Server serves the JSON API over the state of a running monitor
+type Server struct {
+	state *State
+	// dataFile is the monitor's output file, read with its rotated copies for
+	// history; empty when collection is disabled and only memory is used
+	dataFile string
+	now      func() time.Time
+
+	reports   *fileCache[analyze.Report]
+	histories *fileCache[History]
+}
+
+// NewServer creates a Server over state, reading history from dataFile when set
+func NewServer(state *State, dataFile string) *Server {
+	return &Server{
+		state:     state,
+		dataFile:  dataFile,
+		now:       time.Now,
+		reports:   newFileCache[analyze.Report](reportCacheTTL),
+		histories: newFileCache[History](historyCacheTTL),
+	}
+}
+
+// Register adds the API endpoints to mux
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", s.health)
+	mux.HandleFunc("GET /readyz", s.ready)
+	mux.HandleFunc("GET /api/v1/processes", s.processes)
+	mux.HandleFunc("GET /api/v1/builds", s.builds)
+	mux.HandleFunc("GET /api/v1/alerts", s.alerts)
+	mux.HandleFunc("GET /api/v1/history", s.history)
+	mux.HandleFunc("GET /api/v1/report", s.report)
+}
+
+// Health is the body of /healthz and /readyz
+type Health struct {
+	Status                 string     `json:"status"`
+	StartedAt              time.Time  `json:"started_at"`
+	LastCollection         *time.Time `json:"last_collection"`
+	LastCollectionAge      float64    `json:"last_collection_age_seconds"`
+	IntervalSeconds        float64    `json:"interval_seconds"`
+	LastError              string     `json:"last_error,omitempty"`
+	LastErrorAt            *time.Time `json:"last_error_at,omitempty"`
+	Builds                 int        `json:"builds"`
+	Processes              int        `json:"processes"`
+	RecentAlerts           int        `json:"recent_alerts"`
+	HistoryWindowSeconds   float64    `json:"history_window_seconds"`
+	HistoryFromOutputFiles bool       `json:"history_from_output_files"`
+}
+
+// health reports the last collection; the monitor is alive whenever it answers
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	h, _ := s.healthStatus()
+	h.Status = "ok"
+	writeJSON(w, http.StatusOK, h)
+}
+
+// ready fails until the first collection pass and when the last successful
+// one is older than staleIntervals sampling intervals
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	h, ready := s.healthStatus()
+	if !ready {
+		h.Status = "not_ready"
+		writeJSON(w, http.StatusServiceUnavailable, h)
+		return
+	}
+	h.Status = "ready"
+	writeJSON(w, http.StatusOK, h)
+}
+
+func (s *Server) healthStatus() (Health, bool) {
+	now := s.now()
+	st := s.state
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	h := Health{
+		StartedAt:              st.startedAt,
+		IntervalSeconds:        st.interval.Seconds(),
+		LastError:              st.lastError,
+		Builds:                 len(st.builds),
+		Processes:              st.processes,
+		RecentAlerts:           len(st.alerts),
+		HistoryWindowSeconds:   st.cfg.HistoryWindow.Seconds(),
+		HistoryFromOutputFiles: s.dataFile != "",
+	}
+	if !st.lastErrorAt.IsZero() {
+		at := st.lastErrorAt
+		h.LastErrorAt = &at
+	}
+	if st.lastCollection.IsZero() {
+		return h, false
+	}
+	last := st.lastCollection
+	age := now.Sub(last)
+	h.LastCollection, h.LastCollectionAge = &last, age.Seconds()
+	return h, age <= staleIntervals*st.interval
+}
+
+// ProcessEntry is a running build process with the build it belongs to
+type ProcessEntry struct {
+	Job     string `json:"job"`
+	BuildID string `json:"build_id"`
+	adhoc.ProcessReport
+}
+
+// Processes is the body of /api/v1/processes
+type Processes struct {
+	GeneratedAt  time.Time      `json:"generated_at"`
+	ProcessCount int            `json:"process_count"`
+	Processes    []ProcessEntry `json:"processes"`
+}
+
+// processes lists the processes of the last collection pass by CPU, highest
+// first, optionally restricted to a job and limited in number
+func (s *Server) processes(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job := r.URL.Query().Get("job")
+
+	st := s.state
+	st.mu.RLock()
+	resp := Processes{GeneratedAt: st.lastCollection, Processes: []ProcessEntry{}}
+	for _, b := range st.builds {
+		if job != "" && b.BuildJobName != job {
+			continue
+		}
+		for _, p := range b.Processes {
+			resp.Processes = append(resp.Processes, ProcessEntry{Job: b.BuildJobName, BuildID: b.BuildId, ProcessReport: adhoc.NewProcessReport(p)})
+		}
+	}
+	st.mu.RUnlock()
+
+	sort.SliceStable(resp.Processes, func(i, j int) bool {
+		return resp.Processes[i].CPUPercent > resp.Processes[j].CPUPercent
+	})
+	if limit > 0 && len(resp.Processes) > limit {
+		resp.Processes = resp.Processes[:limit]
+	}
+	resp.ProcessCount = len(resp.Processes)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// builds returns the builds of the last collection pass in the schema of
+// adhoc --format json, with their process trees when processes=true
+func (s *Server) builds(w http.ResponseWriter, r *http.Request) {
+	withProcesses, err := boolParam(r, "processes")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job := r.URL.Query().Get("job")
+
+	st := s.state
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	snap := adhoc.Snapshot{
+		GeneratedAt:         st.lastCollection,
+		SampleWindowSeconds: st.interval.Seconds(),
+		Builds:              []adhoc.BuildReport{},
+		Workspaces:          []adhoc.WorkspaceReport{},
+	}
+	for _, b := range st.builds {
+		if job != "" && b.BuildJobName != job {
+			continue
+		}
+		snap.Builds = append(snap.Builds, adhoc.NewBuildReport(b, withProcesses))
+		snap.ProcessCount += len(b.Processes)
+	}
+	snap.BuildCount = len(snap.Builds)
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// Alerts is the body of /api/v1/alerts
+type Alerts struct {
+	Alerts []notifier.Payload `json:"alerts"`
+}
+
+// alerts returns the recent alert events, newest first, in the schema of the
+// default webhook payload
+func (s *Server) alerts(w http.ResponseWriter, r *http.Request) {
+	limit, err := limitParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	q := r.URL.Query()
+	job, alertType := q.Get("job"), q.Get("type")
+
+	st := s.state
+	st.mu.RLock()
+	resp := Alerts{Alerts: []notifier.Payload{}}
+	for i := len(st.alerts) - 1; i >= 0; i-- {
+		p := st.alerts[i]
+		if (job != "" && p.JobName != job) || (alertType != "" && p.Type != alertType) {
+			continue
+		}
+		resp.Alerts = append(resp.Alerts, p)
+		if limit > 0 && len(resp.Alerts) == limit {
+			break
+		}
+	}
+	st.mu.RUnlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// history returns the per-sample usage of the builds of a job over a time range
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	job := q.Get("job")
+	if job == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("job is required"))
+		return
+	}
+	now := s.now()
+	since, err := analyze.ParseTimeArg(q.Get("since"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("since: %w", err))
+		return
+	}
+	if since.IsZero() {
+		since = now.Add(-defaultHistoryRange)
+	}
+	until, err := analyze.ParseTimeArg(q.Get("until"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("until: %w", err))
+		return
+	}
+
+	source := q.Get("source")
+	switch {
+	case source == "" && s.dataFile != "":
+		source = SourceFiles
+	case source == "":
+		source = SourceMemory
+	case source == SourceFiles && s.dataFile == "":
+		writeError(w, http.StatusBadRequest, fmt.Errorf("source %s is not available: collection is disabled", SourceFiles))
+		return
+	case source != SourceFiles && source != SourceMemory:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("source must be %s or %s", SourceFiles, SourceMemory))
+		return
+	}
+
+	hq := HistoryQuery{Job: job, BuildID: q.Get("build"), Since: since, Until: until}
+	var resp History
+	if source == SourceFiles {
+		resp, err = s.histories.get(q.Encode(), now, func() (History, error) {
+			return historyFromFiles(s.dataFile, hq)
+		})
+		if err != nil {
+			utils.Error(fmt.Sprintf("API history query for job %s failed: %v", job, err))
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		resp = s.state.historyFromMemory(hq)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// limitParam parses the optional limit parameter; 0 means no limit
+func limitParam(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("limit must be a non-negative integer")
+	}
+	return limit, nil
+}
+
+func boolParam(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		utils.Error(fmt.Sprintf("Failed to write API response: %v", err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/notifier"
+	"jenkins-monitor/internal/process"
+)
+
+// Point is the usage of a build's process tree in one sample
+type Point struct {
+	Time       time.Time `json:"time"`
+	CPUPercent float64   `json:"cpu_percent"`
+	MemPercent float64   `json:"mem_percent"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	Processes  int       `json:"processes"`
+}
+
+// buildHistory is the recent usage of one build kept in memory
+type buildHistory struct {
+	job     string
+	buildID string
+	points  []Point
+}
+
+// State is what the monitor loop last collected and alerted on, shared with
+// the API handlers
+type State struct {
+	mu  sync.RWMutex
+	cfg config.APIConfig
+
+	startedAt      time.Time
+	interval       time.Duration
+	lastCollection time.Time // end of the last successful collection pass
+	lastError      string
+	lastErrorAt    time.Time
+
+	builds    []process.BuildInfo // sorted by CPU, highest first
+	processes int
+	alerts    []notifier.Payload // oldest first, at most cfg.RecentAlerts
+	history   map[string]*buildHistory
+}
+
+// NewState creates an empty State for a monitor started at now
+func NewState(cfg config.APIConfig, now time.Time) *State {
+	return &State{
+		cfg:       cfg,
+		startedAt: now,
+		history:   make(map[string]*buildHistory),
+	}
+}
+
+// Collected records the builds of a successful collection pass and the
+// interval until the next one
+func (s *State) Collected(builds []process.BuildInfo, interval time.Duration, now time.Time) {
+	sorted := make([]process.BuildInfo, len(builds))
+	copy(sorted, builds)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CPU > sorted[j].CPU
+	})
+	processes := 0
+	for _, b := range builds {
+		processes += len(b.Processes)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builds, s.processes = sorted, processes
+	s.lastCollection, s.interval = now, interval
+
+	for _, b := range builds {
+		h, ok := s.history[b.Key()]
+		if !ok {
+			h = &buildHistory{job: b.BuildJobName, buildID: b.BuildId}
+			s.history[b.Key()] = h
+		}
+		h.points = append(h.points, Point{
+			Time: now, CPUPercent: b.CPU, MemPercent: float64(b.Mem), RSSBytes: b.RSS, Processes: len(b.Processes),
+		})
+	}
+	cutoff := now.Add(-s.cfg.HistoryWindow)
+	for key, h := range s.history {
+		i := sort.Search(len(h.points), func(i int) bool { return !h.points[i].Time.Before(cutoff) })
+		if i == len(h.points) {
+			delete(s.history, key)
+			continue
+		}
+		h.points = h.points[i:]
+	}
+}
+
+// CollectionFailed records the error of a failed collection pass
+func (s *State) CollectionFailed(err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError, s.lastErrorAt = err.Error(), now
+}
+
+// AddAlert keeps an alert event for /api/v1/alerts, dropping the oldest
+// beyond the configured number
+func (s *State) AddAlert(ev alert.Event) {
+	p := notifier.NewPayload(ev)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts = append(s.alerts, p)
+	if extra := len(s.alerts) - s.cfg.RecentAlerts; extra > 0 {
+		s.alerts = append(s.alerts[:0:0], s.alerts[extra:]...)
+	}
+}
@@ -0,0 +1,316 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default locations of the proc and cgroup filesystems
+const (
+	DefaultProcRoot = "/proc"
+	DefaultRoot     = "/sys/fs/cgroup"
+)
+
+// unlimitedV1 is the smallest memory limit treated as "no limit" on cgroup v1,
+// which reports the absence of a limit as a page-aligned maximum value
+const unlimitedV1 = 1 << 62
+
+// Stats is the resource accounting of the cgroup of a process
+type Stats struct {
+	// Path is the process's cgroup, relative to the cgroup root
+	Path    string
+	Version int // 1 or 2
+	// MemoryLimit and MemoryUsage belong to the most constrained of the cgroup
+	// and its ancestors, the one closest to an OOM kill; MemoryLimit is 0 when
+	// none of them has a limit
+	MemoryLimit uint64
+	MemoryUsage uint64
+	// OOMKills counts the processes killed by the OOM killer in the cgroup
+	OOMKills uint64
+	// CPU bandwidth enforcement periods and how many of them were throttled,
+	// since the cgroup was created
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledTime    time.Duration
+	// ThrottledPercent is the share of the periods since the previous sample
+	// that were throttled; 0 on the first sample
+	ThrottledPercent float64
+}
+
+// MemoryPercent returns the memory usage as a percentage of the limit, or 0
+// when there is no limit
+func (s Stats) MemoryPercent() float64 {
+	if s.MemoryLimit == 0 {
+		return 0
+	}
+	return float64(s.MemoryUsage) / float64(s.MemoryLimit) * 100
+}
+
+// Sampler reads the cgroups of processes. Each cgroup is read once per
+// collection pass however many processes share it, and its CPU counters are
+// kept until the next pass to measure throttling over the interval.
+type Sampler struct {
+	procRoot string
+	root     string
+	prev     map[string]Stats
+	current  map[string]*Stats // nil for cgroups that could not be read
+	// oomFiles holds the OOM kill counter file of each cgroup and the pass it
+	// was last read in, so the counter can be re-read after the processes exited
+	oomFiles map[string]oomFile
+	pass     int
+}
+
+type oomFile struct {
+	name string
+	pass int
+}
+
+// NewSampler creates a Sampler reading the proc filesystem at procRoot and
+// the cgroup filesystem at root
+func NewSampler(procRoot, root string) *Sampler {
+	return &Sampler{
+		procRoot: procRoot,
+		root:     root,
+		prev:     make(map[string]Stats),
+		current:  make(map[string]*Stats),
+		oomFiles: make(map[string]oomFile),
+	}
+}
+
+// location is where the accounting of a process's cgroup is found
+type location struct {
+	version int
+	path    string // memory cgroup on v1, the unified cgroup on v2
+	// Mount points of the v1 memory and cpu controllers and the cgroup of the cpu controller
+	memMount, cpuMount, cpuPath string
+}
+
+func (l location) key() string {
+	return statsKey(l.version, l.path)
+}
+
+func statsKey(version int, path string) string {
+	return fmt.Sprintf("%d:%s", version, path)
+}
+
+// Stats returns the accounting of the cgroup of pid, or false if it cannot be read
+func (s *Sampler) Stats(pid int32) (Stats, bool) {
+	loc, err := s.resolve(pid)
+	if err != nil {
+		return Stats{}, false
+	}
+	key := loc.key()
+	if st, ok := s.current[key]; ok {
+		if st == nil {
+			return Stats{}, false
+		}
+		return *st, true
+	}
+
+	var st *Stats
+	if loc.version == 2 {
+		st = s.readV2(loc)
+	} else {
+		st = s.readV1(loc)
+	}
+	if st != nil {
+		if prev, ok := s.prev[key]; ok && st.Periods > prev.Periods && st.ThrottledPeriods >= prev.ThrottledPeriods {
+			st.ThrottledPercent = float64(st.ThrottledPeriods-prev.ThrottledPeriods) / float64(st.Periods-prev.Periods) * 100
+		}
+	}
+	s.current[key] = st
+	if st == nil {
+		return Stats{}, false
+	}
+	return *st, true
+}
+
+// Finish ends a collection pass. The counters read in it become the base of
+// the next pass, and cgroups that were not seen are forgotten.
+func (s *Sampler) Finish() {
+	s.prev = make(map[string]Stats, len(s.current))
+	for key, st := range s.current {
+		if st != nil {
+			s.prev[key] = *st
+		}
+	}
+	s.current = make(map[string]*Stats)
+	s.pass++
+	for key, f := range s.oomFiles {
+		if f.pass < s.pass-2 {
+			delete(s.oomFiles, key)
+		}
+	}
+}
+
+// OOMKills re-reads the OOM kill counter of the cgroup st was read from. It
+// works for cgroups read in the last two passes, whether or not they still
+// have processes, and returns false once the cgroup is removed.
+func (s *Sampler) OOMKills(st Stats) (uint64, bool) {
+	f, ok := s.oomFiles[statsKey(st.Version, st.Path)]
+	if !ok {
+		return 0, false
+	}
+	if _, err := os.Stat(f.name); err != nil {
+		return 0, false
+	}
+	return readKeyed(f.name)["oom_kill"], true
+}
+
+// resolve reads /proc/<pid>/cgroup. The v1 hierarchy is used when the memory
+// controller is mounted there, the unified v2 hierarchy otherwise.
+func (s *Sampler) resolve(pid int32) (location, error) {
+	f, err := os.Open(filepath.Join(s.procRoot, strconv.Itoa(int(pid)), "cgroup"))
+	if err != nil {
+		return location{}, err
+	}
+	defer f.Close()
+
+	var unified string
+	hasUnified := false
+	paths := make(map[string]string)  // v1 controller -> cgroup
+	mounts := make(map[string]string) // v1 controller -> mount point
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			unified, hasUnified = parts[2], true
+			continue
+		}
+		for _, c := range strings.Split(parts[1], ",") {
+			paths[c] = parts[2]
+			// Co-mounted controllers share a directory named after the list, e.g. cpu,cpuacct
+			mounts[c] = filepath.Join(s.root, parts[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return location{}, err
+	}
+
+	if memPath, ok := paths["memory"]; ok {
+		return location{
+			version:  1,
+			path:     memPath,
+			memMount: mounts["memory"],
+			cpuMount: mounts["cpu"],
+			cpuPath:  paths["cpu"],
+		}, nil
+	}
+	if hasUnified {
+		return location{version: 2, path: unified}, nil
+	}
+	return location{}, fmt.Errorf("no memory cgroup for pid %d", pid)
+}
+
+// readV2 reads a cgroup of the unified hierarchy
+func (s *Sampler) readV2(loc location) *Stats {
+	dir := filepath.Join(s.root, loc.path)
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	st := &Stats{Path: loc.path, Version: 2}
+	st.readMemory(s.root, loc.path, "memory.max", "memory.current", 0)
+
+	st.OOMKills = s.readOOMKills(loc, filepath.Join(dir, "memory.events"))
+	cpu := readKeyed(filepath.Join(dir, "cpu.stat"))
+	st.Periods, st.ThrottledPeriods = cpu["nr_periods"], cpu["nr_throttled"]
+	st.ThrottledTime = time.Duration(cpu["throttled_usec"]) * time.Microsecond
+	return st
+}
+
+// readV1 reads the memory and cpu controllers of a v1 cgroup
+func (s *Sampler) readV1(loc location) *Stats {
+	dir := filepath.Join(loc.memMount, loc.path)
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	st := &Stats{Path: loc.path, Version: 1}
+	st.readMemory(loc.memMount, loc.path, "memory.limit_in_bytes", "memory.usage_in_bytes", unlimitedV1)
+
+	st.OOMKills = s.readOOMKills(loc, filepath.Join(dir, "memory.oom_control"))
+	if loc.cpuMount != "" {
+		cpu := readKeyed(filepath.Join(loc.cpuMount, loc.cpuPath, "cpu.stat"))
+		st.Periods, st.ThrottledPeriods = cpu["nr_periods"], cpu["nr_throttled"]
+		st.ThrottledTime = time.Duration(cpu["throttled_time"])
+	}
+	return st
+}
+
+// readOOMKills reads the OOM kill counter of a cgroup and remembers where it is
+func (s *Sampler) readOOMKills(loc location, name string) uint64 {
+	s.oomFiles[loc.key()] = oomFile{name: name, pass: s.pass}
+	return readKeyed(name)["oom_kill"]
+}
+
+// readMemory walks from the cgroup at cgroupPath under mount up to the root
+// and keeps the limit and usage of the most constrained cgroup. Limits of
+// unlimited or more are ignored when unlimited is not zero; without any
+// limit, the usage of the cgroup itself is reported.
+func (s *Stats) readMemory(mount, cgroupPath, limitFile, usageFile string, unlimited uint64) {
+	p := path.Clean("/" + cgroupPath)
+	for {
+		dir := filepath.Join(mount, p)
+		// A missing file or "max" means no limit at this level
+		limit, err := readUint(filepath.Join(dir, limitFile))
+		if err == nil && limit > 0 && (unlimited == 0 || limit < unlimited) {
+			if usage, err := readUint(filepath.Join(dir, usageFile)); err == nil {
+				s.setMemory(limit, usage)
+			}
+		}
+		if p == "/" {
+			break
+		}
+		p = path.Dir(p)
+	}
+	if s.MemoryLimit == 0 {
+		s.MemoryUsage, _ = readUint(filepath.Join(mount, cgroupPath, usageFile))
+	}
+}
+
+// setMemory keeps the limit and usage of the most constrained cgroup seen so far
+func (s *Stats) setMemory(limit, usage uint64) {
+	candidate := Stats{MemoryLimit: limit, MemoryUsage: usage}
+	if s.MemoryLimit == 0 || candidate.MemoryPercent() > s.MemoryPercent() {
+		s.MemoryLimit, s.MemoryUsage = limit, usage
+	}
+}
+
+// readUint reads a file holding a single number; "max" is an error, as the
+// cgroup v2 spelling of no limit
+func readUint(name string) (uint64, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readKeyed reads a flat keyed file of "key value" lines, such as cpu.stat;
+// a missing file yields an empty map
+func readKeyed(name string) map[string]uint64 {
+	values := make(map[string]uint64)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return values
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values
+}
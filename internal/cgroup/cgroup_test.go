@@ -0,0 +1,116 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTree creates the files of a fake proc or cgroup filesystem under root
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSamplerV2(t *testing.T) {
+	procRoot, root := t.TempDir(), t.TempDir()
+	writeTree(t, procRoot, map[string]string{
+		"100/cgroup": "0::/jenkins.slice/build-7.scope\n",
+		"101/cgroup": "0::/jenkins.slice/build-7.scope\n",
+		"200/cgroup": "0::/\n",
+	})
+	writeTree(t, root, map[string]string{
+		// The slice limit applies to the scope, which has none of its own
+		"jenkins.slice/memory.max":                   "4294967296\n",
+		"jenkins.slice/memory.current":               "3865470566\n",
+		"jenkins.slice/build-7.scope/memory.max":     "max\n",
+		"jenkins.slice/build-7.scope/memory.current": "3221225472\n",
+		"jenkins.slice/build-7.scope/memory.events":  "low 0\nhigh 0\nmax 12\noom 2\noom_kill 1\n",
+		"jenkins.slice/build-7.scope/cpu.stat":       "usage_usec 500\nnr_periods 100\nnr_throttled 10\nthrottled_usec 250000\n",
+	})
+
+	s := NewSampler(procRoot, root)
+	st, ok := s.Stats(100)
+	if !ok {
+		t.Fatal("Stats() found no cgroup")
+	}
+	want := Stats{
+		Path: "/jenkins.slice/build-7.scope", Version: 2, MemoryLimit: 4294967296, MemoryUsage: 3865470566,
+		OOMKills: 1, Periods: 100, ThrottledPeriods: 10, ThrottledTime: 250 * time.Millisecond,
+	}
+	if st != want {
+		t.Errorf("Stats() = %+v, want %+v", st, want)
+	}
+	if got := st.MemoryPercent(); got < 89.9 || got > 90.1 {
+		t.Errorf("MemoryPercent() = %.2f, want 90", got)
+	}
+
+	// The root cgroup has no limit
+	if st, ok := s.Stats(200); !ok || st.MemoryLimit != 0 || st.MemoryPercent() != 0 {
+		t.Errorf("Stats(root) = %+v, %v, want no limit", st, ok)
+	}
+	if _, ok := s.Stats(300); ok {
+		t.Error("Stats() of a missing process should fail")
+	}
+	s.Finish()
+
+	// 40 of the 100 periods since the last pass were throttled
+	writeTree(t, root, map[string]string{
+		"jenkins.slice/build-7.scope/cpu.stat": "nr_periods 200\nnr_throttled 50\nthrottled_usec 900000\n",
+	})
+	if st, _ := s.Stats(101); st.ThrottledPercent != 40 {
+		t.Errorf("ThrottledPercent = %.1f, want 40", st.ThrottledPercent)
+	}
+
+	// The counter can be re-read after the processes of the cgroup exited
+	s.Finish()
+	s.Finish()
+	writeTree(t, root, map[string]string{
+		"jenkins.slice/build-7.scope/memory.events": "oom 3\noom_kill 2\n",
+	})
+	if got, ok := s.OOMKills(want); !ok || got != 2 {
+		t.Errorf("OOMKills() = %d, %v, want 2", got, ok)
+	}
+	s.Finish()
+	if _, ok := s.OOMKills(want); ok {
+		t.Error("OOMKills() of a cgroup not read in the last two passes should fail")
+	}
+}
+
+func TestSamplerV1(t *testing.T) {
+	procRoot, root := t.TempDir(), t.TempDir()
+	writeTree(t, procRoot, map[string]string{
+		"100/cgroup": "12:pids:/docker/abc\n5:memory:/docker/abc\n3:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n0::/system.slice/docker.service\n",
+	})
+	writeTree(t, root, map[string]string{
+		"memory/docker/abc/memory.limit_in_bytes": "9223372036854771712\n",
+		"memory/docker/abc/memory.usage_in_bytes": "1073741824\n",
+		"memory/docker/abc/memory.oom_control":    "oom_kill_disable 0\nunder_oom 0\noom_kill 3\n",
+		"memory/docker/memory.limit_in_bytes":     "2147483648\n",
+		"memory/docker/memory.usage_in_bytes":     "1610612736\n",
+		"memory/memory.limit_in_bytes":            "9223372036854771712\n",
+		"memory/memory.usage_in_bytes":            "8589934592\n",
+		"cpu,cpuacct/docker/abc/cpu.stat":         "nr_periods 50\nnr_throttled 5\nthrottled_time 2000000000\n",
+	})
+
+	st, ok := NewSampler(procRoot, root).Stats(100)
+	if !ok {
+		t.Fatal("Stats() found no cgroup")
+	}
+	want := Stats{
+		Path: "/docker/abc", Version: 1, MemoryLimit: 2147483648, MemoryUsage: 1610612736,
+		OOMKills: 3, Periods: 50, ThrottledPeriods: 5, ThrottledTime: 2 * time.Second,
+	}
+	if st != want {
+		t.Errorf("Stats() = %+v, want %+v", st, want)
+	}
+}
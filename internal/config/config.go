@@ -3,23 +3,72 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/exits"
 )
 
 // Config holds the application's configuration
 type Config struct {
-	Prometheus        PrometheusConfig `yaml:"prometheus"`
-	Slack             SlackConfig      `yaml:"slack"`
-	Thresholds        ThresholdsConfig `yaml:"thresholds"`
-	DisableCollection bool             `yaml:"disable_collection"`
+	Prometheus        PrometheusConfig  `yaml:"prometheus"`
+	Slack             SlackConfig       `yaml:"slack"`
+	Notifiers         NotifiersConfig   `yaml:"notifiers"`
+	Thresholds        ThresholdsConfig  `yaml:"thresholds"`
+	Alerting          AlertingConfig    `yaml:"alerting"`
+	Builds            BuildsConfig      `yaml:"builds"`
+	Host              HostConfig        `yaml:"host"`
+	Workspaces        WorkspacesConfig  `yaml:"workspaces"`
+	Cgroups           CgroupsConfig     `yaml:"cgroups"`
+	Exits             ExitsConfig       `yaml:"exits"`
+	Remediation       RemediationConfig `yaml:"remediation"`
+	API               APIConfig         `yaml:"api"`
+	Dashboard         DashboardConfig   `yaml:"dashboard"`
+	DisableCollection bool              `yaml:"disable_collection"`
+	// OutputFile is the default CSV path written by monitor and read by analyze
+	OutputFile string `yaml:"output_file"`
+	// Storage selects the output file format: "csv" (default) or "tsdb"
+	Storage   string          `yaml:"storage"`
+	Retention RetentionConfig `yaml:"retention"`
+	// Interval is the time between collection passes
+	Interval time.Duration `yaml:"interval"`
+	// AdaptiveInterval replaces Interval while any build is above a threshold; zero disables it
+	AdaptiveInterval time.Duration `yaml:"adaptive_interval"`
 }
 
+// Storage formats
+const (
+	StorageCSV  = "csv"
+	StorageTSDB = "tsdb"
+)
+
+// DefaultInterval is the sampling interval used when none is configured
+const DefaultInterval = 30 * time.Second
+
 // PrometheusConfig holds Prometheus-related configuration
 type PrometheusConfig struct {
 	ListenAddress string `yaml:"listen_address"`
+	// Aggregation selects the labels of the jenkins_job_* gauges: per process
+	// (pid), per build (build) or per job (job)
+	Aggregation string `yaml:"aggregation"`
+	// MaxSeries caps the number of per-process, per-build and per-stage series
+	// exported; series beyond it are dropped and counted
+	MaxSeries int `yaml:"max_series"`
 }
 
+// Prometheus aggregation levels of the jenkins_job_* gauges
+const (
+	AggregatePID   = "pid"
+	AggregateBuild = "build"
+	AggregateJob   = "job"
+)
+
+// DefaultMaxSeries is the series cap used when none is configured
+const DefaultMaxSeries = 10000
+
 // SlackConfig holds Slack-related configuration
 type SlackConfig struct {
 	WebhookURL string `yaml:"webhook_url"`
@@ -27,10 +76,266 @@ type SlackConfig struct {
 	Username   string `yaml:"username"`
 }
 
+// NotifiersConfig holds the optional notification backends in addition to Slack.
+// Any number of each backend may be configured.
+type NotifiersConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Teams    []TeamsConfig   `yaml:"teams"`
+	Email    []EmailConfig   `yaml:"email"`
+}
+
+// WebhookConfig holds a generic JSON webhook backend
+type WebhookConfig struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	// BodyTemplate is a Go text/template rendering the request body; empty sends the default JSON payload
+	BodyTemplate string `yaml:"body_template"`
+}
+
+// TeamsConfig holds a Microsoft Teams incoming webhook backend
+type TeamsConfig struct {
+	Name       string `yaml:"name"`
+	WebhookURL string `yaml:"webhook_url"`
+	// Format is "message_card" (default) or "adaptive_card"
+	Format string `yaml:"format"`
+}
+
+// EmailConfig holds an SMTP email backend
+type EmailConfig struct {
+	Name     string   `yaml:"name"`
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// TLS connects with implicit TLS (usually port 465); otherwise STARTTLS is used when offered
+	TLS bool `yaml:"tls"`
+}
+
 // ThresholdsConfig holds alerting thresholds
 type ThresholdsConfig struct {
 	CPUPercent float64 `yaml:"cpu_percent"`
 	MemPercent float64 `yaml:"mem_percent"`
+	// CPUMode selects what cpu_percent is compared against: "core" (percent of
+	// one core, may exceed 100 for multi-threaded builds) or "machine" (percent of all cores)
+	CPUMode string `yaml:"cpu_mode"`
+	// MaxDuration alerts when a build runs longer than this; zero disables it
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// WorkspaceSizeMB alerts when a build's workspace grows beyond this size; zero disables it
+	WorkspaceSizeMB float64 `yaml:"workspace_size_mb"`
+	// WorkspaceGrowthMBPerMin alerts when a build's workspace grows faster than this; zero disables it
+	WorkspaceGrowthMBPerMin float64 `yaml:"workspace_growth_mb_per_min"`
+	// RSSMB alerts when a build's resident memory exceeds this many MB; zero disables it
+	RSSMB float64 `yaml:"rss_mb"`
+	// MaxThreads and MaxOpenFDs alert when a build's processes together hold
+	// more threads or open file descriptors; zero disables them
+	MaxThreads int `yaml:"max_threads"`
+	MaxOpenFDs int `yaml:"max_open_fds"`
+	// FDPercent alerts when any process of a build uses this share of its open
+	// file limit; zero disables it
+	FDPercent float64 `yaml:"fd_percent"`
+	// CgroupMemPercent alerts when a build's cgroup uses this share of its
+	// memory limit; zero disables it
+	CgroupMemPercent float64 `yaml:"cgroup_mem_percent"`
+	// CgroupThrottledPercent alerts when this share of a build's cgroup CPU
+	// periods was throttled over the last interval; zero disables it
+	CgroupThrottledPercent float64 `yaml:"cgroup_throttled_percent"`
+	// Severity is attached to alerts raised by the global thresholds
+	Severity string `yaml:"severity"`
+	// Actions are taken when an alert of a build fires, unless the build's rule has its own
+	Actions []ActionConfig `yaml:"actions"`
+	// Rules override the global thresholds for matching builds; the first match wins
+	Rules []ThresholdRule `yaml:"rules"`
+}
+
+// CPU modes
+const (
+	CPUModeCore    = "core"
+	CPUModeMachine = "machine"
+)
+
+// AlertingConfig controls how threshold breaches turn into notifications
+type AlertingConfig struct {
+	// ConsecutiveSamples is how many samples in a row must breach a threshold before an alert fires
+	ConsecutiveSamples int `yaml:"consecutive_samples"`
+	// RepeatInterval re-sends a firing alert at this interval; zero sends it only once
+	RepeatInterval time.Duration `yaml:"repeat_interval"`
+	// Cooldown suppresses a new alert for the same build and type this long after it resolved
+	Cooldown time.Duration `yaml:"cooldown"`
+	// DisableResolved stops "resolved" notifications from being sent
+	DisableResolved bool `yaml:"disable_resolved"`
+}
+
+// BuildsConfig controls the per-build summaries written when a build finishes
+type BuildsConfig struct {
+	// SummaryFile receives one JSON line per finished build; empty uses
+	// <output file without extension>.builds.jsonl
+	SummaryFile string `yaml:"summary_file"`
+	// DisableSummaryFile stops summaries from being written to a file; they are still logged
+	DisableSummaryFile bool `yaml:"disable_summary_file"`
+	// EndGrace is how long a build must have no processes before it counts as
+	// finished, so the gaps between pipeline steps do not end it
+	EndGrace time.Duration `yaml:"end_grace"`
+	// Slack posts every summary to the Slack webhook
+	Slack bool `yaml:"slack"`
+}
+
+// HostConfig controls the sampling of the agent's own load, CPU, memory, swap and disk
+type HostConfig struct {
+	// Disable turns host sampling off
+	Disable bool `yaml:"disable"`
+	// WorkspacePath is the directory whose filesystem usage is reported; empty
+	// uses the parent directory of the first running build's workspace
+	WorkspacePath string `yaml:"workspace_path"`
+}
+
+// WorkspacesConfig controls the measurement of the size of build workspaces
+type WorkspacesConfig struct {
+	// Disable turns workspace measurement off
+	Disable bool `yaml:"disable"`
+	// Interval is how often each workspace is measured
+	Interval time.Duration `yaml:"interval"`
+	// ScanBudget caps the files and directories visited per collection pass,
+	// over all workspaces; walks left unfinished continue in the next pass
+	ScanBudget int `yaml:"scan_budget"`
+}
+
+// CgroupsConfig controls the cgroup accounting of build processes
+type CgroupsConfig struct {
+	// Disable turns reading the cgroups of build processes off
+	Disable bool `yaml:"disable"`
+	// Root is the mount point of the cgroup filesystem
+	Root string `yaml:"root"`
+}
+
+// ExitsConfig controls the detection of build processes killed by the OOM
+// killer or a crash
+type ExitsConfig struct {
+	// Disable turns the detection and its alerts off
+	Disable bool `yaml:"disable"`
+	// KernelLog is the kernel log device read for OOM kill and crash messages
+	KernelLog string `yaml:"kernel_log"`
+	// DisableKernelLog leaves the OOM kill counters of the cgroups as the only evidence
+	DisableKernelLog bool `yaml:"disable_kernel_log"`
+}
+
+// APIConfig controls the JSON API served by monitor next to /metrics
+type APIConfig struct {
+	// Disable serves /metrics only
+	Disable bool `yaml:"disable"`
+	// RecentAlerts is the number of alert events kept for /api/v1/alerts
+	RecentAlerts int `yaml:"recent_alerts"`
+	// HistoryWindow is how long the per-build samples are kept in memory for
+	// the dashboard sparklines and /api/v1/history without an output file
+	HistoryWindow time.Duration `yaml:"history_window"`
+}
+
+// DashboardConfig controls the HTML dashboard served by monitor on top of the API
+type DashboardConfig struct {
+	// Disable leaves / and /dashboard/ unserved
+	Disable bool `yaml:"disable"`
+}
+
+// API defaults
+const (
+	DefaultRecentAlerts  = 100
+	DefaultHistoryWindow = time.Hour
+)
+
+// Workspace measurement defaults
+const (
+	DefaultWorkspaceInterval   = 5 * time.Minute
+	DefaultWorkspaceScanBudget = 200000
+)
+
+// DefaultEndGrace is the build end grace period used when none is configured
+const DefaultEndGrace = time.Minute
+
+// RetentionConfig controls when the output file is rotated and how long the
+// rotated files are kept. Zero values disable the corresponding limit.
+type RetentionConfig struct {
+	// MaxFileSizeMB rotates the output file once it reaches this size, in addition to the daily rotation
+	MaxFileSizeMB int64 `yaml:"max_file_size_mb"`
+	// MaxAge deletes rotated files last modified longer ago than this
+	MaxAge time.Duration `yaml:"max_age"`
+	// MaxTotalSizeMB deletes the oldest rotated files while they take up more than this
+	MaxTotalSizeMB int64 `yaml:"max_total_size_mb"`
+	// MaxFiles keeps at most this many rotated files
+	MaxFiles int `yaml:"max_files"`
+	// Compress gzips files as they are rotated
+	Compress bool `yaml:"compress"`
+}
+
+// applyDefaults fills in optional settings that were left empty
+func (c *Config) applyDefaults() {
+	if c.Thresholds.CPUMode == "" {
+		c.Thresholds.CPUMode = CPUModeCore
+	}
+	if c.Thresholds.Severity == "" {
+		c.Thresholds.Severity = SeverityWarning
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.Storage == "" {
+		c.Storage = StorageCSV
+	}
+	if c.Prometheus.Aggregation == "" {
+		c.Prometheus.Aggregation = AggregatePID
+	}
+	if c.Prometheus.MaxSeries == 0 {
+		c.Prometheus.MaxSeries = DefaultMaxSeries
+	}
+	if c.Builds.EndGrace == 0 {
+		c.Builds.EndGrace = DefaultEndGrace
+	}
+	if c.Workspaces.Interval == 0 {
+		c.Workspaces.Interval = DefaultWorkspaceInterval
+	}
+	if c.Workspaces.ScanBudget == 0 {
+		c.Workspaces.ScanBudget = DefaultWorkspaceScanBudget
+	}
+	if c.Cgroups.Root == "" {
+		c.Cgroups.Root = cgroup.DefaultRoot
+	}
+	if c.API.RecentAlerts == 0 {
+		c.API.RecentAlerts = DefaultRecentAlerts
+	}
+	if c.API.HistoryWindow == 0 {
+		c.API.HistoryWindow = DefaultHistoryWindow
+	}
+	if c.Exits.KernelLog == "" {
+		c.Exits.KernelLog = exits.DefaultKernelLog
+	}
+	for i := range c.Thresholds.Actions {
+		c.Thresholds.Actions[i].applyDefaults()
+	}
+	for i := range c.Thresholds.Rules {
+		for j := range c.Thresholds.Rules[i].Actions {
+			c.Thresholds.Rules[i].Actions[j].applyDefaults()
+		}
+	}
+	for i := range c.Notifiers.Webhooks {
+		if c.Notifiers.Webhooks[i].Method == "" {
+			c.Notifiers.Webhooks[i].Method = "POST"
+		}
+	}
+	for i := range c.Notifiers.Teams {
+		if c.Notifiers.Teams[i].Format == "" {
+			c.Notifiers.Teams[i].Format = "message_card"
+		}
+	}
+	for i := range c.Notifiers.Email {
+		if c.Notifiers.Email[i].Port == 0 {
+			c.Notifiers.Email[i].Port = 587
+		}
+	}
+	if c.Alerting.ConsecutiveSamples == 0 {
+		c.Alerting.ConsecutiveSamples = 1
+	}
 }
 
 // Validate checks if the configuration is valid
@@ -38,15 +343,85 @@ func (c *Config) Validate() error {
 	if c.Prometheus.ListenAddress == "" {
 		return fmt.Errorf("prometheus listen_address is required")
 	}
-	if c.Slack.WebhookURL == "" {
-		return fmt.Errorf("slack webhook_url is required")
+	switch c.Prometheus.Aggregation {
+	case AggregatePID, AggregateBuild, AggregateJob:
+	default:
+		return fmt.Errorf("prometheus aggregation must be %s, %s or %s", AggregatePID, AggregateBuild, AggregateJob)
+	}
+	if c.Prometheus.MaxSeries < 0 {
+		return fmt.Errorf("prometheus max_series must not be negative")
+	}
+	for i, w := range c.Notifiers.Webhooks {
+		if w.URL == "" {
+			return fmt.Errorf("notifiers webhooks[%d] url is required", i)
+		}
+	}
+	for i, t := range c.Notifiers.Teams {
+		if t.WebhookURL == "" {
+			return fmt.Errorf("notifiers teams[%d] webhook_url is required", i)
+		}
+		if t.Format != "message_card" && t.Format != "adaptive_card" {
+			return fmt.Errorf("notifiers teams[%d] format must be message_card or adaptive_card", i)
+		}
+	}
+	for i, e := range c.Notifiers.Email {
+		if e.Host == "" || e.From == "" || len(e.To) == 0 {
+			return fmt.Errorf("notifiers email[%d] host, from and to are required", i)
+		}
+		for _, v := range append([]string{e.From}, e.To...) {
+			if strings.ContainsAny(v, "\r\n") {
+				return fmt.Errorf("notifiers email[%d] from and to must not contain line breaks", i)
+			}
+		}
 	}
-	if c.Thresholds.CPUPercent <= 0 || c.Thresholds.CPUPercent > 100 {
+	if c.Thresholds.CPUMode != CPUModeCore && c.Thresholds.CPUMode != CPUModeMachine {
+		return fmt.Errorf("cpu_mode must be %s or %s", CPUModeCore, CPUModeMachine)
+	}
+	if c.Thresholds.CPUPercent <= 0 {
+		return fmt.Errorf("cpu_percent must be greater than 0")
+	}
+	if c.Thresholds.CPUMode == CPUModeMachine && c.Thresholds.CPUPercent > 100 {
 		return fmt.Errorf("cpu_percent must be between 0 and 100")
 	}
 	if c.Thresholds.MemPercent <= 0 || c.Thresholds.MemPercent > 100 {
 		return fmt.Errorf("mem_percent must be between 0 and 100")
 	}
+	if err := c.Thresholds.validate(); err != nil {
+		return err
+	}
+	if c.Interval < time.Second {
+		return fmt.Errorf("interval must be at least 1s")
+	}
+	if c.AdaptiveInterval != 0 && (c.AdaptiveInterval < time.Second || c.AdaptiveInterval > c.Interval) {
+		return fmt.Errorf("adaptive_interval must be between 1s and interval")
+	}
+	if c.Alerting.ConsecutiveSamples < 1 {
+		return fmt.Errorf("alerting consecutive_samples must be at least 1")
+	}
+	if c.Alerting.RepeatInterval < 0 || c.Alerting.Cooldown < 0 {
+		return fmt.Errorf("alerting repeat_interval and cooldown must not be negative")
+	}
+	if c.Builds.EndGrace < 0 {
+		return fmt.Errorf("builds end_grace must not be negative")
+	}
+	if c.Builds.Slack && c.Slack.WebhookURL == "" {
+		return fmt.Errorf("builds slack requires slack webhook_url")
+	}
+	if c.Workspaces.Interval < 0 || c.Workspaces.ScanBudget < 0 {
+		return fmt.Errorf("workspaces interval and scan_budget must not be negative")
+	}
+	if c.Storage != StorageCSV && c.Storage != StorageTSDB {
+		return fmt.Errorf("storage must be %s or %s", StorageCSV, StorageTSDB)
+	}
+	if c.Retention.MaxFileSizeMB < 0 || c.Retention.MaxAge < 0 || c.Retention.MaxTotalSizeMB < 0 || c.Retention.MaxFiles < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	if c.API.RecentAlerts < 0 || c.API.HistoryWindow < 0 {
+		return fmt.Errorf("api recent_alerts and history_window must not be negative")
+	}
+	if err := c.Remediation.compile(); err != nil {
+		return fmt.Errorf("remediation %w", err)
+	}
 	return nil
 }
 
@@ -62,6 +437,8 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config file %s: %w", configPath, err)
 	}
 
+	cfg.applyDefaults()
+
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigCPUPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr bool
+	}{
+		{name: "core mode above 100", yaml: "thresholds:\n  cpu_percent: 150\n  mem_percent: 90\n"},
+		{name: "machine mode within 100", yaml: "thresholds:\n  cpu_mode: machine\n  cpu_percent: 80\n  mem_percent: 90\n"},
+		{name: "machine mode above 100", yaml: "thresholds:\n  cpu_mode: machine\n  cpu_percent: 150\n  mem_percent: 90\n", wantErr: true},
+		{name: "zero", yaml: "thresholds:\n  cpu_percent: 0\n  mem_percent: 90\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			data := "prometheus:\n  listen_address: 127.0.0.1:9000\n" + tt.yaml
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Remediation action types
+const (
+	ActionTerminate = "terminate"
+	ActionRenice    = "renice"
+	ActionHook      = "hook"
+)
+
+// Processes a remediation action is taken on
+const (
+	TargetTop   = "top"
+	TargetBuild = "build"
+)
+
+// IO scheduling classes a renice action can move processes to
+const (
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+// Remediation action defaults
+const (
+	DefaultTerminateGrace = 30 * time.Second
+	DefaultHookTimeout    = time.Minute
+)
+
+// ActionConfig is a remediation action taken when an alert of a threshold
+// rule fires. Rules without actions inherit the global ones.
+type ActionConfig struct {
+	// Type is terminate (SIGTERM, then SIGKILL after Grace), renice or hook
+	Type string `yaml:"type"`
+	// On lists the alert types that trigger the action; empty means any alert of the rule
+	On []string `yaml:"on"`
+	// After delays the action until the alert has been firing this long
+	After time.Duration `yaml:"after"`
+	// Target is top, the process contributing most to the alert, or build,
+	// every process of the build
+	Target string        `yaml:"target"`
+	Grace  time.Duration `yaml:"grace"`
+	// Nice is the niceness set by renice, and IOClass and IOLevel the IO
+	// scheduling class and priority (0 highest, 7 lowest) it sets
+	Nice    *int   `yaml:"nice"`
+	IOClass string `yaml:"ionice"`
+	IOLevel int    `yaml:"ionice_level"`
+	// Command is run by hook once per target process, with the process's
+	// fields as environment variables, and killed after Timeout
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Triggers reports whether an alert of the given type triggers the action
+func (a *ActionConfig) Triggers(alertType string) bool {
+	return len(a.On) == 0 || slices.Contains(a.On, alertType)
+}
+
+// applyDefaults fills the target, grace period and hook timeout
+func (a *ActionConfig) applyDefaults() {
+	if a.Target == "" {
+		a.Target = TargetTop
+	}
+	if a.Type == ActionTerminate && a.Grace == 0 {
+		a.Grace = DefaultTerminateGrace
+	}
+	if a.Type == ActionHook && a.Timeout == 0 {
+		a.Timeout = DefaultHookTimeout
+	}
+}
+
+func (a *ActionConfig) validate() error {
+	switch a.Type {
+	case ActionTerminate, ActionRenice, ActionHook:
+	default:
+		return fmt.Errorf("type must be %s, %s or %s", ActionTerminate, ActionRenice, ActionHook)
+	}
+	if a.Target != TargetTop && a.Target != TargetBuild {
+		return fmt.Errorf("target must be %s or %s", TargetTop, TargetBuild)
+	}
+	if a.After < 0 || a.Grace < 0 || a.Timeout < 0 {
+		return fmt.Errorf("after, grace and timeout must not be negative")
+	}
+	switch a.Type {
+	case ActionRenice:
+		if a.Nice == nil && a.IOClass == "" {
+			return fmt.Errorf("renice needs nice or ionice")
+		}
+		if a.Nice != nil && (*a.Nice < -20 || *a.Nice > 19) {
+			return fmt.Errorf("nice must be between -20 and 19")
+		}
+		if a.IOClass != "" && a.IOClass != IOClassBestEffort && a.IOClass != IOClassIdle {
+			return fmt.Errorf("ionice must be %s or %s", IOClassBestEffort, IOClassIdle)
+		}
+		if a.IOLevel < 0 || a.IOLevel > 7 {
+			return fmt.Errorf("ionice_level must be between 0 and 7")
+		}
+	case ActionHook:
+		if a.Command == "" {
+			return fmt.Errorf("hook needs a command")
+		}
+	}
+	return nil
+}
+
+// HasActions reports whether the global thresholds or any rule have actions
+func (t *ThresholdsConfig) HasActions() bool {
+	if len(t.Actions) > 0 {
+		return true
+	}
+	for _, r := range t.Rules {
+		if len(r.Actions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RemediationConfig controls whether and on which jobs the actions of the
+// threshold rules are taken
+type RemediationConfig struct {
+	// DryRun logs, audits and notifies the actions without taking them
+	DryRun bool `yaml:"dry_run"`
+	// AllowedJobs are the jobs actions may be taken on, as rule patterns;
+	// without any, no action is taken
+	AllowedJobs []string `yaml:"allowed_jobs"`
+	// AuditLog receives one JSON line per action; empty uses
+	// <output file without extension>.remediation.jsonl
+	AuditLog string `yaml:"audit_log"`
+
+	allowed  []*regexp.Regexp
+	compiled bool
+}
+
+// compile parses the allowed job patterns
+func (r *RemediationConfig) compile() error {
+	r.allowed = nil
+	for _, pattern := range r.AllowedJobs {
+		if pattern == "" {
+			return fmt.Errorf("allowed_jobs must not contain empty patterns")
+		}
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return fmt.Errorf("allowed_jobs %s: %w", pattern, err)
+		}
+		r.allowed = append(r.allowed, re)
+	}
+	r.compiled = true
+	return nil
+}
+
+// Allows reports whether actions may be taken on the builds of job
+func (r *RemediationConfig) Allows(job string) bool {
+	if !r.compiled {
+		// Configurations built outside LoadConfig have not been compiled yet
+		if err := r.compile(); err != nil {
+			return false
+		}
+	}
+	for _, re := range r.allowed {
+		if re.MatchString(job) {
+			return true
+		}
+	}
+	return false
+}
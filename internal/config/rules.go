@@ -0,0 +1,253 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Alert severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// DefaultRuleName is reported for builds that match no threshold rule
+const DefaultRuleName = "default"
+
+// ThresholdRule overrides the global thresholds for builds matching all of its
+// patterns. Patterns are globs where '*' matches any run of characters
+// (including '/'), or regular expressions when prefixed with "re:". An empty
+// pattern matches everything. Omitted limits inherit the global thresholds.
+type ThresholdRule struct {
+	Name        string        `yaml:"name"`
+	Job         string        `yaml:"job"`
+	Stage       string        `yaml:"stage"`
+	AgentLabel  string        `yaml:"agent_label"`
+	CPUPercent  float64       `yaml:"cpu_percent"`
+	MemPercent  float64       `yaml:"mem_percent"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// Workspace limits in MB and MB per minute
+	WorkspaceSizeMB         float64 `yaml:"workspace_size_mb"`
+	WorkspaceGrowthMBPerMin float64 `yaml:"workspace_growth_mb_per_min"`
+	RSSMB                   float64 `yaml:"rss_mb"`
+	MaxThreads              int     `yaml:"max_threads"`
+	MaxOpenFDs              int     `yaml:"max_open_fds"`
+	FDPercent               float64 `yaml:"fd_percent"`
+	CgroupMemPercent        float64 `yaml:"cgroup_mem_percent"`
+	CgroupThrottledPercent  float64 `yaml:"cgroup_throttled_percent"`
+	Severity                string  `yaml:"severity"`
+	// Actions replace the global actions for builds matching the rule
+	Actions []ActionConfig `yaml:"actions"`
+
+	job, stage, agentLabel *regexp.Regexp
+	compiled               bool
+}
+
+// compile parses the rule's patterns
+func (r *ThresholdRule) compile() error {
+	var err error
+	if r.job, err = compilePattern(r.Job); err != nil {
+		return fmt.Errorf("job: %w", err)
+	}
+	if r.stage, err = compilePattern(r.Stage); err != nil {
+		return fmt.Errorf("stage: %w", err)
+	}
+	if r.agentLabel, err = compilePattern(r.AgentLabel); err != nil {
+		return fmt.Errorf("agent_label: %w", err)
+	}
+	r.compiled = true
+	return nil
+}
+
+// Matches reports whether a build with the given job name, stages and agent
+// labels is covered by the rule. The stage and label patterns match when any
+// of the build's stages or labels match.
+func (r *ThresholdRule) Matches(job string, stages, labels []string) bool {
+	if !r.compiled {
+		// Rules built outside LoadConfig have not been compiled yet
+		if err := r.compile(); err != nil {
+			return false
+		}
+	}
+	return matchAny(r.job, []string{job}) && matchAny(r.stage, stages) && matchAny(r.agentLabel, labels)
+}
+
+// Match returns the effective thresholds for a build: the first rule that
+// matches, with omitted limits filled from the global thresholds, or the
+// global thresholds themselves under the name "default".
+func (t *ThresholdsConfig) Match(job string, stages, labels []string) ThresholdRule {
+	effective := ThresholdRule{
+		Name:                    DefaultRuleName,
+		CPUPercent:              t.CPUPercent,
+		MemPercent:              t.MemPercent,
+		MaxDuration:             t.MaxDuration,
+		WorkspaceSizeMB:         t.WorkspaceSizeMB,
+		WorkspaceGrowthMBPerMin: t.WorkspaceGrowthMBPerMin,
+		RSSMB:                   t.RSSMB,
+		MaxThreads:              t.MaxThreads,
+		MaxOpenFDs:              t.MaxOpenFDs,
+		FDPercent:               t.FDPercent,
+		CgroupMemPercent:        t.CgroupMemPercent,
+		CgroupThrottledPercent:  t.CgroupThrottledPercent,
+		Severity:                t.Severity,
+		Actions:                 t.Actions,
+	}
+
+	for i := range t.Rules {
+		r := &t.Rules[i]
+		if !r.Matches(job, stages, labels) {
+			continue
+		}
+		effective.Name = r.Name
+		effective.Job, effective.Stage, effective.AgentLabel = r.Job, r.Stage, r.AgentLabel
+		if r.CPUPercent > 0 {
+			effective.CPUPercent = r.CPUPercent
+		}
+		if r.MemPercent > 0 {
+			effective.MemPercent = r.MemPercent
+		}
+		if r.MaxDuration > 0 {
+			effective.MaxDuration = r.MaxDuration
+		}
+		if r.WorkspaceSizeMB > 0 {
+			effective.WorkspaceSizeMB = r.WorkspaceSizeMB
+		}
+		if r.WorkspaceGrowthMBPerMin > 0 {
+			effective.WorkspaceGrowthMBPerMin = r.WorkspaceGrowthMBPerMin
+		}
+		if r.RSSMB > 0 {
+			effective.RSSMB = r.RSSMB
+		}
+		if r.MaxThreads > 0 {
+			effective.MaxThreads = r.MaxThreads
+		}
+		if r.MaxOpenFDs > 0 {
+			effective.MaxOpenFDs = r.MaxOpenFDs
+		}
+		if r.FDPercent > 0 {
+			effective.FDPercent = r.FDPercent
+		}
+		if r.CgroupMemPercent > 0 {
+			effective.CgroupMemPercent = r.CgroupMemPercent
+		}
+		if r.CgroupThrottledPercent > 0 {
+			effective.CgroupThrottledPercent = r.CgroupThrottledPercent
+		}
+		if r.Severity != "" {
+			effective.Severity = r.Severity
+		}
+		if len(r.Actions) > 0 {
+			effective.Actions = r.Actions
+		}
+		break
+	}
+
+	return effective
+}
+
+// validate checks the global severity and every rule, compiling rule patterns
+func (t *ThresholdsConfig) validate() error {
+	if t.MaxDuration < 0 {
+		return fmt.Errorf("max_duration must not be negative")
+	}
+	if t.WorkspaceSizeMB < 0 || t.WorkspaceGrowthMBPerMin < 0 {
+		return fmt.Errorf("workspace_size_mb and workspace_growth_mb_per_min must not be negative")
+	}
+	if t.RSSMB < 0 || t.MaxThreads < 0 || t.MaxOpenFDs < 0 {
+		return fmt.Errorf("rss_mb, max_threads and max_open_fds must not be negative")
+	}
+	if t.FDPercent < 0 || t.FDPercent > 100 {
+		return fmt.Errorf("fd_percent must be between 0 and 100")
+	}
+	if t.CgroupMemPercent < 0 || t.CgroupMemPercent > 100 || t.CgroupThrottledPercent < 0 || t.CgroupThrottledPercent > 100 {
+		return fmt.Errorf("cgroup_mem_percent and cgroup_throttled_percent must be between 0 and 100")
+	}
+	if !validSeverity(t.Severity) {
+		return fmt.Errorf("severity must be %s, %s or %s", SeverityInfo, SeverityWarning, SeverityCritical)
+	}
+	for i := range t.Actions {
+		if err := t.Actions[i].validate(); err != nil {
+			return fmt.Errorf("actions[%d]: %w", i, err)
+		}
+	}
+	for i := range t.Rules {
+		r := &t.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("threshold rule %s: %w", r.Name, err)
+		}
+		if r.CPUPercent < 0 || r.MemPercent < 0 || r.MaxDuration < 0 || r.WorkspaceSizeMB < 0 || r.WorkspaceGrowthMBPerMin < 0 ||
+			r.RSSMB < 0 || r.MaxThreads < 0 || r.MaxOpenFDs < 0 || r.FDPercent < 0 || r.CgroupMemPercent < 0 || r.CgroupThrottledPercent < 0 {
+			return fmt.Errorf("threshold rule %s: limits must not be negative", r.Name)
+		}
+		if t.CPUMode == CPUModeMachine && r.CPUPercent > 100 {
+			return fmt.Errorf("threshold rule %s: cpu_percent must be between 0 and 100", r.Name)
+		}
+		if r.MemPercent > 100 {
+			return fmt.Errorf("threshold rule %s: mem_percent must be between 0 and 100", r.Name)
+		}
+		if r.FDPercent > 100 {
+			return fmt.Errorf("threshold rule %s: fd_percent must be between 0 and 100", r.Name)
+		}
+		if r.CgroupMemPercent > 100 || r.CgroupThrottledPercent > 100 {
+			return fmt.Errorf("threshold rule %s: cgroup_mem_percent and cgroup_throttled_percent must be between 0 and 100", r.Name)
+		}
+		if r.Severity != "" && !validSeverity(r.Severity) {
+			return fmt.Errorf("threshold rule %s: severity must be %s, %s or %s", r.Name, SeverityInfo, SeverityWarning, SeverityCritical)
+		}
+		for j := range r.Actions {
+			if err := r.Actions[j].validate(); err != nil {
+				return fmt.Errorf("threshold rule %s: actions[%d]: %w", r.Name, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validSeverity(s string) bool {
+	return s == SeverityInfo || s == SeverityWarning || s == SeverityCritical
+}
+
+// compilePattern turns a glob or "re:" regular expression into a regexp; an
+// empty pattern yields nil, which matches everything
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		return regexp.Compile(expr)
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// matchAny reports whether re matches any of values; a nil re matches everything
+func matchAny(re *regexp.Regexp, values []string) bool {
+	if re == nil {
+		return true
+	}
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholdsMatch(t *testing.T) {
+	thresholds := ThresholdsConfig{
+		CPUPercent:  80,
+		MemPercent:  70,
+		MaxDuration: time.Hour,
+		CPUMode:     CPUModeCore,
+		Severity:    SeverityWarning,
+		Rules: []ThresholdRule{
+			{Name: "lint", Job: "*-lint", CPUPercent: 10, Severity: SeverityInfo},
+			{Name: "release", Job: "re:^release/(main|hotfix-.*)$", CPUPercent: 95, MaxDuration: 3 * time.Hour, Severity: SeverityCritical},
+			{Name: "docker-tests", Stage: "Integration*", AgentLabel: "docker", MemPercent: 90},
+		},
+	}
+	if err := thresholds.validate(); err != nil {
+		t.Fatalf("validate() error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		job      string
+		stages   []string
+		labels   []string
+		wantRule string
+		wantCPU  float64
+		wantMem  float64
+		wantDur  time.Duration
+		wantSev  string
+	}{
+		{
+			name: "glob on job name", job: "frontend-lint",
+			wantRule: "lint", wantCPU: 10, wantMem: 70, wantDur: time.Hour, wantSev: SeverityInfo,
+		},
+		{
+			name: "regex on folder job", job: "release/hotfix-1.2",
+			wantRule: "release", wantCPU: 95, wantMem: 70, wantDur: 3 * time.Hour, wantSev: SeverityCritical,
+		},
+		{
+			name: "stage and agent label", job: "backend", stages: []string{"Build", "Integration Tests"}, labels: []string{"agent-7", "linux", "docker"},
+			wantRule: "docker-tests", wantCPU: 80, wantMem: 90, wantDur: time.Hour, wantSev: SeverityWarning,
+		},
+		{
+			name: "stage without label", job: "backend", stages: []string{"Integration Tests"}, labels: []string{"linux"},
+			wantRule: DefaultRuleName, wantCPU: 80, wantMem: 70, wantDur: time.Hour, wantSev: SeverityWarning,
+		},
+		{
+			name: "first match wins", job: "release/main-lint",
+			wantRule: "lint", wantCPU: 10, wantMem: 70, wantDur: time.Hour, wantSev: SeverityInfo,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := thresholds.Match(tt.job, tt.stages, tt.labels)
+			if got.Name != tt.wantRule || got.CPUPercent != tt.wantCPU || got.MemPercent != tt.wantMem ||
+				got.MaxDuration != tt.wantDur || got.Severity != tt.wantSev {
+				t.Errorf("Match() = %+v, want rule %s cpu %.0f mem %.0f duration %s severity %s",
+					got, tt.wantRule, tt.wantCPU, tt.wantMem, tt.wantDur, tt.wantSev)
+			}
+		})
+	}
+}
+
+func TestThresholdsMatchInheritsProcessLimits(t *testing.T) {
+	thresholds := ThresholdsConfig{
+		CPUMode:    CPUModeCore,
+		Severity:   SeverityWarning,
+		MaxThreads: 500,
+		MaxOpenFDs: 4000,
+		FDPercent:  90,
+		Rules: []ThresholdRule{
+			{Name: "gradle", Job: "gradle-*", MaxThreads: 3000, RSSMB: 8192},
+		},
+	}
+	if err := thresholds.validate(); err != nil {
+		t.Fatalf("validate() error: %v", err)
+	}
+
+	got := thresholds.Match("gradle-build", nil, nil)
+	if got.MaxThreads != 3000 || got.RSSMB != 8192 || got.MaxOpenFDs != 4000 || got.FDPercent != 90 {
+		t.Errorf("Match() = %+v, want threads 3000, rss 8192 MB, fds 4000 and fd share 90 inherited", got)
+	}
+}
+
+func TestThresholdsValidateRejectsBadRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ThresholdRule
+	}{
+		{name: "invalid regex", rule: ThresholdRule{Job: "re:("}},
+		{name: "unknown severity", rule: ThresholdRule{Severity: "page"}},
+		{name: "memory above 100", rule: ThresholdRule{MemPercent: 150}},
+		{name: "negative workspace size", rule: ThresholdRule{WorkspaceSizeMB: -1}},
+		{name: "negative thread limit", rule: ThresholdRule{MaxThreads: -1}},
+		{name: "fd share above 100", rule: ThresholdRule{FDPercent: 120}},
+		{name: "cgroup memory above 100", rule: ThresholdRule{CgroupMemPercent: 101}},
+		{name: "unknown action", rule: ThresholdRule{Actions: []ActionConfig{{Type: "reboot", Target: TargetTop}}}},
+		{name: "renice without priority", rule: ThresholdRule{Actions: []ActionConfig{{Type: ActionRenice, Target: TargetTop}}}},
+		{name: "hook without command", rule: ThresholdRule{Actions: []ActionConfig{{Type: ActionHook, Target: TargetBuild}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thresholds := ThresholdsConfig{CPUMode: CPUModeCore, Severity: SeverityWarning, Rules: []ThresholdRule{tt.rule}}
+			if err := thresholds.validate(); err == nil {
+				t.Errorf("validate() accepted %+v", tt.rule)
+			}
+		})
+	}
+}
+
+func TestThresholdsMatchInheritsActions(t *testing.T) {
+	global := []ActionConfig{{Type: ActionRenice, IOClass: IOClassIdle}}
+	own := []ActionConfig{{Type: ActionTerminate, On: []string{"MEM_HIGH"}}}
+	thresholds := ThresholdsConfig{
+		CPUMode: CPUModeCore, Severity: SeverityWarning, Actions: global,
+		Rules: []ThresholdRule{{Name: "leaky", Job: "nightly-*", Actions: own}, {Name: "lint", Job: "*-lint"}},
+	}
+
+	if got := thresholds.Match("nightly-app", nil, nil).Actions; len(got) != 1 || got[0].Type != ActionTerminate {
+		t.Errorf("rule actions = %+v, want its own", got)
+	}
+	if got := thresholds.Match("app-lint", nil, nil).Actions; len(got) != 1 || got[0].Type != ActionRenice {
+		t.Errorf("rule without actions = %+v, want the global ones", got)
+	}
+	if !own[0].Triggers("MEM_HIGH") || own[0].Triggers("CPU_HIGH") || !global[0].Triggers("CPU_HIGH") {
+		t.Error("Triggers() should match the listed alert types, or any when none are listed")
+	}
+}
+
+func TestRemediationAllows(t *testing.T) {
+	r := RemediationConfig{AllowedJobs: []string{"nightly-*", "re:^sandbox/"}}
+	for job, want := range map[string]bool{"nightly-app": true, "sandbox/alice": true, "release/main": false} {
+		if got := r.Allows(job); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", job, got, want)
+		}
+	}
+	if (&RemediationConfig{}).Allows("nightly-app") {
+		t.Error("an empty allowlist should allow no job")
+	}
+}
@@ -0,0 +1,366 @@
+// The dashboard only reads the JSON API of the monitor; it has no state of
+// its own beyond the selected job and range.
+(function () {
+  "use strict";
+
+  var MIN_REFRESH_MS = 5000;
+  var REPORT_REFRESH_MS = 60000;
+
+  var refreshMs = MIN_REFRESH_MS;
+  var selectedJob = "";
+  var lastReport = 0;
+  // emptyText keeps the placeholders that load errors temporarily replace
+  var emptyText = {};
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function getJSON(url) {
+    return fetch(url, { headers: { Accept: "application/json" } }).then(function (resp) {
+      return resp.json().then(function (body) {
+        if (!resp.ok) {
+          var err = new Error(body.error || resp.statusText);
+          err.status = resp.status;
+          throw err;
+        }
+        return body;
+      });
+    });
+  }
+
+  // el creates an element with optional class and text content
+  function el(tag, className, text) {
+    var e = document.createElement(tag);
+    if (className) {
+      e.className = className;
+    }
+    if (text !== undefined && text !== null) {
+      e.textContent = String(text);
+    }
+    return e;
+  }
+
+  function cell(row, text, className) {
+    var td = el("td", className, text);
+    row.appendChild(td);
+    return td;
+  }
+
+  function fill(tableId, emptyId, rows) {
+    var body = $(tableId).tBodies[0];
+    body.replaceChildren.apply(body, rows);
+    if (emptyId) {
+      $(emptyId).textContent = emptyText[emptyId];
+      $(emptyId).hidden = rows.length > 0;
+    }
+  }
+
+  // formatBytes mirrors utils.FormatBytes
+  function formatBytes(b) {
+    b = Math.max(0, Math.round(b));
+    if (b < 1024) {
+      return b + "B";
+    }
+    var units = "KMGTPE";
+    var div = 1024;
+    var exp = 0;
+    for (var n = Math.floor(b / 1024); n >= 1024; n = Math.floor(n / 1024)) {
+      div *= 1024;
+      exp++;
+    }
+    return (b / div).toFixed(1) + units[exp] + "iB";
+  }
+
+  function formatDuration(seconds) {
+    seconds = Math.round(seconds);
+    var h = Math.floor(seconds / 3600);
+    var m = Math.floor((seconds % 3600) / 60);
+    var s = seconds % 60;
+    if (h > 0) {
+      return h + "h" + m + "m" + s + "s";
+    }
+    if (m > 0) {
+      return m + "m" + s + "s";
+    }
+    return s + "s";
+  }
+
+  function formatPercent(v) {
+    return v.toFixed(2) + "%";
+  }
+
+  // formatValue mirrors alert.FormatValue
+  function formatValue(type, v) {
+    switch (type) {
+      case "DURATION_HIGH":
+        return formatDuration(v);
+      case "WORKSPACE_SIZE_HIGH":
+      case "RSS_HIGH":
+      case "OOM_KILLED":
+      case "PROCESS_CRASHED":
+        return formatBytes(v);
+      case "THREADS_HIGH":
+      case "FDS_HIGH":
+        return v.toFixed(0);
+      case "WORKSPACE_GROWTH_HIGH":
+        return formatBytes(Math.max(v, 0) * 60) + "/min";
+    }
+    return formatPercent(v);
+  }
+
+  function formatTime(t) {
+    if (!t) {
+      return "";
+    }
+    var d = new Date(t);
+    if (isNaN(d.getTime()) || d.getFullYear() < 1970) {
+      return "";
+    }
+    return d.toLocaleString();
+  }
+
+  // sparkline draws values as an SVG polyline scaled to their maximum
+  function sparkline(values, className) {
+    var ns = "http://www.w3.org/2000/svg";
+    var width = 120;
+    var height = 24;
+    var svg = document.createElementNS(ns, "svg");
+    svg.setAttribute("class", "spark " + className);
+    svg.setAttribute("width", width);
+    svg.setAttribute("height", height);
+    svg.setAttribute("viewBox", "0 0 " + width + " " + height);
+    if (values.length === 0) {
+      return svg;
+    }
+    var peak = Math.max.apply(null, values.concat([1]));
+    var step = values.length > 1 ? width / (values.length - 1) : 0;
+    var points = values.map(function (v, i) {
+      var x = values.length > 1 ? i * step : width / 2;
+      var y = height - 1 - (v / peak) * (height - 2);
+      return x.toFixed(1) + "," + y.toFixed(1);
+    });
+    if (values.length === 1) {
+      points.unshift("0," + points[0].split(",")[1]);
+    }
+    var line = document.createElementNS(ns, "polyline");
+    line.setAttribute("points", points.join(" "));
+    svg.appendChild(line);
+    var title = document.createElementNS(ns, "title");
+    title.textContent = "peak " + formatPercent(Math.max.apply(null, values));
+    svg.appendChild(title);
+    return svg;
+  }
+
+  function sparkCell(row, points, field, className) {
+    var td = cell(row, null);
+    td.appendChild(sparkline(points.map(function (p) { return p[field]; }), className));
+  }
+
+  function jobCell(row, job) {
+    var td = cell(row, job, "job");
+    td.title = "Show the history of " + job;
+    td.addEventListener("click", function () {
+      showJob(job);
+    });
+  }
+
+  // refreshHealth reads /readyz, whose body is the same when it is not ready
+  function refreshHealth() {
+    return fetch("/readyz").then(function (resp) {
+      return resp.json();
+    }).then(function (h) {
+      var ready = h.status === "ready";
+      var text = ready ? "ready" : "not ready";
+      if (h.last_collection) {
+        text += " · last sample " + Math.round(h.last_collection_age_seconds) + "s ago";
+      }
+      text += " · " + h.builds + " builds, " + h.processes + " processes";
+      if (h.last_error) {
+        text += " · last error: " + h.last_error;
+      }
+      $("health").textContent = text;
+      $("health").className = "health " + (ready ? "ok" : "down");
+      if (h.interval_seconds > 0) {
+        refreshMs = Math.max(MIN_REFRESH_MS, h.interval_seconds * 1000);
+      }
+    }).catch(function (err) {
+      $("health").textContent = "unreachable: " + err.message;
+      $("health").className = "health down";
+    });
+  }
+
+  // historyOf fetches the in-memory history of each job and indexes it by build
+  function historyOf(jobs) {
+    var byBuild = {};
+    return Promise.all(jobs.map(function (job) {
+      var url = "/api/v1/history?source=memory&job=" + encodeURIComponent(job);
+      return getJSON(url).then(function (h) {
+        h.builds.forEach(function (b) {
+          byBuild[job + "\u0000" + b.build_id] = b.points;
+        });
+      }).catch(function () {});
+    })).then(function () {
+      return byBuild;
+    });
+  }
+
+  function refreshBuilds() {
+    return getJSON("/api/v1/builds").then(function (snap) {
+      var jobs = [];
+      snap.builds.forEach(function (b) {
+        if (jobs.indexOf(b.job) < 0) {
+          jobs.push(b.job);
+        }
+      });
+      return historyOf(jobs).then(function (history) {
+        fill("builds", "builds-empty", snap.builds.map(function (b) {
+          var points = history[b.job + "\u0000" + b.build_id] || [];
+          var row = el("tr");
+          jobCell(row, b.job);
+          cell(row, b.build_id);
+          cell(row, (b.stages || []).join(" › "));
+          cell(row, b.process_count, "num");
+          cell(row, formatPercent(b.cpu_percent), "num");
+          sparkCell(row, points, "cpu_percent", "cpu");
+          cell(row, formatPercent(b.mem_percent), "num");
+          sparkCell(row, points, "mem_percent", "mem");
+          cell(row, formatBytes(b.rss_bytes), "num");
+          cell(row, formatTime(b.start_time));
+          return row;
+        }));
+      });
+    }).catch(function (err) {
+      $("builds-empty").textContent = "Failed to load builds: " + err.message;
+      $("builds-empty").hidden = false;
+    });
+  }
+
+  function refreshAlerts() {
+    return getJSON("/api/v1/alerts?limit=20").then(function (resp) {
+      fill("alerts", "alerts-empty", resp.alerts.map(function (a) {
+        var unit = a.action ? a.action.alert : a.type;
+        var row = el("tr");
+        cell(row, formatTime(a.timestamp));
+        cell(row, a.action ? a.action.action : a.state, a.action ? "action" : a.state);
+        jobCell(row, a.job_name);
+        cell(row, a.build_id);
+        cell(row, a.title);
+        cell(row, formatValue(unit, a.value), "num");
+        cell(row, a.threshold ? formatValue(unit, a.threshold) : "", "num");
+        return row;
+      }));
+    }).catch(function (err) {
+      $("alerts-empty").textContent = "Failed to load alerts: " + err.message;
+      $("alerts-empty").hidden = false;
+    });
+  }
+
+  function refreshReport() {
+    lastReport = Date.now();
+    var url = "/api/v1/report?top=10&since=" + encodeURIComponent($("range").value);
+    return getJSON(url).then(function (r) {
+      var note = r.jobs_analyzed + " jobs analyzed, generated " + formatTime(r.generated_at);
+      if (r.skipped_rows > 0) {
+        note += " (" + r.skipped_rows + " malformed rows skipped)";
+      }
+      $("report-note").textContent = note;
+      fill("top-cpu", null, r.top_cpu.map(function (j) {
+        var row = el("tr");
+        jobCell(row, j.job);
+        cell(row, formatPercent(j.cpu.peak), "num");
+        cell(row, formatPercent(j.cpu.p95), "num");
+        cell(row, formatPercent(j.cpu.mean), "num");
+        cell(row, formatDuration(j.cpu_seconds), "num");
+        return row;
+      }));
+      fill("top-memory", null, r.top_memory.map(function (j) {
+        var row = el("tr");
+        jobCell(row, j.job);
+        cell(row, formatPercent(j.memory.peak), "num");
+        cell(row, formatPercent(j.memory.p95), "num");
+        cell(row, formatPercent(j.memory.mean), "num");
+        cell(row, j.samples, "num");
+        return row;
+      }));
+    }).catch(function (err) {
+      $("report-note").textContent = err.status === 404
+        ? "The report needs the monitor's output files; collection is disabled."
+        : "Failed to load the report: " + err.message;
+      fill("top-cpu", null, []);
+      fill("top-memory", null, []);
+    });
+  }
+
+  function refreshJob() {
+    if (!selectedJob) {
+      return Promise.resolve();
+    }
+    var job = selectedJob;
+    var url = "/api/v1/history?job=" + encodeURIComponent(job) + "&since=" + encodeURIComponent($("range").value);
+    return getJSON(url).then(function (h) {
+      if (job !== selectedJob) {
+        return;
+      }
+      var note = h.source === "memory"
+        ? "From the samples kept in memory; older samples are only in the output files."
+        : "From the monitor's output files.";
+      if (h.skipped_rows > 0) {
+        note += " " + h.skipped_rows + " malformed rows skipped.";
+      }
+      $("job-source").textContent = note;
+      fill("job-builds", "job-empty", h.builds.map(function (b) {
+        var cpu = b.points.map(function (p) { return p.cpu_percent; });
+        var mem = b.points.map(function (p) { return p.mem_percent; });
+        var row = el("tr");
+        cell(row, b.build_id);
+        cell(row, b.points.length, "num");
+        cell(row, formatPercent(Math.max.apply(null, cpu)), "num");
+        sparkCell(row, b.points, "cpu_percent", "cpu");
+        cell(row, formatPercent(Math.max.apply(null, mem)), "num");
+        sparkCell(row, b.points, "mem_percent", "mem");
+        cell(row, formatTime(b.points[0].time));
+        cell(row, formatTime(b.points[b.points.length - 1].time));
+        return row;
+      }));
+    }).catch(function (err) {
+      $("job-source").textContent = "Failed to load the history: " + err.message;
+      fill("job-builds", null, []);
+    });
+  }
+
+  function showJob(job) {
+    selectedJob = job;
+    $("job-name").textContent = job;
+    $("job").hidden = false;
+    $("job-source").textContent = "Loading…";
+    fill("job-builds", null, []);
+    $("job-empty").hidden = true;
+    refreshJob();
+    $("job").scrollIntoView({ behavior: "smooth" });
+  }
+
+  function refresh() {
+    var pending = [refreshHealth(), refreshBuilds(), refreshAlerts(), refreshJob()];
+    if (Date.now() - lastReport >= REPORT_REFRESH_MS) {
+      pending.push(refreshReport());
+    }
+    Promise.all(pending).then(function () {
+      setTimeout(refresh, refreshMs);
+    });
+  }
+
+  ["builds-empty", "job-empty", "alerts-empty"].forEach(function (id) {
+    emptyText[id] = $(id).textContent;
+  });
+  $("job-close").addEventListener("click", function () {
+    selectedJob = "";
+    $("job").hidden = true;
+  });
+  $("range").addEventListener("change", function () {
+    refreshReport();
+    refreshJob();
+  });
+
+  refresh();
+})();
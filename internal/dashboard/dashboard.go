@@ -0,0 +1,28 @@
+package dashboard
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+)
+
+// assets are the page, script and stylesheet of the dashboard. They only
+// read the JSON API, so the dashboard needs the API to be enabled.
+//
+//go:embed assets
+var assets embed.FS
+
+// Path is where the dashboard is served
+const Path = "/dashboard/"
+
+// Register serves the dashboard under Path and redirects / to it
+func Register(mux *http.ServeMux) error {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		return fmt.Errorf("failed to load dashboard assets: %w", err)
+	}
+	mux.Handle("GET "+Path, http.StripPrefix(Path, http.FileServerFS(sub)))
+	mux.Handle("GET /{$}", http.RedirectHandler(Path, http.StatusFound))
+	return nil
+}
@@ -0,0 +1,43 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	mux := http.NewServeMux()
+	if err := Register(mux); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	tests := []struct {
+		url      string
+		code     int
+		location string
+		contains string
+	}{
+		{"/", http.StatusFound, Path, ""},
+		{Path, http.StatusOK, "", "dashboard.js"},
+		{Path + "dashboard.js", http.StatusOK, "", "/api/v1/builds"},
+		{Path + "dashboard.css", http.StatusOK, "", "svg.spark"},
+		{Path + "missing.js", http.StatusNotFound, "", ""},
+		{"/other", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rec.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.url, rec.Code, tt.code)
+			continue
+		}
+		if loc := rec.Header().Get("Location"); loc != tt.location {
+			t.Errorf("GET %s Location = %q, want %q", tt.url, loc, tt.location)
+		}
+		if !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("GET %s body does not contain %q", tt.url, tt.contains)
+		}
+	}
+}
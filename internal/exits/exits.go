@@ -0,0 +1,197 @@
+package exits
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/process"
+	"jenkins-monitor/internal/utils"
+)
+
+// Cause is why a build process exited abnormally
+type Cause string
+
+const (
+	// OOMKill is a process killed by the kernel OOM killer
+	OOMKill Cause = "oom_kill"
+	// Crash is a process killed by a fatal fault such as a segmentation fault
+	Crash Cause = "crash"
+)
+
+// Exit is a build process that disappeared between two passes, with the cause
+// it was attributed to
+type Exit struct {
+	Cause Cause
+	// Evidence is the kernel log message or the cgroup counter that gave the cause away
+	Evidence string
+	// Process and Build are the last samples taken before the process exited
+	Process process.ProcessInfo
+	Build   process.BuildInfo
+	Time    time.Time
+}
+
+// OOMCounter re-reads the OOM kill counter of a cgroup; false when the cgroup is gone
+type OOMCounter func(cgroup.Stats) (uint64, bool)
+
+// Detector compares the build processes of consecutive passes and attributes
+// the ones that disappeared to the OOM killer or a crash when the kernel log
+// or the OOM kill counter of their cgroup says so. Processes that exited on
+// their own are not reported: the monitor is not their parent, so their exit
+// status cannot be seen.
+type Detector struct {
+	kernel   KernelLog  // nil when the kernel log cannot be read
+	oomKills OOMCounter // nil when cgroups are not read
+	procs    map[procKey]process.ProcessInfo
+	builds   map[string]process.BuildInfo
+	// pending holds kernel events by PID until the process is seen to be gone
+	pending map[int32]pendingEvent
+}
+
+// procKey tells a process apart from a later one reusing its PID
+type procKey struct {
+	pid   int32
+	start time.Time
+}
+
+type pendingEvent struct {
+	KernelEvent
+	passes int
+}
+
+// maxPendingPasses is how many passes a kernel event waits for its process to
+// disappear; events about processes that were never tracked expire after it
+const maxPendingPasses = 2
+
+// NewDetector creates a Detector. Either source of evidence may be nil.
+func NewDetector(kernel KernelLog, oomKills OOMCounter) *Detector {
+	return &Detector{
+		kernel:   kernel,
+		oomKills: oomKills,
+		procs:    make(map[procKey]process.ProcessInfo),
+		builds:   make(map[string]process.BuildInfo),
+		pending:  make(map[int32]pendingEvent),
+	}
+}
+
+// Close releases the kernel log
+func (d *Detector) Close() error {
+	if d.kernel == nil {
+		return nil
+	}
+	return d.kernel.Close()
+}
+
+// Update takes the builds of a pass and returns the processes of the previous
+// pass that were OOM-killed or crashed since, ordered by build and PID
+func (d *Detector) Update(builds []process.BuildInfo, now time.Time) []Exit {
+	procs := make(map[procKey]process.ProcessInfo)
+	byKey := make(map[string]process.BuildInfo, len(builds))
+	for _, b := range builds {
+		byKey[b.Key()] = b
+		for _, p := range b.Processes {
+			procs[procKey{p.PID, p.StartTime}] = p.ProcessInfo
+		}
+	}
+
+	if d.kernel != nil {
+		events, err := d.kernel.Read()
+		if err != nil {
+			utils.Error(fmt.Sprintf("Failed to read kernel log: %v", err))
+		}
+		for _, ev := range events {
+			d.pending[ev.PID] = pendingEvent{KernelEvent: ev}
+		}
+	}
+
+	// The OOM killer picks the largest process, so the largest are blamed first
+	var gone []process.ProcessInfo
+	for key, p := range d.procs {
+		if _, ok := procs[key]; !ok {
+			gone = append(gone, p)
+		}
+	}
+	sort.Slice(gone, func(i, j int) bool {
+		if gone[i].RSS != gone[j].RSS {
+			return gone[i].RSS > gone[j].RSS
+		}
+		return gone[i].PID < gone[j].PID
+	})
+
+	var exits []Exit
+	newExit := func(p process.ProcessInfo, cause Cause, evidence string) {
+		exits = append(exits, Exit{
+			Cause: cause, Evidence: evidence, Process: p, Time: now,
+			Build: d.builds[process.BuildKey(p.BuildJobName, p.BuildId)],
+		})
+	}
+
+	// The kernel log names the killed PID
+	kernelOOMKills := make(map[string]uint64)
+	var unexplained []process.ProcessInfo
+	for _, p := range gone {
+		ev, ok := d.pending[p.PID]
+		if !ok {
+			unexplained = append(unexplained, p)
+			continue
+		}
+		delete(d.pending, p.PID)
+		newExit(p, ev.Cause, ev.Message)
+		if ev.Cause == OOMKill && p.Cgroup != nil {
+			kernelOOMKills[cgroupKey(p.Cgroup)]++
+		}
+	}
+
+	// Otherwise a rise of the OOM kill counter of the cgroup is blamed on its
+	// largest processes that disappeared, less the kills already explained
+	if d.oomKills != nil {
+		byCgroup := make(map[string][]process.ProcessInfo)
+		var cgroups []string
+		for _, p := range unexplained {
+			if p.Cgroup == nil {
+				continue
+			}
+			key := cgroupKey(p.Cgroup)
+			if _, ok := byCgroup[key]; !ok {
+				cgroups = append(cgroups, key)
+			}
+			byCgroup[key] = append(byCgroup[key], p)
+		}
+		for _, key := range cgroups {
+			candidates := byCgroup[key]
+			cg := candidates[0].Cgroup
+			current, ok := d.oomKills(*cg)
+			if !ok || current <= cg.OOMKills+kernelOOMKills[key] {
+				continue
+			}
+			kills := current - cg.OOMKills - kernelOOMKills[key]
+			evidence := fmt.Sprintf("OOM kill counter of cgroup %s rose from %d to %d", cg.Path, cg.OOMKills, current)
+			for i := 0; i < len(candidates) && uint64(i) < kills; i++ {
+				newExit(candidates[i], OOMKill, evidence)
+			}
+		}
+	}
+
+	for pid, ev := range d.pending {
+		ev.passes++
+		if ev.passes >= maxPendingPasses {
+			delete(d.pending, pid)
+			continue
+		}
+		d.pending[pid] = ev
+	}
+	d.procs, d.builds = procs, byKey
+
+	sort.Slice(exits, func(i, j int) bool {
+		if ki, kj := exits[i].Build.Key(), exits[j].Build.Key(); ki != kj {
+			return ki < kj
+		}
+		return exits[i].Process.PID < exits[j].Process.PID
+	})
+	return exits
+}
+
+func cgroupKey(cg *cgroup.Stats) string {
+	return fmt.Sprintf("%d:%s", cg.Version, cg.Path)
+}
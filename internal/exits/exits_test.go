@@ -0,0 +1,142 @@
+package exits
+
+import (
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/process"
+)
+
+func TestParseKernelMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+		want   KernelEvent
+		ok     bool
+	}{
+		{
+			name:   "oom killer",
+			record: "3,1234,5678901,-;Out of memory: Killed process 4321 (java) total-vm:8388608kB, anon-rss:4194304kB, file-rss:0kB\n SUBSYSTEM=memory",
+			want:   KernelEvent{PID: 4321, Command: "java", Cause: OOMKill},
+			ok:     true,
+		},
+		{
+			name:   "memory cgroup",
+			record: "3,1235,5678902,-;Memory cgroup out of memory: Killed process 99 (gradle worker) total-vm:100kB",
+			want:   KernelEvent{PID: 99, Command: "gradle worker", Cause: OOMKill},
+			ok:     true,
+		},
+		{
+			name:   "oom summary",
+			record: "6,1236,5678903,-;oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=abc,mems_allowed=0,oom_memcg=/docker/abc,task_memcg=/docker/abc,task=node,pid=77,uid=1000",
+			want:   KernelEvent{PID: 77, Command: "node", Cause: OOMKill},
+			ok:     true,
+		},
+		{
+			name:   "segfault",
+			record: "6,1237,5678904,-;java[555]: segfault at 0 ip 00007f sp 00007e error 4 in libjvm.so[7f+100]",
+			want:   KernelEvent{PID: 555, Command: "java", Cause: Crash},
+			ok:     true,
+		},
+		{
+			name:   "trap",
+			record: "6,1238,5678905,-;traps: cc1plus[808] general protection fault ip:55 sp:7f error:0 in cc1plus[55+10]",
+			want:   KernelEvent{PID: 808, Command: "cc1plus", Cause: Crash},
+			ok:     true,
+		},
+		{name: "other message", record: "6,1239,5678906,-;eth0: link up", ok: false},
+		{name: "not a record", record: "Killed process 1 (init)", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseKernelMessage(tt.record)
+			if ok != tt.ok {
+				t.Fatalf("parseKernelMessage() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got.PID != tt.want.PID || got.Command != tt.want.Command || got.Cause != tt.want.Cause || got.Message == "" {
+				t.Errorf("parseKernelMessage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeKernelLog returns queued events on the next Read
+type fakeKernelLog struct {
+	events []KernelEvent
+}
+
+func (f *fakeKernelLog) Read() ([]KernelEvent, error) {
+	events := f.events
+	f.events = nil
+	return events, nil
+}
+
+func (f *fakeKernelLog) Close() error { return nil }
+
+func newBuild(job, id string, procs ...process.ProcessInfo) process.BuildInfo {
+	b := process.BuildInfo{BuildJobName: job, BuildId: id}
+	for _, p := range procs {
+		p.BuildJobName, p.BuildId = job, id
+		b.Processes = append(b.Processes, process.BuildProcess{ProcessInfo: p})
+	}
+	return b
+}
+
+func TestDetector(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	cg := &cgroup.Stats{Path: "/docker/abc", Version: 2, OOMKills: 1}
+	jvm := process.ProcessInfo{PID: 10, Name: "java", StartTime: start, RSS: 3 << 30, StageName: "Test", Cgroup: cg}
+	worker := process.ProcessInfo{PID: 11, Name: "java", StartTime: start, RSS: 1 << 30, Cgroup: cg}
+	shell := process.ProcessInfo{PID: 12, Name: "sh", StartTime: start, RSS: 1 << 20, Cgroup: cg}
+	crashed := process.ProcessInfo{PID: 20, Name: "node", StartTime: start, RSS: 1 << 28}
+	done := process.ProcessInfo{PID: 21, Name: "make", StartTime: start}
+
+	kernel := &fakeKernelLog{}
+	oomKills := uint64(1)
+	d := NewDetector(kernel, func(st cgroup.Stats) (uint64, bool) {
+		return oomKills, st.Path == "/docker/abc"
+	})
+	now := start.Add(time.Minute)
+
+	if exits := d.Update([]process.BuildInfo{
+		newBuild("app", "1", jvm, worker, shell),
+		newBuild("web", "2", crashed, done),
+	}, now); len(exits) != 0 {
+		t.Fatalf("first pass reported exits: %+v", exits)
+	}
+
+	// The JVM and shell are gone and the counter rose by one; node crashed,
+	// make exited normally and the PID of the worker was reused
+	oomKills = 2
+	kernel.events = []KernelEvent{{PID: 20, Command: "node", Cause: Crash, Message: "node[20]: segfault at 0"}}
+	reused := worker
+	reused.StartTime = start.Add(time.Second)
+	exits := d.Update([]process.BuildInfo{newBuild("app", "1", reused)}, now.Add(time.Minute))
+
+	// One kill is blamed on the largest process gone from the cgroup, not on
+	// the shell or the worker whose PID was reused
+	if len(exits) != 2 {
+		t.Fatalf("got %d exits, want 2: %+v", len(exits), exits)
+	}
+	if e := exits[0]; e.Process.PID != 10 || e.Cause != OOMKill || e.Process.StageName != "Test" || len(e.Build.Processes) != 3 {
+		t.Errorf("exit[0] = %+v, want the JVM OOM-killed with its last build sample", e)
+	}
+	if e := exits[1]; e.Process.PID != 20 || e.Cause != Crash || e.Build.BuildJobName != "web" {
+		t.Errorf("exit[1] = %+v, want node crashed", e)
+	}
+
+	// A kernel event arriving before its process is seen to be gone is kept for the next pass
+	kernel.events = []KernelEvent{{PID: 11, Command: "java", Cause: OOMKill, Message: "Killed process 11 (java)"}}
+	if exits := d.Update([]process.BuildInfo{newBuild("app", "1", reused)}, now.Add(2*time.Minute)); len(exits) != 0 {
+		t.Fatalf("running process reported as exited: %+v", exits)
+	}
+	exits = d.Update(nil, now.Add(3*time.Minute))
+	if len(exits) != 1 || exits[0].Process.PID != 11 || exits[0].Cause != OOMKill || exits[0].Evidence != "Killed process 11 (java)" {
+		t.Errorf("exits = %+v, want the worker OOM-killed", exits)
+	}
+}
@@ -0,0 +1,68 @@
+package exits
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultKernelLog is the kernel log device read for OOM kills and crashes
+const DefaultKernelLog = "/dev/kmsg"
+
+// KernelEvent is a kernel log message reporting that a process was killed
+type KernelEvent struct {
+	PID     int32
+	Command string
+	Cause   Cause
+	Message string
+}
+
+// KernelLog yields the kernel events logged since the previous call
+type KernelLog interface {
+	Read() ([]KernelEvent, error)
+	Close() error
+}
+
+var (
+	// "Out of memory: Killed process 4321 (java) total-vm:..." and the
+	// "Memory cgroup out of memory: ..." variant
+	oomKilledRe = regexp.MustCompile(`Killed process (\d+) \(([^)]*)\)`)
+	// "oom-kill:constraint=CONSTRAINT_MEMCG,...,task=java,pid=4321,uid=1000"
+	oomSummaryRe = regexp.MustCompile(`oom-kill:.*\btask=([^,]*),pid=(\d+)`)
+	// "java[4321]: segfault at 0 ip ... in libjvm.so[...]"
+	segfaultRe = regexp.MustCompile(`(\S+)\[(\d+)\]: segfault at`)
+	// "traps: java[4321] general protection fault ip:..." and other traps
+	trapRe = regexp.MustCompile(`traps: (\S+)\[(\d+)\] (.+?) ip:`)
+)
+
+// parseKernelMessage recognises the OOM kill and crash messages of a
+// /dev/kmsg record: "priority,sequence,timestamp,flags;message" followed by
+// optional continuation lines
+func parseKernelMessage(record string) (KernelEvent, bool) {
+	_, msg, ok := strings.Cut(record, ";")
+	if !ok {
+		return KernelEvent{}, false
+	}
+	msg, _, _ = strings.Cut(msg, "\n")
+
+	ev := KernelEvent{Message: msg}
+	var pid string
+	if m := oomKilledRe.FindStringSubmatch(msg); m != nil {
+		pid, ev.Command, ev.Cause = m[1], m[2], OOMKill
+	} else if m := oomSummaryRe.FindStringSubmatch(msg); m != nil {
+		pid, ev.Command, ev.Cause = m[2], m[1], OOMKill
+	} else if m := segfaultRe.FindStringSubmatch(msg); m != nil {
+		pid, ev.Command, ev.Cause = m[2], m[1], Crash
+	} else if m := trapRe.FindStringSubmatch(msg); m != nil {
+		pid, ev.Command, ev.Cause = m[2], m[1], Crash
+	} else {
+		return KernelEvent{}, false
+	}
+
+	n, err := strconv.ParseInt(pid, 10, 32)
+	if err != nil {
+		return KernelEvent{}, false
+	}
+	ev.PID = int32(n)
+	return ev, true
+}
@@ -0,0 +1,59 @@
+//go:build linux
+
+package exits
+
+import (
+	"errors"
+	"io"
+	"syscall"
+)
+
+// kmsg reads /dev/kmsg without blocking. Each read returns one record.
+type kmsg struct {
+	fd  int
+	buf []byte
+}
+
+// OpenKernelLog opens the kernel log device at path, positioned after the
+// last message logged so far. Reading it needs CAP_SYSLOG when
+// kernel.dmesg_restrict is set.
+func OpenKernelLog(path string) (KernelLog, error) {
+	// A plain os.File would park reads in the runtime poller instead of returning EAGAIN
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syscall.Seek(fd, 0, io.SeekEnd); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &kmsg{fd: fd, buf: make([]byte, 8192)}, nil
+}
+
+// Read returns the OOM kill and crash events logged since the previous call
+func (k *kmsg) Read() ([]KernelEvent, error) {
+	var events []KernelEvent
+	for {
+		n, err := syscall.Read(k.fd, k.buf)
+		switch {
+		case errors.Is(err, syscall.EAGAIN):
+			return events, nil
+		case errors.Is(err, syscall.EPIPE):
+			// Records were overwritten before they were read; carry on with the next one
+			continue
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case err != nil:
+			return events, err
+		case n == 0:
+			return events, nil
+		}
+		if ev, ok := parseKernelMessage(string(k.buf[:n])); ok {
+			events = append(events, ev)
+		}
+	}
+}
+
+func (k *kmsg) Close() error {
+	return syscall.Close(k.fd)
+}
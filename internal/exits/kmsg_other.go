@@ -0,0 +1,13 @@
+//go:build !linux
+
+package exits
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// OpenKernelLog is only supported on Linux
+func OpenKernelLog(path string) (KernelLog, error) {
+	return nil, fmt.Errorf("reading the kernel log is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,59 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format selects how a command renders its output
+type Format string
+
+// Supported output formats
+const (
+	Table    Format = "table"
+	JSON     Format = "json"
+	CSV      Format = "csv"
+	Markdown Format = "markdown"
+)
+
+// Parse validates a --format value
+func Parse(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case Table, JSON, CSV, Markdown:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q: expected table, json, csv or markdown", s)
+	}
+}
+
+// WriteJSON writes v as indented JSON followed by a newline
+func WriteJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// WriteMarkdownTable writes a GitHub flavoured Markdown table
+func WriteMarkdownTable(w io.Writer, header []string, rows [][]string) {
+	fmt.Fprintf(w, "| %s |\n", strings.Join(escapeCells(header), " | "))
+	sep := make([]string, len(header))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(sep, " | "))
+	for _, row := range rows {
+		fmt.Fprintf(w, "| %s |\n", strings.Join(escapeCells(row), " | "))
+	}
+}
+
+// escapeCells keeps pipes and newlines in values from breaking the table layout
+func escapeCells(cells []string) []string {
+	escaped := make([]string, len(cells))
+	for i, c := range cells {
+		c = strings.ReplaceAll(c, "|", "\\|")
+		escaped[i] = strings.ReplaceAll(c, "\n", " ")
+	}
+	return escaped
+}
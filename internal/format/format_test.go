@@ -0,0 +1,27 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, in := range []string{"table", "JSON", "csv", "markdown"} {
+		if _, err := Parse(in); err != nil {
+			t.Errorf("Parse(%q) error: %v", in, err)
+		}
+	}
+	if _, err := Parse("yaml"); err == nil {
+		t.Error("Parse(\"yaml\") should fail")
+	}
+}
+
+func TestWriteMarkdownTable(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMarkdownTable(&buf, []string{"Job", "CPU"}, [][]string{{"a|b", "1"}})
+
+	want := "| Job | CPU |\n| --- | --- |\n| a\\|b | 1 |\n"
+	if buf.String() != want {
+		t.Errorf("WriteMarkdownTable() = %q, want %q", buf.String(), want)
+	}
+}
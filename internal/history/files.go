@@ -0,0 +1,311 @@
+package history
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GzipExt is appended to the name of compressed data files
+const GzipExt = ".gz"
+
+// splitExt splits a data file path into its base and extension, e.g.
+// "/data/processes.csv" into "/data/processes" and ".csv"
+func splitExt(path string) (string, string) {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext), ext
+}
+
+// RotatedName returns the name a data file is renamed to when it is rotated
+// at the end of the given day: base.YYYY-MM-DD.ext
+func RotatedName(path string, day time.Time) string {
+	base, ext := splitExt(path)
+	return fmt.Sprintf("%s.%s%s", base, day.Format("2006-01-02"), ext)
+}
+
+// RotatedDay returns the day in the name of a rotated copy of a data file,
+// in local time like the rotation itself
+func RotatedDay(path, rotated string) (time.Time, bool) {
+	base, _ := splitExt(path)
+	rest, ok := strings.CutPrefix(rotated, base+".")
+	if !ok || len(rest) < len("2006-01-02") {
+		return time.Time{}, false
+	}
+	day, err := time.ParseInLocation("2006-01-02", rest[:len("2006-01-02")], time.Local)
+	return day, err == nil
+}
+
+// RotatedFiles returns the rotated siblings of a data file, compressed or
+// not, oldest first (see sortFiles)
+func RotatedFiles(path string) ([]string, error) {
+	base, ext := splitExt(path)
+	// Only dated names, so sibling files such as base.host.csv are not taken for rotated copies
+	pattern := escapeGlob(base) + ".[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]*" + escapeGlob(ext)
+
+	plain, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := filepath.Glob(pattern + GzipExt)
+	if err != nil {
+		return nil, err
+	}
+
+	files := append(plain, compressed...)
+	sortFiles(files)
+	return files, nil
+}
+
+// fileOrder is the position of a data file among the rotated copies of the
+// file it was rotated from
+type fileOrder struct {
+	base    string // path without the date, sequence number and extensions
+	ext     string
+	rotated bool
+	day     string // YYYY-MM-DD of a rotated copy
+	seq     int    // 0 for the first rotation of a day, then 1, 2, ...
+}
+
+func orderOf(path string) fileOrder {
+	name := strings.TrimSuffix(path, GzipExt)
+	o := fileOrder{ext: filepath.Ext(name)}
+	o.base = strings.TrimSuffix(name, o.ext)
+
+	rest, seq := o.base, 0
+	if e := filepath.Ext(rest); len(e) > 1 && strings.Trim(e[1:], "0123456789") == "" {
+		if n, err := strconv.Atoi(e[1:]); err == nil {
+			rest, seq = strings.TrimSuffix(rest, e), n
+		}
+	}
+	if e := filepath.Ext(rest); len(e) == len(".2006-01-02") {
+		if _, err := time.Parse("2006-01-02", e[1:]); err == nil {
+			o.base, o.rotated, o.day, o.seq = strings.TrimSuffix(rest, e), true, e[1:], seq
+		}
+	}
+	return o
+}
+
+// fileBefore orders data files by the file they were rotated from, then its
+// rotated copies by day and sequence number, and the file itself last
+func fileBefore(a, b string) bool {
+	oa, ob := orderOf(a), orderOf(b)
+	switch {
+	case oa.base != ob.base:
+		return oa.base < ob.base
+	case oa.ext != ob.ext:
+		return oa.ext < ob.ext
+	case oa.rotated != ob.rotated:
+		return oa.rotated
+	case oa.day != ob.day:
+		return oa.day < ob.day
+	case oa.seq != ob.seq:
+		return oa.seq < ob.seq
+	}
+	return a < b
+}
+
+// sortFiles sorts data files so the samples of each are read in time order:
+// base.D.csv, base.D.1.csv, ..., base.D.10.csv, then the next day, then
+// base.csv. Plain name order would read base.D.1.csv before base.D.csv and
+// base.D.10.csv before base.D.2.csv.
+func sortFiles(files []string) {
+	sort.SliceStable(files, func(i, j int) bool { return fileBefore(files[i], files[j]) })
+}
+
+// HostName returns the name of the host samples file written next to a data
+// file: base.host.csv. Host files hold no process samples, so directories and
+// glob patterns passed to Discover skip them.
+func HostName(path string) string {
+	base, _ := splitExt(path)
+	return base + hostSuffix + ".csv"
+}
+
+// WorkspacesName returns the name of the workspace samples file written next
+// to a data file: base.workspaces.csv. Like host files, Discover skips them.
+func WorkspacesName(path string) string {
+	base, _ := splitExt(path)
+	return base + workspacesSuffix + ".csv"
+}
+
+// Suffixes marking the sample files written next to a data file, rotated or not
+const (
+	hostSuffix       = ".host"
+	workspacesSuffix = ".workspaces"
+)
+
+// isSideFile reports whether path is a host or workspace samples file
+func isSideFile(path string) bool {
+	return hasNameSuffix(path, hostSuffix) || hasNameSuffix(path, workspacesSuffix)
+}
+
+// isWorkspacesFile reports whether path is a workspace samples file
+func isWorkspacesFile(path string) bool {
+	return hasNameSuffix(path, workspacesSuffix)
+}
+
+// hasNameSuffix reports whether the name of path, or of the file it is a
+// rotated copy of, ends in suffix before its extension
+func hasNameSuffix(path, suffix string) bool {
+	name := strings.TrimSuffix(filepath.Base(path), GzipExt)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	for {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+		// Strip the date and sequence number of a rotated copy
+		ext := filepath.Ext(name)
+		if ext == "" || strings.Trim(ext[1:], "0123456789-") != "" {
+			return false
+		}
+		name = strings.TrimSuffix(name, ext)
+	}
+}
+
+// Discover expands inputs into a de-duplicated list of data files, the
+// rotated copies of each file oldest first and before the file itself.
+// Each input may be a file, a glob pattern, or a directory, in which case its
+// CSV and store files are used, compressed or not. With includeRotated, the rotated
+// siblings of every plain file input are added as well.
+func Discover(inputs []string, includeRotated bool) ([]string, error) {
+	return discover(inputs, includeRotated, func(path string) bool { return !isSideFile(path) })
+}
+
+// DiscoverWorkspaces is Discover for workspace samples files: directories and
+// glob patterns yield only workspace samples files, and a data file input is
+// replaced by the workspace samples file written next to it.
+func DiscoverWorkspaces(inputs []string, includeRotated bool) ([]string, error) {
+	mapped := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		if info, err := os.Stat(input); err == nil && !info.IsDir() && !isWorkspacesFile(input) {
+			input = WorkspacesName(input)
+			if _, err := os.Stat(input); err != nil {
+				// Not written yet, but its rotated copies may exist
+				if includeRotated {
+					rotated, err := RotatedFiles(input)
+					if err != nil {
+						return nil, err
+					}
+					mapped = append(mapped, rotated...)
+				}
+				continue
+			}
+		}
+		mapped = append(mapped, input)
+	}
+	return discover(mapped, includeRotated, isWorkspacesFile)
+}
+
+// discover implements Discover; keep selects the files of directories and glob patterns
+func discover(inputs []string, includeRotated bool, keep func(string) bool) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		switch {
+		case err == nil && info.IsDir():
+			for _, pattern := range []string{"*.csv", "*.csv" + GzipExt, "*" + StoreExt, "*" + StoreExt + GzipExt} {
+				matches, err := filepath.Glob(filepath.Join(escapeGlob(input), pattern))
+				if err != nil {
+					return nil, err
+				}
+				for _, m := range matches {
+					if keep(m) {
+						add(m)
+					}
+				}
+			}
+		case err == nil:
+			add(input)
+			if includeRotated {
+				rotated, err := RotatedFiles(input)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range rotated {
+					add(r)
+				}
+			}
+		case hasGlobMeta(input):
+			matches, err := filepath.Glob(input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %w", input, err)
+			}
+			for _, m := range matches {
+				if keep(m) {
+					add(m)
+				}
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	sortFiles(files)
+	return files, nil
+}
+
+// Open opens a data file for reading, decompressing it if its name ends in .gz
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, GzipExt) {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read gzip file %s: %w", path, err)
+	}
+	return &gzipFile{Reader: gz, file: f}, nil
+}
+
+// gzipFile closes both the decompressor and the underlying file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if cerr := g.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// escapeGlob quotes the glob metacharacters in a literal path. Windows globs
+// have no escape character, so paths are used as-is there.
+func escapeGlob(path string) string {
+	if runtime.GOOS == "windows" {
+		return path
+	}
+	var b strings.Builder
+	for _, r := range path {
+		if r == '*' || r == '?' || r == '[' || r == '\\' {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
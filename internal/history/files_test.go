@@ -0,0 +1,269 @@
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotatedName(t *testing.T) {
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	got := RotatedName(filepath.Join("data", "processes.csv"), day)
+	want := filepath.Join("data", "processes.2024-06-01.csv")
+	if got != want {
+		t.Errorf("RotatedName() = %q, want %q", got, want)
+	}
+}
+
+func TestRotatedDay(t *testing.T) {
+	path := filepath.Join("data", "processes.csv")
+	tests := []struct {
+		rotated string
+		want    time.Time
+		ok      bool
+	}{
+		{filepath.Join("data", "processes.2024-06-01.csv"), time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), true},
+		{filepath.Join("data", "processes.2024-06-01.2.csv.gz"), time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), true},
+		{filepath.Join("data", "processes.host.csv"), time.Time{}, false},
+		{filepath.Join("other", "processes.2024-06-01.csv"), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := RotatedDay(path, tt.rotated)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("RotatedDay(%q) = %v, %v; want %v, %v", tt.rotated, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "processes.csv")
+	day1 := filepath.Join(dir, "processes.2024-06-01.csv.gz")
+	day2 := filepath.Join(dir, "processes.2024-06-02.csv")
+	other := filepath.Join(dir, "other.csv")
+	notes := filepath.Join(dir, "notes.txt")
+	// Host and workspace samples files are never taken for process data
+	hostFile := filepath.Join(dir, "processes.host.csv")
+	hostDay := filepath.Join(dir, "processes.host.2024-06-01.1.csv")
+	wsFile := filepath.Join(dir, "processes.workspaces.csv")
+	for _, f := range []string{current, day2, other, notes, hostFile, hostDay, wsFile} {
+		writeFile(t, f, "")
+	}
+	writeGzip(t, day1, "")
+
+	testCases := []struct {
+		name           string
+		inputs         []string
+		includeRotated bool
+		expected       []string
+	}{
+		{
+			name:     "Single file",
+			inputs:   []string{current},
+			expected: []string{current},
+		},
+		{
+			name:           "File with rotated siblings",
+			inputs:         []string{current},
+			includeRotated: true,
+			expected:       []string{day1, day2, current},
+		},
+		{
+			name:     "Directory",
+			inputs:   []string{dir},
+			expected: []string{other, day1, day2, current},
+		},
+		{
+			name:     "Glob",
+			inputs:   []string{filepath.Join(dir, "processes.2024-*")},
+			expected: []string{day1, day2},
+		},
+		{
+			name:           "Overlapping inputs are de-duplicated",
+			inputs:         []string{current, day2, dir},
+			includeRotated: true,
+			expected:       []string{other, day1, day2, current},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Discover(tc.inputs, tc.includeRotated)
+			if err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Discover() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDiscoverSameDayRotations(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "processes.csv")
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	// Size rotations of one day, oldest first, then the next day and the current file
+	names := []string{"processes.2024-06-01.csv", "processes.2024-06-01.1.csv", "processes.2024-06-01.2.csv",
+		"processes.2024-06-01.10.csv", "processes.2024-06-02.csv.gz", "processes.csv"}
+	var want []string
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		var buf bytes.Buffer
+		if err := WriteHeader(&buf); err != nil {
+			t.Fatal(err)
+		}
+		w := csv.NewWriter(&buf)
+		w.Write(Record{Time: start.Add(time.Duration(i) * time.Minute), JobName: "app", BuildID: "1", PID: int32(i + 1)}.Fields())
+		w.Flush()
+		if strings.HasSuffix(name, GzipExt) {
+			writeGzip(t, path, buf.String())
+		} else {
+			writeFile(t, path, buf.String())
+		}
+		want = append(want, path)
+	}
+
+	files, err := Discover([]string{current}, true)
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("Discover() = %v, want %v", files, want)
+	}
+	var last time.Time
+	for _, file := range files {
+		if _, err := ReadFile(file, Query{}, func(rec Record) {
+			if rec.Time.Before(last) {
+				t.Errorf("%s: sample at %v read after %v", file, rec.Time, last)
+			}
+			last = rec.Time
+		}, nil); err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", file, err)
+		}
+	}
+	if rotated, _ := RotatedFiles(current); !reflect.DeepEqual(rotated, want[:len(want)-1]) {
+		t.Errorf("RotatedFiles() = %v, want %v", rotated, want[:len(want)-1])
+	}
+}
+
+func TestDiscoverWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "processes.csv")
+	wsFile := filepath.Join(dir, "processes.workspaces.csv")
+	wsDay := filepath.Join(dir, "processes.workspaces.2024-06-01.csv.gz")
+	other := filepath.Join(dir, "other.csv")
+	for _, f := range []string{current, other, filepath.Join(dir, "processes.host.csv"), wsFile, wsDay} {
+		writeFile(t, f, "")
+	}
+
+	testCases := []struct {
+		name           string
+		inputs         []string
+		includeRotated bool
+		expected       []string
+	}{
+		{
+			name:     "Data file is replaced by its workspace samples file",
+			inputs:   []string{current},
+			expected: []string{wsFile},
+		},
+		{
+			name:           "With rotated copies",
+			inputs:         []string{current},
+			includeRotated: true,
+			expected:       []string{wsDay, wsFile},
+		},
+		{
+			name:     "Directory",
+			inputs:   []string{dir},
+			expected: []string{wsDay, wsFile},
+		},
+		{
+			name:     "Data file without workspace samples",
+			inputs:   []string{other},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DiscoverWorkspaces(tc.inputs, tc.includeRotated)
+			if err != nil {
+				t.Fatalf("DiscoverWorkspaces() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("DiscoverWorkspaces() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDiscoverMissingFile(t *testing.T) {
+	if _, err := Discover([]string{filepath.Join(t.TempDir(), "missing.csv")}, false); err == nil {
+		t.Error("Discover() expected an error for a missing file")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "processes.csv")
+	compressed := filepath.Join(dir, "processes.2024-06-01.csv.gz")
+	writeFile(t, plain, "plain\n")
+	writeGzip(t, compressed, "compressed\n")
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{path: plain, expected: "plain\n"},
+		{path: compressed, expected: "compressed\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(filepath.Base(tc.path), func(t *testing.T) {
+			f, err := Open(tc.path)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.expected {
+				t.Errorf("Open() read %q, want %q", data, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package history
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Query selects the records read from data files
+type Query struct {
+	// Since and Until bound the sample timestamps; zero values leave the range open
+	Since time.Time
+	Until time.Time
+	// Job restricts the records to jobs whose name matches; nil matches all jobs
+	Job *regexp.Regexp
+}
+
+// Matches reports whether a record is selected by the query
+func (q Query) Matches(rec Record) bool {
+	if q.Job != nil && !q.Job.MatchString(rec.JobName) {
+		return false
+	}
+	if !q.Since.IsZero() && rec.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && rec.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// matchesBlock reports whether a store block may hold selected records
+func (q Query) matchesBlock(bh blockHeader) bool {
+	if !q.Since.IsZero() && bh.MaxTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && bh.MinTime.After(q.Until) {
+		return false
+	}
+	if q.Job == nil {
+		return true
+	}
+	for _, job := range bh.Jobs {
+		if q.Job.MatchString(job) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStore reports whether path names a binary store file, compressed or not
+func IsStore(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, GzipExt), StoreExt)
+}
+
+// Progress is called while a data file is read with the number of bytes of
+// the file, as stored on disk, consumed so far
+type Progress func(read int64)
+
+// ReadFile calls fn for every record of a CSV or store file that matches q,
+// and returns the number of malformed CSV rows that were skipped. A file cut
+// short, for example a gzip archive whose copy was interrupted, ends with one
+// skipped row rather than an error. progress may be nil.
+func ReadFile(path string, q Query, fn func(Record), progress Progress) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var file io.Reader = &countingReader{file: f, progress: progress}
+	if strings.HasSuffix(path, GzipExt) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read gzip file %s: %w", path, err)
+		}
+		defer gz.Close()
+		file = gz
+	}
+
+	if IsStore(path) {
+		if err := readStore(file, q, fn); err != nil {
+			return 0, fmt.Errorf("failed to read store %s: %w", path, err)
+		}
+		return 0, nil
+	}
+
+	reader, err := NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read CSV file %s: %w", path, err)
+	}
+	skipped := 0
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			return skipped, nil
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			skipped++
+			continue
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, gzip.ErrChecksum) {
+			return skipped + 1, nil
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("failed to read CSV file %s: %w", path, err)
+		}
+		if q.Matches(rec) {
+			fn(rec)
+		}
+	}
+}
+
+// countingReader reports how far into a file reading has got
+type countingReader struct {
+	file     *os.File
+	read     int64
+	progress Progress
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.file.Read(p)
+	c.read += int64(n)
+	if c.progress != nil {
+		c.progress(c.read)
+	}
+	return n, err
+}
+
+// Seek lets the store reader skip blocks of uncompressed files
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.file.Seek(offset, whence)
+	if err == nil {
+		c.read = pos
+		if c.progress != nil {
+			c.progress(c.read)
+		}
+	}
+	return pos, err
+}
@@ -0,0 +1,122 @@
+package history
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"jenkins-monitor/internal/config"
+)
+
+// bytesPerMB converts the MB limits of the retention settings to bytes
+const bytesPerMB = 1024 * 1024
+
+// NextRotatedName returns a free name to rotate a data file to at the given
+// day. The first rotation of a day uses RotatedName; further size-based
+// rotations on the same day add a counter: base.YYYY-MM-DD.N.ext
+func NextRotatedName(path string, day time.Time) string {
+	name := RotatedName(path, day)
+	base, ext := splitExt(path)
+	for n := 1; exists(name) || exists(name+GzipExt); n++ {
+		name = fmt.Sprintf("%s.%s.%d%s", base, day.Format("2006-01-02"), n, ext)
+	}
+	return name
+}
+
+// Compress gzips a file next to itself, removes the original and returns the
+// name of the compressed file
+func Compress(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	target := path + GzipExt
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(target)
+		return "", fmt.Errorf("failed to compress %s: %w", path, err)
+	}
+
+	src.Close()
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// Prune deletes the rotated siblings of a data file that fall outside the
+// retention limits, oldest first, and returns the deleted files. The current
+// data file itself is never deleted.
+func Prune(path string, cfg config.RetentionConfig, now time.Time) ([]string, error) {
+	rotated, err := RotatedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	type rotatedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	files := make([]rotatedFile, 0, len(rotated))
+	for _, r := range rotated {
+		info, err := os.Stat(r)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path: r, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	// Newest first, so the files past a limit are the ones to delete
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.After(files[j].modTime)
+		}
+		return fileBefore(files[j].path, files[i].path)
+	})
+
+	var removed []string
+	var errs []error
+	var total int64
+	for i, f := range files {
+		total += f.size
+		expired := cfg.MaxAge > 0 && now.Sub(f.modTime) > cfg.MaxAge
+		tooMany := cfg.MaxFiles > 0 && i >= cfg.MaxFiles
+		tooBig := cfg.MaxTotalSizeMB > 0 && total > cfg.MaxTotalSizeMB*bytesPerMB
+		if !expired && !tooMany && !tooBig {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, f.path)
+	}
+
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("failed to delete %d rotated files: %v", len(errs), errs[0])
+	}
+	return removed, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,112 @@
+package history
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/config"
+)
+
+func TestNextRotatedName(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "processes.csv")
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	if got, want := NextRotatedName(path, day), filepath.Join(dir, "processes.2024-06-01.csv"); got != want {
+		t.Errorf("NextRotatedName() = %q, want %q", got, want)
+	}
+
+	// A compressed rotation of the same day still takes the name
+	writeGzip(t, filepath.Join(dir, "processes.2024-06-01.csv.gz"), "")
+	writeFile(t, filepath.Join(dir, "processes.2024-06-01.1.csv"), "")
+	if got, want := NextRotatedName(path, day), filepath.Join(dir, "processes.2024-06-01.2.csv"); got != want {
+		t.Errorf("NextRotatedName() = %q, want %q", got, want)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes.2024-06-01.csv")
+	writeFile(t, path, "timestamp,pid\n")
+
+	compressed, err := Compress(path)
+	if err != nil {
+		t.Fatalf("Compress() error = %v", err)
+	}
+	if compressed != path+GzipExt {
+		t.Errorf("Compress() = %q, want %q", compressed, path+GzipExt)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Compress() left the original file behind")
+	}
+
+	f, err := Open(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if string(data) != "timestamp,pid\n" {
+		t.Errorf("compressed file holds %q", data)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	mb := strings.Repeat("x", bytesPerMB)
+
+	// Rotated files of the last four days, 1 MB each, plus the current file
+	setup := func(t *testing.T) (string, []string) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "processes.csv")
+		writeFile(t, path, mb)
+		var rotated []string
+		for i := 4; i >= 1; i-- {
+			day := now.AddDate(0, 0, -i)
+			r := RotatedName(path, day)
+			writeFile(t, r, mb)
+			if err := os.Chtimes(r, day, day); err != nil {
+				t.Fatal(err)
+			}
+			rotated = append(rotated, r)
+		}
+		return path, rotated
+	}
+
+	testCases := []struct {
+		name      string
+		retention config.RetentionConfig
+		removed   int // number of oldest rotated files expected to be deleted
+	}{
+		{name: "No limits", retention: config.RetentionConfig{}, removed: 0},
+		{name: "Max age", retention: config.RetentionConfig{MaxAge: 60 * time.Hour}, removed: 2},
+		{name: "Max files", retention: config.RetentionConfig{MaxFiles: 1}, removed: 3},
+		{name: "Max total size", retention: config.RetentionConfig{MaxTotalSizeMB: 2}, removed: 2},
+		{name: "Tightest limit wins", retention: config.RetentionConfig{MaxAge: 24 * time.Hour, MaxFiles: 3}, removed: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, rotated := setup(t)
+			removed, err := Prune(path, tc.retention, now)
+			if err != nil {
+				t.Fatalf("Prune() error = %v", err)
+			}
+
+			var expected []string
+			for i := tc.removed - 1; i >= 0; i-- {
+				expected = append(expected, rotated[i])
+			}
+			if !reflect.DeepEqual(removed, expected) {
+				t.Errorf("Prune() removed %v, want %v", removed, expected)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("Prune() removed the current file: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"jenkins-monitor/internal/process"
+)
+
+// SchemaVersion is the version of the CSV layout written by the monitor
+const SchemaVersion = 3
+
+// schemaMarker starts the comment line that precedes the header of versioned
+// files. Version 1 files have no marker and the columns in columnsV1.
+const schemaMarker = "# jenkins-monitor schema="
+
+// Columns is the header of the current schema
+var Columns = []string{
+	"timestamp", "host", "job_name", "build_id", "stage", "workspace",
+	"pid", "command", "cpu", "mem", "rss_bytes", "threads",
+	"fds", "fd_limit", "read_bytes", "write_bytes", "voluntary_ctx_switches", "involuntary_ctx_switches",
+}
+
+// columnsV1 is the header of files written before the schema was versioned
+var columnsV1 = []string{"timestamp", "pid", "cpu", "mem", "build_path"}
+
+// Record is one process sample as stored in a data file. Fields that an older
+// schema does not carry are left empty.
+type Record struct {
+	Time      time.Time
+	Host      string
+	JobName   string
+	BuildID   string
+	Stage     string
+	Workspace string
+	PID       int32
+	Command   string
+	CPU       float64 // percent of one core
+	Mem       float64 // percent of system memory
+	RSS       uint64
+	Threads   int32
+	FDs       int32
+	FDLimit   uint64 // soft open file limit; 0 when unknown or unlimited
+	// I/O and context switch counters since the process started
+	ReadBytes              uint64
+	WriteBytes             uint64
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+}
+
+// NewRecord builds the record of a process sampled at t on host
+func NewRecord(p process.ProcessInfo, host string, t time.Time) Record {
+	return Record{
+		Time:      t,
+		Host:      host,
+		JobName:   p.BuildJobName,
+		BuildID:   p.BuildId,
+		Stage:     p.StageName,
+		Workspace: p.WorkSpace,
+		PID:       p.PID,
+		Command:   p.Name,
+		CPU:       p.CPU,
+		Mem:       float64(p.Mem),
+		RSS:       p.RSS,
+		Threads:   p.NumThreads,
+		FDs:       p.NumFDs,
+		FDLimit:   p.FDLimit,
+
+		ReadBytes:              p.ReadBytes,
+		WriteBytes:             p.WriteBytes,
+		VoluntaryCtxSwitches:   p.VoluntaryCtxSwitches,
+		InvoluntaryCtxSwitches: p.InvoluntaryCtxSwitches,
+	}
+}
+
+// Fields returns the record as a row of the current schema, in Columns order
+func (r Record) Fields() []string {
+	return []string{
+		r.Time.UTC().Format(time.RFC3339),
+		r.Host,
+		r.JobName,
+		r.BuildID,
+		r.Stage,
+		r.Workspace,
+		strconv.Itoa(int(r.PID)),
+		r.Command,
+		strconv.FormatFloat(r.CPU, 'f', 2, 64),
+		strconv.FormatFloat(r.Mem, 'f', 2, 64),
+		strconv.FormatUint(r.RSS, 10),
+		strconv.Itoa(int(r.Threads)),
+		strconv.Itoa(int(r.FDs)),
+		strconv.FormatUint(r.FDLimit, 10),
+		strconv.FormatUint(r.ReadBytes, 10),
+		strconv.FormatUint(r.WriteBytes, 10),
+		strconv.FormatInt(r.VoluntaryCtxSwitches, 10),
+		strconv.FormatInt(r.InvoluntaryCtxSwitches, 10),
+	}
+}
+
+// WriteHeader writes the schema marker and the header of the current schema
+func WriteHeader(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s%d\n", schemaMarker, SchemaVersion); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(Columns)
+	cw.Flush()
+	return cw.Error()
+}
+
+// RowError reports a data row that could not be parsed; reading can continue
+// with the next row
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads the records of a data file of any schema version
+type Reader struct {
+	// Version is the schema version of the file
+	Version int
+	csv     *csv.Reader
+	index   map[string]int
+}
+
+// NewReader reads the schema marker and header of a data file. An empty
+// file yields a Reader that returns io.EOF.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	version := 1
+	if prefix, err := br.Peek(len(schemaMarker)); err == nil && string(prefix) == schemaMarker {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		version, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, schemaMarker)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema marker %q", strings.TrimSpace(line))
+		}
+		if version > SchemaVersion {
+			return nil, fmt.Errorf("unsupported schema version %d (newest known is %d)", version, SchemaVersion)
+		}
+	}
+
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1
+	reader := &Reader{Version: version, csv: cr, index: make(map[string]int)}
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return reader, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if version == 1 && !slices.Equal(header, columnsV1) {
+		return nil, fmt.Errorf("unrecognized header %q", strings.Join(header, ","))
+	}
+	for i, name := range header {
+		reader.index[name] = i
+	}
+	if _, ok := reader.index["timestamp"]; !ok {
+		return nil, fmt.Errorf("header has no timestamp column")
+	}
+	return reader, nil
+}
+
+// Read returns the next record, io.EOF at the end of the file, or a *RowError
+// for a malformed row
+func (r *Reader) Read() (Record, error) {
+	row, err := r.csv.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return Record{}, &RowError{Line: parseErr.Line, Err: parseErr.Err}
+		}
+		return Record{}, err
+	}
+	line, _ := r.csv.FieldPos(0)
+
+	field := func(name string) string {
+		if i, ok := r.index[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	rowErr := func(err error) (Record, error) {
+		return Record{}, &RowError{Line: line, Err: err}
+	}
+
+	var rec Record
+	if rec.Time, err = time.Parse(time.RFC3339, field("timestamp")); err != nil {
+		return rowErr(err)
+	}
+	pid, err := strconv.ParseInt(field("pid"), 10, 32)
+	if err != nil {
+		return rowErr(fmt.Errorf("invalid pid: %w", err))
+	}
+	rec.PID = int32(pid)
+	if rec.CPU, err = strconv.ParseFloat(field("cpu"), 64); err != nil {
+		return rowErr(fmt.Errorf("invalid cpu: %w", err))
+	}
+	if rec.Mem, err = strconv.ParseFloat(field("mem"), 64); err != nil {
+		return rowErr(fmt.Errorf("invalid mem: %w", err))
+	}
+
+	if r.Version == 1 {
+		rec.JobName = field("build_path")
+		return rec, nil
+	}
+
+	rec.Host = field("host")
+	rec.JobName = field("job_name")
+	rec.BuildID = field("build_id")
+	rec.Stage = field("stage")
+	rec.Workspace = field("workspace")
+	rec.Command = field("command")
+	// The absolute counters are informational; a blank value, or a column
+	// missing from an older schema, is not an error
+	for _, c := range []struct {
+		name string
+		dst  *uint64
+	}{
+		{"rss_bytes", &rec.RSS},
+		{"fd_limit", &rec.FDLimit},
+		{"read_bytes", &rec.ReadBytes},
+		{"write_bytes", &rec.WriteBytes},
+	} {
+		if v := field(c.name); v != "" {
+			if *c.dst, err = strconv.ParseUint(v, 10, 64); err != nil {
+				return rowErr(fmt.Errorf("invalid %s: %w", c.name, err))
+			}
+		}
+	}
+	var threads, fds int64
+	for _, c := range []struct {
+		name string
+		dst  *int64
+		bits int
+	}{
+		{"threads", &threads, 32},
+		{"fds", &fds, 32},
+		{"voluntary_ctx_switches", &rec.VoluntaryCtxSwitches, 64},
+		{"involuntary_ctx_switches", &rec.InvoluntaryCtxSwitches, 64},
+	} {
+		if v := field(c.name); v != "" {
+			if *c.dst, err = strconv.ParseInt(v, 10, c.bits); err != nil {
+				return rowErr(fmt.Errorf("invalid %s: %w", c.name, err))
+			}
+		}
+	}
+	rec.Threads, rec.FDs = int32(threads), int32(fds)
+	return rec, nil
+}
+
+// FileVersion returns the schema version of an existing data file, or 0 if
+// the file is empty
+func FileVersion(path string) (int, error) {
+	f, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	if len(r.index) == 0 {
+		return 0, nil
+	}
+	return r.Version, nil
+}
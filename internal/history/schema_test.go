@@ -0,0 +1,138 @@
+package history
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/process"
+)
+
+// readAll returns the records of a data file and the lines of its malformed rows
+func readAll(t *testing.T, data string) ([]Record, []int, int) {
+	t.Helper()
+	r, err := NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	var records []Record
+	var malformed []int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records, malformed, r.Version
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			malformed = append(malformed, rowErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	p := process.ProcessInfo{
+		PID:          42,
+		Name:         "java",
+		BuildJobName: "app/main",
+		BuildId:      "41",
+		StageName:    "Build, Test",
+		WorkSpace:    "/var/lib/jenkins/workspace/app",
+		CPU:          150.5,
+		Mem:          12.25,
+		RSS:          1 << 30,
+		NumThreads:   64,
+		NumFDs:       900,
+		FDLimit:      1024,
+		ReadBytes:    5 << 20,
+		WriteBytes:   3 << 20,
+
+		VoluntaryCtxSwitches:   12000,
+		InvoluntaryCtxSwitches: 340,
+	}
+	want := NewRecord(p, "agent-1", ts)
+
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	w := csv.NewWriter(&buf)
+	w.Write(want.Fields())
+	w.Flush()
+
+	got, malformed, version := readAll(t, buf.String())
+	if version != SchemaVersion {
+		t.Errorf("Version = %d, want %d", version, SchemaVersion)
+	}
+	if len(malformed) != 0 {
+		t.Errorf("malformed rows at lines %v", malformed)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("Read() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadSchemaV1(t *testing.T) {
+	data := "timestamp,pid,cpu,mem,build_path\n" +
+		"2024-06-01T10:00:00Z,7,50.00,10.00,app/main\n" +
+		"not-a-time,8,1,1,app/main\n"
+
+	got, malformed, version := readAll(t, data)
+	if version != 1 {
+		t.Errorf("Version = %d, want 1", version)
+	}
+	want := []Record{{Time: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC), PID: 7, CPU: 50, Mem: 10, JobName: "app/main"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %+v, want %+v", got, want)
+	}
+	if !reflect.DeepEqual(malformed, []int{3}) {
+		t.Errorf("malformed lines = %v, want [3]", malformed)
+	}
+}
+
+func TestReadSchemaV2(t *testing.T) {
+	data := "# jenkins-monitor schema=2\n" +
+		"timestamp,host,job_name,build_id,stage,workspace,pid,command,cpu,mem,rss_bytes,threads\n" +
+		"2024-06-01T10:00:00Z,agent-1,app,3,,/ws,7,java,50.00,10.00,2048,16\n"
+
+	got, malformed, version := readAll(t, data)
+	if version != 2 || len(malformed) != 0 {
+		t.Fatalf("Version = %d, malformed = %v, want 2 and none", version, malformed)
+	}
+	want := []Record{{
+		Time: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC), Host: "agent-1", JobName: "app", BuildID: "3",
+		Workspace: "/ws", PID: 7, Command: "java", CPU: 50, Mem: 10, RSS: 2048, Threads: 16,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNewReaderErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "Unknown header", data: "time,value\n"},
+		{name: "Newer schema", data: "# jenkins-monitor schema=99\ntimestamp\n"},
+		{name: "Invalid marker", data: "# jenkins-monitor schema=x\ntimestamp\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewReader(strings.NewReader(tc.data)); err == nil {
+				t.Error("NewReader() expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// StoreExt is the extension of the binary store files
+const StoreExt = ".jmts"
+
+// storeMagic starts every store file; the byte after it is the format version
+var storeMagic = []byte("JMTS")
+
+// StoreVersion is the version of the store format written by StoreWriter.
+// Version 1 records end at the thread count.
+const StoreVersion = 2
+
+// blockPrefixSize is the size of the fixed part in front of every block:
+// body length, CRC-32 of the body and length of the block header
+const blockPrefixSize = 12
+
+// maxBlockSize guards against reading a corrupt length as a huge allocation
+const maxBlockSize = 64 << 20
+
+// The store is an append-only sequence of blocks, usually one per monitor
+// tick. Each block header carries the time range and the jobs of its records,
+// so readers skip blocks outside a query without decoding them:
+//
+//	file   = magic version block*
+//	block  = bodyLen:u32 crc:u32 headerLen:u32 header payload
+//	header = minTime maxTime jobCount job*
+//	payload = recordCount record*
+//
+// Times are Unix milliseconds, integers are varints, strings are
+// length-prefixed and floats are little-endian IEEE 754.
+
+// blockHeader is the index entry of one block
+type blockHeader struct {
+	MinTime time.Time
+	MaxTime time.Time
+	Jobs    []string
+}
+
+// StoreWriter appends blocks of records to a store file
+type StoreWriter struct {
+	file *os.File
+}
+
+// OpenStoreWriter opens a store file for appending, creating it if needed.
+// A block left incomplete by a crash is cut off so new blocks stay readable.
+func OpenStoreWriter(path string) (*StoreWriter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %w", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if _, err := file.Write(append(append([]byte{}, storeMagic...), StoreVersion)); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &StoreWriter{file: file}, nil
+	}
+
+	version, err := readStoreMagic(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if version != StoreVersion {
+		// Blocks of different versions cannot share a file
+		file.Close()
+		return nil, fmt.Errorf("%s: cannot append to a version %d store", path, version)
+	}
+	end, err := lastBlockEnd(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if end < info.Size() {
+		if err := file.Truncate(end); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &StoreWriter{file: file}, nil
+}
+
+// Write appends records as one block
+func (w *StoreWriter) Write(records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	_, err := w.file.Write(encodeBlock(records))
+	return err
+}
+
+// Close closes the store file
+func (w *StoreWriter) Close() error {
+	return w.file.Close()
+}
+
+// lastBlockEnd returns the offset just past the last complete block
+func lastBlockEnd(file *os.File) (int64, error) {
+	offset := int64(len(storeMagic) + 1)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(file)
+	for {
+		var prefix [blockPrefixSize]byte
+		if _, err := io.ReadFull(br, prefix[:]); err != nil {
+			return offset, nil
+		}
+		bodyLen := binary.LittleEndian.Uint32(prefix[0:])
+		if bodyLen > maxBlockSize {
+			return offset, nil
+		}
+		body := make([]byte, bodyLen)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(prefix[4:]) {
+			return offset, nil
+		}
+		offset += blockPrefixSize + int64(bodyLen)
+	}
+}
+
+// readStoreMagic checks the magic of a store and returns its format version
+func readStoreMagic(r io.Reader) (int, error) {
+	head := make([]byte, len(storeMagic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, fmt.Errorf("not a store file: %w", err)
+	}
+	if !bytes.Equal(head[:len(storeMagic)], storeMagic) {
+		return 0, fmt.Errorf("not a store file")
+	}
+	version := int(head[len(storeMagic)])
+	if version < 1 || version > StoreVersion {
+		return 0, fmt.Errorf("unsupported store version %d", version)
+	}
+	return version, nil
+}
+
+// StoreFileVersion returns the format version of an existing store file, or
+// 0 if the file is empty
+func StoreFileVersion(path string) (int, error) {
+	f, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	version, err := readStoreMagic(f)
+	if errors.Is(err, io.EOF) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// readStore calls fn for every record of a store that matches q. Blocks whose
+// header rules them out are skipped, with a seek when r supports it.
+func readStore(r io.Reader, q Query, fn func(Record)) error {
+	seeker, canSeek := r.(io.Seeker)
+	if !canSeek {
+		r = bufio.NewReader(r)
+	}
+	skip := func(n int64) error {
+		if canSeek {
+			_, err := seeker.Seek(n, io.SeekCurrent)
+			return err
+		}
+		_, err := io.CopyN(io.Discard, r, n)
+		return err
+	}
+
+	version, err := readStoreMagic(r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var prefix [blockPrefixSize]byte
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			// A block cut short by a crash ends the readable data
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		bodyLen := binary.LittleEndian.Uint32(prefix[0:])
+		crc := binary.LittleEndian.Uint32(prefix[4:])
+		headerLen := binary.LittleEndian.Uint32(prefix[8:])
+		if bodyLen > maxBlockSize || headerLen > bodyLen {
+			return fmt.Errorf("corrupt block header")
+		}
+
+		header := make([]byte, headerLen)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil
+		}
+		bh, err := decodeBlockHeader(header)
+		if err != nil {
+			return err
+		}
+		if !q.matchesBlock(bh) {
+			if err := skip(int64(bodyLen - headerLen)); err != nil {
+				return nil
+			}
+			continue
+		}
+
+		payload := make([]byte, bodyLen-headerLen)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil
+		}
+		if crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, payload) != crc {
+			return fmt.Errorf("block checksum mismatch")
+		}
+		records, err := decodePayload(payload, bh, version)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if q.Matches(rec) {
+				fn(rec)
+			}
+		}
+	}
+}
+
+// encodeBlock serializes records into a complete block, prefix included
+func encodeBlock(records []Record) []byte {
+	minTime, maxTime := records[0].Time, records[0].Time
+	jobIndex := make(map[string]uint64)
+	var jobs []string
+	for _, rec := range records {
+		if rec.Time.Before(minTime) {
+			minTime = rec.Time
+		}
+		if rec.Time.After(maxTime) {
+			maxTime = rec.Time
+		}
+		if _, ok := jobIndex[rec.JobName]; !ok {
+			jobIndex[rec.JobName] = uint64(len(jobs))
+			jobs = append(jobs, rec.JobName)
+		}
+	}
+
+	var header []byte
+	header = binary.AppendVarint(header, minTime.UnixMilli())
+	header = binary.AppendVarint(header, maxTime.UnixMilli())
+	header = binary.AppendUvarint(header, uint64(len(jobs)))
+	for _, job := range jobs {
+		header = appendString(header, job)
+	}
+
+	var payload []byte
+	payload = binary.AppendUvarint(payload, uint64(len(records)))
+	for _, rec := range records {
+		payload = binary.AppendVarint(payload, rec.Time.UnixMilli()-minTime.UnixMilli())
+		payload = binary.AppendUvarint(payload, jobIndex[rec.JobName])
+		payload = appendString(payload, rec.Host)
+		payload = appendString(payload, rec.BuildID)
+		payload = appendString(payload, rec.Stage)
+		payload = appendString(payload, rec.Workspace)
+		payload = binary.AppendVarint(payload, int64(rec.PID))
+		payload = appendString(payload, rec.Command)
+		payload = binary.LittleEndian.AppendUint64(payload, math.Float64bits(rec.CPU))
+		payload = binary.LittleEndian.AppendUint64(payload, math.Float64bits(rec.Mem))
+		payload = binary.AppendUvarint(payload, rec.RSS)
+		payload = binary.AppendVarint(payload, int64(rec.Threads))
+		payload = binary.AppendVarint(payload, int64(rec.FDs))
+		payload = binary.AppendUvarint(payload, rec.FDLimit)
+		payload = binary.AppendUvarint(payload, rec.ReadBytes)
+		payload = binary.AppendUvarint(payload, rec.WriteBytes)
+		payload = binary.AppendVarint(payload, rec.VoluntaryCtxSwitches)
+		payload = binary.AppendVarint(payload, rec.InvoluntaryCtxSwitches)
+	}
+
+	crc := crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, payload)
+	block := make([]byte, blockPrefixSize, blockPrefixSize+len(header)+len(payload))
+	binary.LittleEndian.PutUint32(block[0:], uint32(len(header)+len(payload)))
+	binary.LittleEndian.PutUint32(block[4:], crc)
+	binary.LittleEndian.PutUint32(block[8:], uint32(len(header)))
+	block = append(block, header...)
+	return append(block, payload...)
+}
+
+func decodeBlockHeader(data []byte) (blockHeader, error) {
+	d := decoder{data: data}
+	bh := blockHeader{
+		MinTime: time.UnixMilli(d.varint()),
+		MaxTime: time.UnixMilli(d.varint()),
+	}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		bh.Jobs = append(bh.Jobs, d.string())
+	}
+	return bh, d.err
+}
+
+func decodePayload(data []byte, bh blockHeader, version int) ([]Record, error) {
+	d := decoder{data: data}
+	n := d.uvarint()
+	records := make([]Record, 0, min(n, uint64(len(data))))
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		var rec Record
+		rec.Time = time.UnixMilli(bh.MinTime.UnixMilli() + d.varint()).UTC()
+		job := d.uvarint()
+		if job >= uint64(len(bh.Jobs)) {
+			return nil, fmt.Errorf("corrupt block: job index %d out of range", job)
+		}
+		rec.JobName = bh.Jobs[job]
+		rec.Host = d.string()
+		rec.BuildID = d.string()
+		rec.Stage = d.string()
+		rec.Workspace = d.string()
+		rec.PID = int32(d.varint())
+		rec.Command = d.string()
+		rec.CPU = math.Float64frombits(d.uint64())
+		rec.Mem = math.Float64frombits(d.uint64())
+		rec.RSS = d.uvarint()
+		rec.Threads = int32(d.varint())
+		if version >= 2 {
+			rec.FDs = int32(d.varint())
+			rec.FDLimit = d.uvarint()
+			rec.ReadBytes = d.uvarint()
+			rec.WriteBytes = d.uvarint()
+			rec.VoluntaryCtxSwitches = d.varint()
+			rec.InvoluntaryCtxSwitches = d.varint()
+		}
+		records = append(records, rec)
+	}
+	return records, d.err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// errCorrupt is returned when a block ends in the middle of a value
+var errCorrupt = errors.New("corrupt block: truncated value")
+
+// decoder reads values from a block, remembering the first error
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 8 {
+		d.err = errCorrupt
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.data)
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.data)) {
+		d.err = errCorrupt
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+// ConvertToStore appends the records of the given CSV or store files to a
+// store, one block per sample time, and returns the number of records written
+// and malformed rows skipped
+func ConvertToStore(inputFiles []string, output string) (int, int, error) {
+	w, err := OpenStoreWriter(output)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer w.Close()
+
+	var block []Record
+	var writeErr error
+	written, skipped := 0, 0
+	flush := func() {
+		if writeErr == nil {
+			writeErr = w.Write(block)
+		}
+		written += len(block)
+		block = block[:0]
+	}
+
+	for _, input := range inputFiles {
+		n, err := ReadFile(input, Query{}, func(rec Record) {
+			if len(block) > 0 && !rec.Time.Equal(block[0].Time) {
+				flush()
+			}
+			block = append(block, rec)
+		}, nil)
+		skipped += n
+		if err != nil {
+			return written, skipped, err
+		}
+		flush()
+		if writeErr != nil {
+			return written, skipped, writeErr
+		}
+	}
+	return written, skipped, w.Close()
+}
@@ -0,0 +1,155 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// storeTick returns the records of one monitor tick at minute m
+func storeTick(m int, jobs ...string) []Record {
+	ts := time.Date(2024, 6, 1, 10, m, 0, 0, time.UTC)
+	var records []Record
+	for i, job := range jobs {
+		records = append(records, Record{
+			Time: ts, Host: "agent-1", JobName: job, BuildID: "7", Stage: "Build",
+			Workspace: "/ws/" + job, PID: int32(100 + i), Command: "java",
+			CPU: float64(10*m + i), Mem: 1.5, RSS: 1 << 20, Threads: 8, FDs: 40, FDLimit: 1024,
+			ReadBytes: 4096, WriteBytes: uint64(m) << 10, VoluntaryCtxSwitches: int64(100 * m), InvoluntaryCtxSwitches: 3,
+		})
+	}
+	return records
+}
+
+func writeStore(t *testing.T, path string, ticks ...[]Record) {
+	t.Helper()
+	w, err := OpenStoreWriter(path)
+	if err != nil {
+		t.Fatalf("OpenStoreWriter() error = %v", err)
+	}
+	for _, tick := range ticks {
+		if err := w.Write(tick); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readStoreFile(t *testing.T, path string, q Query) []Record {
+	t.Helper()
+	var got []Record
+	if _, err := ReadFile(path, q, func(rec Record) { got = append(got, rec) }, nil); err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	return got
+}
+
+func TestStoreQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes"+StoreExt)
+	tick0, tick1, tick2 := storeTick(0, "app", "lib"), storeTick(1, "app"), storeTick(2, "lib")
+	writeStore(t, path, tick0, tick1, tick2)
+
+	testCases := []struct {
+		name     string
+		query    Query
+		expected []Record
+	}{
+		{
+			name:     "All records",
+			query:    Query{},
+			expected: append(append(append([]Record{}, tick0...), tick1...), tick2...),
+		},
+		{
+			name:     "Job",
+			query:    Query{Job: regexp.MustCompile("^lib$")},
+			expected: []Record{tick0[1], tick2[0]},
+		},
+		{
+			name:     "Time range",
+			query:    Query{Since: tick1[0].Time, Until: tick1[0].Time},
+			expected: tick1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := readStoreFile(t, path, tc.query)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("ReadFile() = %+v, want %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestStoreRepairsTruncatedBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes"+StoreExt)
+	writeStore(t, path, storeTick(0, "app"), storeTick(1, "app"))
+
+	// Simulate a crash in the middle of writing the last block
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+	if got := readStoreFile(t, path, Query{}); len(got) != 1 {
+		t.Fatalf("read %d records from a truncated store, want 1", len(got))
+	}
+
+	writeStore(t, path, storeTick(2, "app"))
+	got := readStoreFile(t, path, Query{})
+	want := append(storeTick(0, "app"), storeTick(2, "app")...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadFile() after repair = %+v, want %+v", got, want)
+	}
+}
+
+func TestStoreVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processes"+StoreExt)
+	if err := os.WriteFile(path, append([]byte("JMTS"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := StoreFileVersion(path); err != nil || v != 1 {
+		t.Errorf("StoreFileVersion() = %d, %v, want 1", v, err)
+	}
+	if _, err := OpenStoreWriter(path); err == nil {
+		t.Error("OpenStoreWriter() appended to a version 1 store")
+	}
+	if got := readStoreFile(t, path, Query{}); len(got) != 0 {
+		t.Errorf("ReadFile() = %+v, want no records", got)
+	}
+}
+
+func TestConvertToStore(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "processes.csv")
+	writeFile(t, input, "timestamp,pid,cpu,mem,build_path\n"+
+		"2024-06-01T10:00:00Z,1,50.00,10.00,app\n"+
+		"2024-06-01T10:00:00Z,2,5.00,1.00,lib\n"+
+		"garbage\n"+
+		"2024-06-01T10:00:30Z,1,70.00,12.00,app\n")
+	output := filepath.Join(dir, "processes"+StoreExt)
+
+	written, skipped, err := ConvertToStore([]string{input}, output)
+	if err != nil {
+		t.Fatalf("ConvertToStore() error = %v", err)
+	}
+	if written != 3 || skipped != 1 {
+		t.Errorf("ConvertToStore() = %d written, %d skipped, want 3 and 1", written, skipped)
+	}
+
+	compressed, err := Compress(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readStoreFile(t, compressed, Query{Job: regexp.MustCompile("^app$")})
+	if len(got) != 2 || got[1].CPU != 70 || got[1].PID != 1 {
+		t.Errorf("ReadFile() = %+v, want the two app samples", got)
+	}
+}
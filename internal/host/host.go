@@ -0,0 +1,199 @@
+package host
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// Pressure thresholds above which the agent is reported as overloaded
+const (
+	loadPerCPUPressure = 1.5
+	memPressure        = 90.0
+	swapPressure       = 50.0
+	diskPressure       = 90.0
+)
+
+// Snapshot is the state of the agent at one collection pass. Values that
+// could not be read on this platform are left at zero.
+type Snapshot struct {
+	Time            time.Time
+	NumCPU          int
+	Load1           float64
+	Load5           float64
+	Load15          float64
+	CPUPercent      float64   // utilization of the whole machine since the previous snapshot
+	CPUCorePercent  []float64 // utilization of each CPU since the previous snapshot
+	MemTotal        uint64
+	MemAvailable    uint64
+	MemUsedPercent  float64
+	SwapTotal       uint64
+	SwapUsed        uint64
+	SwapUsedPercent float64
+	DiskPath        string // filesystem holding the Jenkins workspaces
+	DiskTotal       uint64
+	DiskUsed        uint64
+	DiskUsedPercent float64
+}
+
+// Overloaded reports whether the agent itself is under pressure: load well
+// above the number of CPUs, or memory, swap or the workspace disk nearly full
+func (s *Snapshot) Overloaded() bool {
+	return (s.NumCPU > 0 && s.Load1 > loadPerCPUPressure*float64(s.NumCPU)) ||
+		s.MemUsedPercent >= memPressure ||
+		s.SwapUsedPercent >= swapPressure ||
+		s.DiskUsedPercent >= diskPressure
+}
+
+// Pressure summarises the host state on one line for logs and notifications
+func (s *Snapshot) Pressure() string {
+	parts := []string{
+		fmt.Sprintf("load %.2f/%.2f/%.2f on %d CPUs", s.Load1, s.Load5, s.Load15, s.NumCPU),
+		fmt.Sprintf("CPU %.0f%%", s.CPUPercent),
+		fmt.Sprintf("memory %.0f%% used", s.MemUsedPercent),
+	}
+	if s.SwapTotal > 0 {
+		parts = append(parts, fmt.Sprintf("swap %.0f%% used", s.SwapUsedPercent))
+	}
+	if s.DiskPath != "" {
+		parts = append(parts, fmt.Sprintf("disk %.0f%% used", s.DiskUsedPercent))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Columns is the header of the host samples file
+var Columns = []string{
+	"timestamp", "host", "load1", "load5", "load15", "cpu_percent",
+	"mem_total_bytes", "mem_available_bytes", "mem_used_percent",
+	"swap_total_bytes", "swap_used_bytes", "swap_used_percent",
+	"disk_path", "disk_total_bytes", "disk_used_bytes", "disk_used_percent", "cpu_core_percent",
+}
+
+// SchemaMarker is the first line of host samples files, which versions their layout
+const SchemaMarker = "# jenkins-monitor host-schema=1"
+
+// Fields returns the snapshot as a row of the host samples file, in Columns
+// order. Per-CPU utilization is joined with ';'.
+func (s *Snapshot) Fields(hostname string) []string {
+	cores := make([]string, len(s.CPUCorePercent))
+	for i, c := range s.CPUCorePercent {
+		cores[i] = formatFloat(c)
+	}
+	return []string{
+		s.Time.UTC().Format(time.RFC3339),
+		hostname,
+		formatFloat(s.Load1),
+		formatFloat(s.Load5),
+		formatFloat(s.Load15),
+		formatFloat(s.CPUPercent),
+		strconv.FormatUint(s.MemTotal, 10),
+		strconv.FormatUint(s.MemAvailable, 10),
+		formatFloat(s.MemUsedPercent),
+		strconv.FormatUint(s.SwapTotal, 10),
+		strconv.FormatUint(s.SwapUsed, 10),
+		formatFloat(s.SwapUsedPercent),
+		s.DiskPath,
+		strconv.FormatUint(s.DiskTotal, 10),
+		strconv.FormatUint(s.DiskUsed, 10),
+		formatFloat(s.DiskUsedPercent),
+		strings.Join(cores, ";"),
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// Sampler reads the host state and remembers the CPU times of the previous
+// snapshot, so CPU utilization is measured over each interval
+type Sampler struct {
+	prevCores []cpu.TimesStat
+
+	// Sources of the host state, replaced in tests
+	cpuTimes func() ([]cpu.TimesStat, error)
+	loadAvg  func() (*load.AvgStat, error)
+	memory   func() (*mem.VirtualMemoryStat, error)
+	swap     func() (*mem.SwapMemoryStat, error)
+	diskUse  func(path string) (*disk.UsageStat, error)
+}
+
+// NewSampler creates a Sampler reading the local machine
+func NewSampler() *Sampler {
+	return &Sampler{
+		cpuTimes: func() ([]cpu.TimesStat, error) { return cpu.Times(true) },
+		loadAvg:  load.Avg,
+		memory:   mem.VirtualMemory,
+		swap:     mem.SwapMemory,
+		diskUse:  disk.Usage,
+	}
+}
+
+// Sample reads the host state. diskPath is the filesystem whose usage is
+// reported; it is skipped when empty. Each source is read best effort, so a
+// platform without load averages still reports memory and disk.
+func (s *Sampler) Sample(diskPath string, now time.Time) Snapshot {
+	snap := Snapshot{Time: now, NumCPU: runtime.NumCPU()}
+
+	if cores, err := s.cpuTimes(); err == nil {
+		snap.NumCPU = len(cores)
+		snap.CPUCorePercent, snap.CPUPercent = utilization(s.prevCores, cores)
+		s.prevCores = cores
+	}
+	if avg, err := s.loadAvg(); err == nil && avg != nil {
+		snap.Load1, snap.Load5, snap.Load15 = avg.Load1, avg.Load5, avg.Load15
+	}
+	if vm, err := s.memory(); err == nil && vm != nil {
+		snap.MemTotal = vm.Total
+		snap.MemAvailable = vm.Available
+		if vm.Total > 0 {
+			snap.MemUsedPercent = float64(vm.Total-vm.Available) / float64(vm.Total) * 100
+		}
+	}
+	if sw, err := s.swap(); err == nil && sw != nil {
+		snap.SwapTotal, snap.SwapUsed, snap.SwapUsedPercent = sw.Total, sw.Used, sw.UsedPercent
+	}
+	if diskPath != "" {
+		if du, err := s.diskUse(diskPath); err == nil && du != nil {
+			snap.DiskPath = diskPath
+			snap.DiskTotal, snap.DiskUsed, snap.DiskUsedPercent = du.Total, du.Used, du.UsedPercent
+		}
+	}
+	return snap
+}
+
+// utilization returns the busy percentage of each CPU and of the whole
+// machine between two sets of CPU times, or since boot without previous times
+func utilization(prev, cur []cpu.TimesStat) ([]float64, float64) {
+	perCore := make([]float64, len(cur))
+	var busyTotal, allTotal float64
+	for i, c := range cur {
+		busy, all := busyTime(c)
+		if i < len(prev) && prev[i].CPU == c.CPU {
+			prevBusy, prevAll := busyTime(prev[i])
+			busy, all = busy-prevBusy, all-prevAll
+		}
+		if all > 0 && busy > 0 {
+			perCore[i] = busy / all * 100
+		}
+		busyTotal += max(busy, 0)
+		allTotal += max(all, 0)
+	}
+	if allTotal <= 0 {
+		return perCore, 0
+	}
+	return perCore, busyTotal / allTotal * 100
+}
+
+// busyTime returns the non-idle and total time of a CPU. Guest time is already
+// included in user time on Linux.
+func busyTime(t cpu.TimesStat) (busy, all float64) {
+	all = t.User + t.System + t.Idle + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+	return all - t.Idle - t.Iowait, all
+}
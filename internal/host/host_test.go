@@ -0,0 +1,55 @@
+package host
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+func TestSampler(t *testing.T) {
+	times := [][]cpu.TimesStat{
+		{{CPU: "cpu0", User: 100, Idle: 100}, {CPU: "cpu1", User: 0, Idle: 200}},
+		// cpu0 is fully busy over the interval, cpu1 a quarter busy
+		{{CPU: "cpu0", User: 110, Idle: 100}, {CPU: "cpu1", User: 2.5, Idle: 207.5}},
+	}
+	pass := 0
+	s := &Sampler{
+		cpuTimes: func() ([]cpu.TimesStat, error) { return times[pass], nil },
+		loadAvg:  func() (*load.AvgStat, error) { return nil, errors.New("not implemented") },
+		memory: func() (*mem.VirtualMemoryStat, error) {
+			return &mem.VirtualMemoryStat{Total: 1000, Available: 50}, nil
+		},
+		swap: func() (*mem.SwapMemoryStat, error) { return &mem.SwapMemoryStat{}, nil },
+		diskUse: func(path string) (*disk.UsageStat, error) {
+			return &disk.UsageStat{Path: path, Total: 100, Used: 40, UsedPercent: 40}, nil
+		},
+	}
+
+	first := s.Sample("/ws", time.Now())
+	if first.CPUPercent != 25 {
+		t.Errorf("first CPUPercent = %.2f, want 25 (since boot)", first.CPUPercent)
+	}
+
+	pass = 1
+	snap := s.Sample("/ws", time.Now())
+	if snap.CPUCorePercent[0] != 100 || snap.CPUCorePercent[1] != 25 || snap.CPUPercent != 62.5 {
+		t.Errorf("CPU = %v, total %.2f, want [100 25] and 62.5", snap.CPUCorePercent, snap.CPUPercent)
+	}
+	if snap.Load1 != 0 || snap.NumCPU != 2 {
+		t.Errorf("Load1 = %.2f, NumCPU = %d, want 0 without load averages and 2", snap.Load1, snap.NumCPU)
+	}
+	if snap.MemUsedPercent != 95 || snap.DiskPath != "/ws" || snap.DiskUsedPercent != 40 {
+		t.Errorf("memory %.0f%%, disk %s %.0f%%, want 95%% and /ws 40%%", snap.MemUsedPercent, snap.DiskPath, snap.DiskUsedPercent)
+	}
+	if !snap.Overloaded() {
+		t.Error("Overloaded() = false with 95% memory used")
+	}
+	if len(snap.Fields("agent")) != len(Columns) {
+		t.Errorf("Fields() has %d values for %d columns", len(snap.Fields("agent")), len(Columns))
+	}
+}
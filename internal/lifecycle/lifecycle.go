@@ -0,0 +1,188 @@
+package lifecycle
+
+import (
+	"sort"
+	"time"
+
+	"jenkins-monitor/internal/process"
+)
+
+// maxSampleGap is the longest gap between two samples of a build over which
+// CPU time is integrated; longer gaps are treated as the build being idle
+const maxSampleGap = 5 * time.Minute
+
+// Summary is the resource profile of one build, written when it finishes.
+// Its JSON form is the documented schema of the summary file.
+type Summary struct {
+	JobName      string    `json:"job_name"`
+	BuildID      string    `json:"build_id"`
+	Workspace    string    `json:"workspace,omitempty"`
+	NodeName     string    `json:"node_name,omitempty"`
+	Stages       []string  `json:"stages"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at"`
+	Duration     float64   `json:"duration_seconds"`
+	Samples      int       `json:"samples"`
+	PeakCPU      float64   `json:"peak_cpu_percent"`
+	AvgCPU       float64   `json:"avg_cpu_percent"`
+	PeakMem      float64   `json:"peak_mem_percent"`
+	AvgMem       float64   `json:"avg_mem_percent"`
+	PeakRSS      uint64    `json:"peak_rss_bytes"`
+	CPUSeconds   float64   `json:"cpu_seconds"`
+	MaxProcesses int       `json:"max_processes"`
+	// StageUsage breaks the usage down by pipeline stage, in stage name order
+	StageUsage []StageSummary `json:"stage_usage"`
+}
+
+// StageSummary is the resource profile of one pipeline stage of a build.
+// Processes outside any stage are summarised under an empty name.
+type StageSummary struct {
+	Name       string  `json:"name"`
+	Samples    int     `json:"samples"`
+	PeakCPU    float64 `json:"peak_cpu_percent"`
+	PeakMem    float64 `json:"peak_mem_percent"`
+	PeakRSS    uint64  `json:"peak_rss_bytes"`
+	CPUSeconds float64 `json:"cpu_seconds"`
+}
+
+// Key identifies the build a summary belongs to
+func (s *Summary) Key() string {
+	return process.BuildKey(s.JobName, s.BuildID)
+}
+
+// buildState accumulates the samples of one running build
+type buildState struct {
+	summary  Summary
+	stages   map[string]*StageSummary
+	cpuSum   float64
+	memSum   float64
+	lastSeen time.Time
+}
+
+// Tracker follows builds across samples and reports when each one starts and
+// finishes, with the accumulated summary of a finished build
+type Tracker struct {
+	endGrace time.Duration
+	builds   map[string]*buildState
+}
+
+// NewTracker creates a Tracker. A build is finished once it has had no
+// processes for endGrace.
+func NewTracker(endGrace time.Duration) *Tracker {
+	return &Tracker{
+		endGrace: endGrace,
+		builds:   make(map[string]*buildState),
+	}
+}
+
+// Update feeds one sample's builds into the tracker and returns the builds
+// seen for the first time and the summaries of the builds that finished
+func (t *Tracker) Update(builds []process.BuildInfo, now time.Time) ([]process.BuildInfo, []Summary) {
+	var started []process.BuildInfo
+	for _, b := range builds {
+		st, ok := t.builds[b.Key()]
+		if !ok {
+			st = newBuildState(b, now)
+			t.builds[b.Key()] = st
+			started = append(started, b)
+		}
+		st.add(b, now)
+	}
+
+	var finished []Summary
+	for key, st := range t.builds {
+		if now.Sub(st.lastSeen) >= t.endGrace && st.lastSeen.Before(now) {
+			finished = append(finished, st.result())
+			delete(t.builds, key)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Key() < finished[j].Key() })
+	return started, finished
+}
+
+// Active returns the summaries so far of the builds that are still running,
+// sorted by job name and build ID
+func (t *Tracker) Active() []Summary {
+	active := make([]Summary, 0, len(t.builds))
+	for _, st := range t.builds {
+		active = append(active, st.result())
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Key() < active[j].Key() })
+	return active
+}
+
+func newBuildState(b process.BuildInfo, now time.Time) *buildState {
+	startedAt := now
+	if !b.StartTime.IsZero() && b.StartTime.Before(now) {
+		startedAt = b.StartTime
+	}
+	return &buildState{
+		summary: Summary{
+			JobName:   b.BuildJobName,
+			BuildID:   b.BuildId,
+			Workspace: b.WorkSpace,
+			NodeName:  b.NodeName,
+			StartedAt: startedAt,
+		},
+		stages: make(map[string]*StageSummary),
+	}
+}
+
+// add accounts for one sample of the build
+func (st *buildState) add(b process.BuildInfo, now time.Time) {
+	s := &st.summary
+	// CPU is measured over the interval since the previous sample
+	var seconds float64
+	if s.Samples > 0 {
+		if gap := now.Sub(st.lastSeen); gap > 0 && gap <= maxSampleGap {
+			seconds = gap.Seconds()
+		}
+	}
+	s.CPUSeconds += b.CPU / 100 * seconds
+
+	s.Samples++
+	st.cpuSum += b.CPU
+	st.memSum += float64(b.Mem)
+	s.PeakCPU = max(s.PeakCPU, b.CPU)
+	s.PeakMem = max(s.PeakMem, float64(b.Mem))
+	s.PeakRSS = max(s.PeakRSS, b.RSS)
+	s.MaxProcesses = max(s.MaxProcesses, len(b.Processes))
+	if s.Workspace == "" {
+		s.Workspace = b.WorkSpace
+	}
+	for _, u := range b.StageUsage {
+		stage, ok := st.stages[u.Name]
+		if !ok {
+			stage = &StageSummary{Name: u.Name}
+			st.stages[u.Name] = stage
+		}
+		stage.Samples++
+		stage.PeakCPU = max(stage.PeakCPU, u.CPU)
+		stage.PeakMem = max(stage.PeakMem, float64(u.Mem))
+		stage.PeakRSS = max(stage.PeakRSS, u.RSS)
+		stage.CPUSeconds += u.CPU / 100 * seconds
+	}
+	st.lastSeen = now
+}
+
+// result returns the summary of the samples seen so far
+func (st *buildState) result() Summary {
+	s := st.summary
+	s.EndedAt = st.lastSeen
+	s.Duration = s.EndedAt.Sub(s.StartedAt).Seconds()
+	if s.Samples > 0 {
+		s.AvgCPU = st.cpuSum / float64(s.Samples)
+		s.AvgMem = st.memSum / float64(s.Samples)
+	}
+	s.Stages = make([]string, 0, len(st.stages))
+	s.StageUsage = make([]StageSummary, 0, len(st.stages))
+	for name, stage := range st.stages {
+		if name != "" {
+			s.Stages = append(s.Stages, name)
+		}
+		s.StageUsage = append(s.StageUsage, *stage)
+	}
+	sort.Strings(s.Stages)
+	sort.Slice(s.StageUsage, func(i, j int) bool { return s.StageUsage[i].Name < s.StageUsage[j].Name })
+	return s
+}
@@ -0,0 +1,78 @@
+package lifecycle
+
+import (
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/process"
+)
+
+// build returns a single-process build, in the given stage if it is not empty
+func build(id string, cpu float64, mem float32, stage string) process.BuildInfo {
+	b := process.BuildInfo{
+		BuildJobName: "app",
+		BuildId:      id,
+		WorkSpace:    "/ws/app",
+		CPU:          cpu,
+		Mem:          mem,
+		Processes:    []process.BuildProcess{{}},
+		StageUsage:   []process.StageUsage{{Name: stage, CPU: cpu, Mem: mem, Processes: 1}},
+	}
+	if stage != "" {
+		b.Stages = []string{stage}
+	}
+	return b
+}
+
+func TestTrackerSummarisesFinishedBuilds(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tracker := NewTracker(time.Minute)
+
+	steps := []struct {
+		builds       []process.BuildInfo
+		wantStarted  int
+		wantFinished int
+	}{
+		{builds: []process.BuildInfo{build("1", 100, 10, "Build")}, wantStarted: 1},
+		{builds: []process.BuildInfo{build("1", 50, 20, "Test"), build("2", 10, 1, "")}, wantStarted: 1},
+		// Build 1 is between steps; the grace period keeps it running
+		{builds: []process.BuildInfo{build("2", 10, 1, "")}},
+		{builds: []process.BuildInfo{build("1", 0, 30, "Test"), build("2", 10, 1, "")}},
+		{builds: nil},
+		{builds: nil, wantFinished: 2},
+	}
+
+	var finished []Summary
+	for i, step := range steps {
+		now := start.Add(time.Duration(i) * 30 * time.Second)
+		started, done := tracker.Update(step.builds, now)
+		if len(started) != step.wantStarted || len(done) != step.wantFinished {
+			t.Fatalf("step %d: %d started, %d finished, want %d and %d", i, len(started), len(done), step.wantStarted, step.wantFinished)
+		}
+		finished = append(finished, done...)
+	}
+
+	got := finished[0]
+	if got.BuildID != "1" {
+		t.Fatalf("first summary is build %s, want 1", got.BuildID)
+	}
+	if got.Samples != 3 || got.Duration != 90 {
+		t.Errorf("Samples = %d, Duration = %.0f, want 3 and 90", got.Samples, got.Duration)
+	}
+	if got.PeakCPU != 100 || got.AvgCPU != 50 || got.PeakMem != 30 || got.AvgMem != 20 {
+		t.Errorf("CPU peak %.0f avg %.0f, memory peak %.0f avg %.0f, want 100, 50, 30 and 20", got.PeakCPU, got.AvgCPU, got.PeakMem, got.AvgMem)
+	}
+	// 30s at 50%, then 60s at 0%
+	if got.CPUSeconds != 15 {
+		t.Errorf("CPUSeconds = %.1f, want 15", got.CPUSeconds)
+	}
+	if len(got.Stages) != 2 || got.Stages[0] != "Build" || got.Stages[1] != "Test" {
+		t.Errorf("Stages = %v, want [Build Test]", got.Stages)
+	}
+	if len(got.StageUsage) != 2 || got.StageUsage[1].Name != "Test" || got.StageUsage[1].PeakMem != 30 || got.StageUsage[1].CPUSeconds != 15 {
+		t.Errorf("StageUsage = %+v, want Test with peak memory 30 and 15 CPU-seconds", got.StageUsage)
+	}
+	if len(tracker.Active()) != 0 {
+		t.Errorf("%d builds still active", len(tracker.Active()))
+	}
+}
@@ -0,0 +1,41 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/lifecycle"
+)
+
+// summaryPath returns the file build summaries are appended to, or "" if they
+// are only logged
+func summaryPath(outputFile string, cfg config.BuildsConfig) string {
+	if cfg.DisableSummaryFile {
+		return ""
+	}
+	if cfg.SummaryFile != "" {
+		return cfg.SummaryFile
+	}
+	return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".builds.jsonl"
+}
+
+// appendSummary writes one build summary as a JSON line
+func appendSummary(path string, sum lifecycle.Summary) error {
+	data, err := json.Marshal(sum)
+	if err != nil {
+		return fmt.Errorf("failed to encode build summary: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open build summary file: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write build summary: %w", err)
+	}
+	return nil
+}
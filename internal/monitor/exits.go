@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"fmt"
+
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/exits"
+	"jenkins-monitor/internal/utils"
+)
+
+// newExitDetector creates an exit detector with the evidence available: the
+// kernel log unless it is disabled or cannot be read, and the OOM kill
+// counters of the cgroups when they are read
+func newExitDetector(cfg config.ExitsConfig, cgroups *cgroup.Sampler) *exits.Detector {
+	var kernel exits.KernelLog
+	if !cfg.DisableKernelLog {
+		k, err := exits.OpenKernelLog(cfg.KernelLog)
+		if err != nil {
+			utils.Error(fmt.Sprintf("Failed to open kernel log %s, crashes will not be detected: %v", cfg.KernelLog, err))
+		} else {
+			kernel = k
+		}
+	}
+
+	var oomKills exits.OOMCounter
+	if cgroups != nil {
+		oomKills = cgroups.OOMKills
+	}
+	if kernel == nil && oomKills == nil {
+		utils.Info("Neither the kernel log nor cgroups are read. OOM kills will not be detected.")
+	}
+	return exits.NewDetector(kernel, oomKills)
+}
@@ -0,0 +1,420 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/host"
+	"jenkins-monitor/internal/notifier"
+	"jenkins-monitor/internal/process"
+)
+
+// buildLabels are the labels of the per-build gauges
+var buildLabels = []string{"job_name", "build_id"}
+
+// metrics holds the collectors exported by the monitor, registered on a
+// registry of their own so several monitors can coexist in tests and the
+// exported set does not depend on what other packages register globally
+type metrics struct {
+	registry *prometheus.Registry
+
+	jobCPU *prometheus.GaugeVec
+	jobMem *prometheus.GaugeVec
+
+	buildCPU             *prometheus.GaugeVec
+	buildCPUMachine      *prometheus.GaugeVec
+	buildMem             *prometheus.GaugeVec
+	buildProcesses       *prometheus.GaugeVec
+	buildRSS             *prometheus.GaugeVec
+	buildThreads         *prometheus.GaugeVec
+	buildFDs             *prometheus.GaugeVec
+	buildFDLimitUsage    *prometheus.GaugeVec
+	buildReadBytes       *prometheus.GaugeVec
+	buildWriteBytes      *prometheus.GaugeVec
+	buildVoluntaryCtx    *prometheus.GaugeVec
+	buildInvoluntaryCtx  *prometheus.GaugeVec
+	buildCgroupLimit     *prometheus.GaugeVec
+	buildCgroupUsage     *prometheus.GaugeVec
+	buildCgroupMem       *prometheus.GaugeVec
+	buildCgroupOOMKills  *prometheus.GaugeVec
+	buildCgroupThrottled *prometheus.GaugeVec
+	buildWorkspace       *prometheus.GaugeVec
+	buildWorkspaceGrowth *prometheus.GaugeVec
+	stageCPU             *prometheus.GaugeVec
+	stageMem             *prometheus.GaugeVec
+
+	activeBuilds    prometheus.Gauge
+	activeProcesses prometheus.Gauge
+	buildDuration   *prometheus.HistogramVec
+	abnormalExits   *prometheus.CounterVec
+
+	remediationActions *prometheus.CounterVec
+
+	alertsFired          *prometheus.CounterVec
+	notificationFailures *prometheus.CounterVec
+
+	hostLoad         *prometheus.GaugeVec
+	hostCPU          prometheus.Gauge
+	hostCoreCPU      *prometheus.GaugeVec
+	hostMemTotal     prometheus.Gauge
+	hostMemAvailable prometheus.Gauge
+	hostSwapTotal    prometheus.Gauge
+	hostSwapUsed     prometheus.Gauge
+	hostDiskTotal    *prometheus.GaugeVec
+	hostDiskUsed     *prometheus.GaugeVec
+
+	collectionDuration prometheus.Histogram
+	processesScanned   prometheus.Gauge
+	sampleInterval     prometheus.Gauge
+	exportedSeries     prometheus.Gauge
+	droppedSeries      prometheus.Counter
+}
+
+// newMetrics creates and registers the monitor's metrics. aggregation
+// selects the labels of the jenkins_job_* gauges.
+func newMetrics(aggregation string) *metrics {
+	jobLabels := []string{"job_name", "pid"}
+	switch aggregation {
+	case config.AggregateBuild:
+		jobLabels = buildLabels
+	case config.AggregateJob:
+		jobLabels = []string{"job_name"}
+	}
+
+	buildGauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, buildLabels)
+	}
+	stageLabels := []string{"job_name", "build_id", "stage"}
+
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		jobCPU: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_job_cpu_usage_percent",
+				Help: "Current CPU usage percentage of Jenkins jobs.",
+			},
+			jobLabels,
+		),
+		jobMem: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_job_memory_usage_percent",
+				Help: "Current memory usage percentage of Jenkins jobs.",
+			},
+			jobLabels,
+		),
+
+		buildCPU:             buildGauge("jenkins_build_cpu_usage_percent", "Current CPU usage percentage of Jenkins builds, summed over the build's process tree."),
+		buildCPUMachine:      buildGauge("jenkins_build_cpu_machine_usage_percent", "Current CPU usage of Jenkins builds as a percentage of all cores on the machine."),
+		buildMem:             buildGauge("jenkins_build_memory_usage_percent", "Current memory usage percentage of Jenkins builds, summed over the build's process tree."),
+		buildProcesses:       buildGauge("jenkins_build_processes", "Number of processes currently running for a Jenkins build."),
+		buildRSS:             buildGauge("jenkins_build_rss_bytes", "Resident set size of a Jenkins build, summed over the build's process tree."),
+		buildThreads:         buildGauge("jenkins_build_threads", "Number of threads of a Jenkins build, summed over the build's process tree."),
+		buildFDs:             buildGauge("jenkins_build_open_fds", "Number of open file descriptors of a Jenkins build, summed over the build's process tree."),
+		buildFDLimitUsage:    buildGauge("jenkins_build_fd_limit_usage_percent", "Highest share of its open file limit used by any process of a Jenkins build."),
+		buildReadBytes:       buildGauge("jenkins_build_io_read_bytes", "Bytes read from storage by the running processes of a Jenkins build since they started."),
+		buildWriteBytes:      buildGauge("jenkins_build_io_write_bytes", "Bytes written to storage by the running processes of a Jenkins build since they started."),
+		buildVoluntaryCtx:    buildGauge("jenkins_build_voluntary_context_switches", "Voluntary context switches of the running processes of a Jenkins build since they started."),
+		buildInvoluntaryCtx:  buildGauge("jenkins_build_involuntary_context_switches", "Involuntary context switches of the running processes of a Jenkins build since they started."),
+		buildCgroupLimit:     buildGauge("jenkins_build_cgroup_memory_limit_bytes", "Memory limit of the most constrained cgroup of a Jenkins build."),
+		buildCgroupUsage:     buildGauge("jenkins_build_cgroup_memory_usage_bytes", "Memory usage of the most constrained cgroup of a Jenkins build."),
+		buildCgroupMem:       buildGauge("jenkins_build_cgroup_memory_usage_percent", "Memory usage of the most constrained cgroup of a Jenkins build as a percentage of its limit."),
+		buildCgroupOOMKills:  buildGauge("jenkins_build_cgroup_oom_kills", "Processes killed by the OOM killer in the cgroup of a Jenkins build since the cgroup was created."),
+		buildCgroupThrottled: buildGauge("jenkins_build_cgroup_cpu_throttled_percent", "Share of the CPU periods of the cgroup of a Jenkins build that were throttled over the last interval."),
+		buildWorkspace:       buildGauge("jenkins_build_workspace_bytes", "Size of the workspace of a Jenkins build at its last measurement."),
+		buildWorkspaceGrowth: buildGauge("jenkins_build_workspace_growth_bytes_per_second", "Growth of the workspace of a Jenkins build between its last two complete measurements."),
+		stageCPU: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_build_stage_cpu_usage_percent",
+				Help: "Current CPU usage percentage of the processes of a pipeline stage within a Jenkins build.",
+			},
+			stageLabels,
+		),
+		stageMem: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_build_stage_memory_usage_percent",
+				Help: "Current memory usage percentage of the processes of a pipeline stage within a Jenkins build.",
+			},
+			stageLabels,
+		),
+
+		activeBuilds: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_active_builds",
+				Help: "Number of Jenkins builds with running processes.",
+			},
+		),
+		activeProcesses: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_active_processes",
+				Help: "Number of running processes belonging to Jenkins builds.",
+			},
+		),
+		buildDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "jenkins_build_duration_seconds",
+				Help:    "Wall-clock duration of finished Jenkins builds, from their first to their last process.",
+				Buckets: prometheus.ExponentialBuckets(30, 2, 10),
+			},
+			[]string{"job_name"},
+		),
+		abnormalExits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_build_abnormal_exits_total",
+				Help: "Number of Jenkins build processes that were OOM-killed or crashed, by job and cause.",
+			},
+			[]string{"job_name", "cause"},
+		),
+		remediationActions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_monitor_remediation_actions_total",
+				Help: "Number of remediation actions taken or recorded, by action and result.",
+			},
+			[]string{"action", "result"},
+		),
+
+		alertsFired: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_monitor_alerts_fired_total",
+				Help: "Number of alerts that started firing, by alert type.",
+			},
+			[]string{"type"},
+		),
+		notificationFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "jenkins_monitor_notification_failures_total",
+				Help: "Number of notifications that could not be delivered, by notifier.",
+			},
+			[]string{"notifier"},
+		),
+
+		hostLoad: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_load_average",
+				Help: "Load average of the agent over 1, 5 and 15 minutes.",
+			},
+			[]string{"period"},
+		),
+		hostCPU: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_cpu_usage_percent",
+				Help: "CPU utilization of the whole agent over the last sampling interval.",
+			},
+		),
+		hostCoreCPU: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_cpu_core_usage_percent",
+				Help: "Utilization of each CPU of the agent over the last sampling interval.",
+			},
+			[]string{"cpu"},
+		),
+		hostMemTotal: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_memory_total_bytes",
+				Help: "Total physical memory of the agent.",
+			},
+		),
+		hostMemAvailable: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_memory_available_bytes",
+				Help: "Memory of the agent available to new processes without swapping.",
+			},
+		),
+		hostSwapTotal: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_swap_total_bytes",
+				Help: "Total swap space of the agent.",
+			},
+		),
+		hostSwapUsed: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_swap_used_bytes",
+				Help: "Swap space of the agent in use.",
+			},
+		),
+		hostDiskTotal: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_disk_total_bytes",
+				Help: "Size of the filesystem holding the Jenkins workspaces.",
+			},
+			[]string{"path"},
+		),
+		hostDiskUsed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "jenkins_host_disk_used_bytes",
+				Help: "Space in use on the filesystem holding the Jenkins workspaces.",
+			},
+			[]string{"path"},
+		),
+
+		collectionDuration: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "jenkins_monitor_collection_duration_seconds",
+				Help:    "Time spent scanning processes in each collection pass.",
+				Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+			},
+		),
+		processesScanned: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_monitor_processes_scanned",
+				Help: "Number of processes examined by the last collection pass, Jenkins or not.",
+			},
+		),
+		sampleInterval: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_monitor_sample_interval_seconds",
+				Help: "Current interval between collection passes.",
+			},
+		),
+		exportedSeries: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "jenkins_monitor_exported_series",
+				Help: "Number of per-process, per-build and per-stage series exported by the last collection pass.",
+			},
+		),
+		droppedSeries: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "jenkins_monitor_series_dropped_total",
+				Help: "Number of series updates dropped because prometheus max_series was reached.",
+			},
+		),
+	}
+
+	m.registry.MustRegister(
+		m.jobCPU, m.jobMem,
+		m.buildCPU, m.buildCPUMachine, m.buildMem, m.buildProcesses, m.buildRSS, m.buildThreads, m.buildFDs,
+		m.buildFDLimitUsage, m.buildReadBytes, m.buildWriteBytes, m.buildVoluntaryCtx, m.buildInvoluntaryCtx,
+		m.buildCgroupLimit, m.buildCgroupUsage, m.buildCgroupMem, m.buildCgroupOOMKills, m.buildCgroupThrottled, m.buildWorkspace, m.buildWorkspaceGrowth, m.stageCPU, m.stageMem,
+		m.activeBuilds, m.activeProcesses, m.buildDuration, m.abnormalExits, m.remediationActions,
+		m.alertsFired, m.notificationFailures,
+		m.hostLoad, m.hostCPU, m.hostCoreCPU, m.hostMemTotal, m.hostMemAvailable, m.hostSwapTotal, m.hostSwapUsed,
+		m.hostDiskTotal, m.hostDiskUsed,
+		m.collectionDuration, m.processesScanned, m.sampleInterval, m.exportedSeries, m.droppedSeries,
+		// The runtime metrics the global registry used to provide
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	// Export zero counts so rates work before the first alert of a type
+	for _, t := range []string{
+		alert.CPUHigh, alert.MemHigh, alert.DurationHigh, alert.WorkspaceSizeHigh, alert.WorkspaceGrowthHigh,
+		alert.RSSHigh, alert.ThreadsHigh, alert.FDsHigh, alert.FDLimitHigh, alert.CgroupMemHigh, alert.CgroupThrottled,
+		alert.OOMKilled, alert.ProcessCrashed,
+	} {
+		m.alertsFired.WithLabelValues(t)
+	}
+	return m
+}
+
+// handler serves the registry in the Prometheus exposition format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// setBuilds sets the per-build and per-stage gauges of one collection pass.
+// Build series are set before process series so they are the last to be dropped.
+func (m *metrics) setBuilds(series *seriesTracker, builds []process.BuildInfo, processes int) {
+	for _, b := range builds {
+		id := []string{b.BuildJobName, b.BuildId}
+		series.set(m.buildCPU, b.CPU, id...)
+		series.set(m.buildCPUMachine, b.CPUMachine, id...)
+		series.set(m.buildMem, float64(b.Mem), id...)
+		series.set(m.buildProcesses, float64(len(b.Processes)), id...)
+		series.set(m.buildRSS, float64(b.RSS), id...)
+		series.set(m.buildThreads, float64(b.NumThreads), id...)
+		series.set(m.buildFDs, float64(b.NumFDs), id...)
+		series.set(m.buildFDLimitUsage, b.FDPercent, id...)
+		series.set(m.buildReadBytes, float64(b.ReadBytes), id...)
+		series.set(m.buildWriteBytes, float64(b.WriteBytes), id...)
+		series.set(m.buildVoluntaryCtx, float64(b.VoluntaryCtxSwitches), id...)
+		series.set(m.buildInvoluntaryCtx, float64(b.InvoluntaryCtxSwitches), id...)
+		if cg := b.Cgroup; cg != nil {
+			series.set(m.buildCgroupUsage, float64(cg.MemoryUsage), id...)
+			series.set(m.buildCgroupOOMKills, float64(cg.OOMKills), id...)
+			series.set(m.buildCgroupThrottled, cg.ThrottledPercent, id...)
+			// Unlimited cgroups have no limit or percentage to export
+			if cg.MemoryLimit > 0 {
+				series.set(m.buildCgroupLimit, float64(cg.MemoryLimit), id...)
+				series.set(m.buildCgroupMem, cg.MemoryPercent(), id...)
+			}
+		}
+		if b.WorkspaceMeasured {
+			series.set(m.buildWorkspace, float64(b.WorkspaceBytes), id...)
+			series.set(m.buildWorkspaceGrowth, b.WorkspaceGrowth, id...)
+		}
+		for _, u := range b.StageUsage {
+			series.set(m.stageCPU, u.CPU, b.BuildJobName, b.BuildId, u.Name)
+			series.set(m.stageMem, float64(u.Mem), b.BuildJobName, b.BuildId, u.Name)
+		}
+	}
+	m.activeBuilds.Set(float64(len(builds)))
+	m.activeProcesses.Set(float64(processes))
+}
+
+// setHost sets the host gauges from a snapshot
+func (m *metrics) setHost(snap host.Snapshot) {
+	m.hostLoad.WithLabelValues("1m").Set(snap.Load1)
+	m.hostLoad.WithLabelValues("5m").Set(snap.Load5)
+	m.hostLoad.WithLabelValues("15m").Set(snap.Load15)
+	m.hostCPU.Set(snap.CPUPercent)
+	for i, c := range snap.CPUCorePercent {
+		m.hostCoreCPU.WithLabelValues(strconv.Itoa(i)).Set(c)
+	}
+	m.hostMemTotal.Set(float64(snap.MemTotal))
+	m.hostMemAvailable.Set(float64(snap.MemAvailable))
+	m.hostSwapTotal.Set(float64(snap.SwapTotal))
+	m.hostSwapUsed.Set(float64(snap.SwapUsed))
+	if snap.DiskPath != "" {
+		m.hostDiskTotal.WithLabelValues(snap.DiskPath).Set(float64(snap.DiskTotal))
+		m.hostDiskUsed.WithLabelValues(snap.DiskPath).Set(float64(snap.DiskUsed))
+	}
+}
+
+// jobLabelValues returns the jenkins_job_* label values of a process
+func jobLabelValues(p process.ProcessInfo, aggregation string) []string {
+	switch aggregation {
+	case config.AggregateBuild:
+		return []string{p.BuildJobName, p.BuildId}
+	case config.AggregateJob:
+		return []string{p.BuildJobName}
+	default:
+		return []string{p.BuildJobName, fmt.Sprintf("%d", p.PID)}
+	}
+}
+
+// observeAlert counts an alert that started firing; repeats and resolutions
+// are not new alerts
+func (m *metrics) observeAlert(ev alert.Event) {
+	if ev.State == alert.Firing && !ev.Repeat {
+		m.alertsFired.WithLabelValues(ev.Type).Inc()
+	}
+}
+
+// countingNotifier counts the failed deliveries of a notifier
+type countingNotifier struct {
+	notifier.Notifier
+	failures prometheus.Counter
+}
+
+func (n countingNotifier) Notify(ev alert.Event) error {
+	err := n.Notifier.Notify(ev)
+	if err != nil {
+		n.failures.Inc()
+	}
+	return err
+}
+
+// countFailures wraps every notifier so its delivery failures are counted
+func (m *metrics) countFailures(notifiers []notifier.Notifier) []notifier.Notifier {
+	counted := make([]notifier.Notifier, 0, len(notifiers))
+	for _, n := range notifiers {
+		counted = append(counted, countingNotifier{Notifier: n, failures: m.notificationFailures.WithLabelValues(n.Name())})
+	}
+	return counted
+}
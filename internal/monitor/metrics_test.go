@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/notifier"
+	"jenkins-monitor/internal/process"
+)
+
+// failingNotifier is a notifier whose deliveries always fail
+type failingNotifier struct{}
+
+func (failingNotifier) Name() string                { return "Webhook" }
+func (failingNotifier) Notify(ev alert.Event) error { return errors.New("unreachable") }
+
+func TestMetrics(t *testing.T) {
+	// A second registry must not clash with the first
+	newMetrics(config.AggregatePID)
+	m := newMetrics(config.AggregateBuild)
+	series := newSeriesTracker(100, m.droppedSeries)
+
+	builds := []process.BuildInfo{
+		{BuildJobName: "app", BuildId: "1", RSS: 1 << 20, ReadBytes: 512, FDPercent: 87.5, InvoluntaryCtxSwitches: 42},
+		{BuildJobName: "app", BuildId: "2", WorkspaceMeasured: true, WorkspaceBytes: 4096, Cgroup: &cgroup.Stats{MemoryUsage: 1 << 30}},
+		{BuildJobName: "app", BuildId: "3", Cgroup: &cgroup.Stats{MemoryLimit: 4 << 30, MemoryUsage: 1 << 30}},
+	}
+	m.setBuilds(series, builds, 3)
+	series.finish()
+	if got := testutil.ToFloat64(m.buildRSS.WithLabelValues("app", "1")); got != 1<<20 {
+		t.Errorf("jenkins_build_rss_bytes = %.0f, want %d", got, 1<<20)
+	}
+	if got := testutil.ToFloat64(m.buildFDLimitUsage.WithLabelValues("app", "1")); got != 87.5 {
+		t.Errorf("jenkins_build_fd_limit_usage_percent = %.1f, want 87.5", got)
+	}
+	if got := testutil.ToFloat64(m.buildInvoluntaryCtx.WithLabelValues("app", "1")); got != 42 {
+		t.Errorf("jenkins_build_involuntary_context_switches = %.0f, want 42", got)
+	}
+	if got := testutil.ToFloat64(m.buildWorkspace.WithLabelValues("app", "2")); got != 4096 {
+		t.Errorf("jenkins_build_workspace_bytes = %.0f, want 4096", got)
+	}
+	// Unmeasured workspaces are not exported as empty
+	if got := testutil.CollectAndCount(m.buildWorkspace); got != 1 {
+		t.Errorf("jenkins_build_workspace_bytes has %d series, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.buildCgroupMem.WithLabelValues("app", "3")); got != 25 {
+		t.Errorf("jenkins_build_cgroup_memory_usage_percent = %.1f, want 25", got)
+	}
+	// Only limited cgroups have a percentage
+	if got := testutil.CollectAndCount(m.buildCgroupMem); got != 1 {
+		t.Errorf("jenkins_build_cgroup_memory_usage_percent has %d series, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.activeProcesses); got != 3 {
+		t.Errorf("jenkins_active_processes = %.0f, want 3", got)
+	}
+
+	m.observeAlert(alert.Event{Type: alert.CPUHigh, State: alert.Firing})
+	m.observeAlert(alert.Event{Type: alert.CPUHigh, State: alert.Firing, Repeat: true})
+	m.observeAlert(alert.Event{Type: alert.CPUHigh, State: alert.Resolved})
+	if got := testutil.ToFloat64(m.alertsFired.WithLabelValues(alert.CPUHigh)); got != 1 {
+		t.Errorf("alerts fired = %.0f, want 1", got)
+	}
+
+	notifiers := m.countFailures([]notifier.Notifier{failingNotifier{}})
+	notifiers[0].Notify(alert.Event{})
+	if got := testutil.ToFloat64(m.notificationFailures.WithLabelValues("Webhook")); got != 1 {
+		t.Errorf("notification failures = %.0f, want 1", got)
+	}
+
+	if _, err := m.registry.Gather(); err != nil {
+		t.Errorf("Gather() error = %v", err)
+	}
+}
@@ -1,53 +1,57 @@
 package monitor
 
 import (
-	"encoding/csv"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/api"
+	"jenkins-monitor/internal/cgroup"
 	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/dashboard"
+	"jenkins-monitor/internal/exits"
+	"jenkins-monitor/internal/history"
+	"jenkins-monitor/internal/host"
+	"jenkins-monitor/internal/lifecycle"
 	"jenkins-monitor/internal/notifier"
 	"jenkins-monitor/internal/process"
+	"jenkins-monitor/internal/remediation"
 	"jenkins-monitor/internal/utils"
+	"jenkins-monitor/internal/workspace"
 )
 
-var (
-	jenkinsCPUUsage = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "jenkins_job_cpu_usage_percent",
-			Help: "Current CPU usage percentage of Jenkins jobs.",
-		},
-		[]string{"job_name", "pid"},
-	)
-	jenkinsMemoryUsage = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "jenkins_job_memory_usage_percent",
-			Help: "Current memory usage percentage of Jenkins jobs.",
-		},
-		[]string{"job_name", "pid"},
-	)
-)
+func RunMonitor(outputFile string, cfg *config.Config) {
+	m := newMetrics(cfg.Prometheus.Aggregation)
+	series := newSeriesTracker(cfg.Prometheus.MaxSeries, m.droppedSeries)
 
-func init() {
-	// Register the metrics with Prometheus's default registry.
-	prometheus.MustRegister(jenkinsCPUUsage)
-	prometheus.MustRegister(jenkinsMemoryUsage)
-}
+	// The JSON API shares the metrics listener and reads history from the output file
+	var apiState *api.State
+	if cfg.Prometheus.ListenAddress != "" && !cfg.API.Disable {
+		apiState = api.NewState(cfg.API, time.Now())
+		dataFile := outputFile
+		if cfg.DisableCollection {
+			dataFile = ""
+		}
+		api.NewServer(apiState, dataFile).Register(http.DefaultServeMux)
+
+		if !cfg.Dashboard.Disable {
+			if err := dashboard.Register(http.DefaultServeMux); err != nil {
+				utils.Error(fmt.Sprintf("Failed to serve the dashboard: %v", err))
+			}
+		}
+	} else if cfg.Prometheus.ListenAddress != "" && !cfg.Dashboard.Disable {
+		utils.Info("Dashboard is not served because the API is disabled")
+	}
 
-func RunMonitor(outputFile string, cfg *config.Config) {
 	// Start Prometheus metrics HTTP server
 	if cfg.Prometheus.ListenAddress != "" {
 		go func() {
-			http.Handle("/metrics", promhttp.Handler())
+			http.Handle("/metrics", m.handler())
 			utils.Info(fmt.Sprintf("Starting Prometheus metrics server on %s", cfg.Prometheus.ListenAddress))
 			if err := http.ListenAndServe(cfg.Prometheus.ListenAddress, nil); err != nil {
 				utils.Fatal(fmt.Sprintf("Failed to start Prometheus metrics server: %v", err))
@@ -55,149 +59,329 @@ func RunMonitor(outputFile string, cfg *config.Config) {
 		}()
 	}
 
-	var file *os.File
-	var writer *csv.Writer
-	var currentDay int
+	var output *sampleOutput
+	var hostOut, workspaceOut *sidecarOutput
 
 	// Initialize CSV collection if enabled
 	if !cfg.DisableCollection {
-		// Ensure the output directory exists
-		outputDir := utils.GetDir(outputFile)
-		if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-			if err := os.MkdirAll(outputDir, 0755); err != nil {
-				utils.Fatal(fmt.Sprintf("Failed to create output directory: %v", err))
-			}
+		var err error
+		output, err = openOutput(outputFile, cfg.Storage, cfg.Retention)
+		if err != nil {
+			utils.Fatal(err.Error())
 		}
-
-		openCSV := func() {
-			var err error
-			file, err = os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		defer output.close()
+		if !cfg.Host.Disable {
+			hostOut, err = openSidecarOutput(history.HostName(outputFile), host.SchemaMarker, host.Columns, cfg.Retention)
 			if err != nil {
-				utils.Fatal(fmt.Sprintf("Failed to open output file: %v", err))
+				utils.Fatal(err.Error())
 			}
-			writer = csv.NewWriter(file)
-
-			// Write header if the file is new
-			info, err := file.Stat()
+			defer hostOut.close()
+		}
+		if !cfg.Workspaces.Disable {
+			workspaceOut, err = openSidecarOutput(history.WorkspacesName(outputFile), workspace.SchemaMarker, workspace.Columns, cfg.Retention)
 			if err != nil {
-				utils.Fatal(fmt.Sprintf("Failed to get file info: %v", err))
-			}
-			if info.Size() == 0 {
-				writer.Write([]string{"timestamp", "pid", "cpu", "mem", "build_path"})
-				writer.Flush()
+				utils.Fatal(err.Error())
 			}
+			defer workspaceOut.close()
 		}
-
-		openCSV()
-		defer func() {
-			if file != nil {
-				file.Close()
-			}
-		}()
-		currentDay = time.Now().Day()
 	} else {
 		utils.Info("Collection disabled via config. Only alerting will be active.")
 	}
 
+	// The host sampler keeps CPU times between passes like the process collector
+	var hostSampler *host.Sampler
+	diskPath := cfg.Host.WorkspacePath
+	if !cfg.Host.Disable {
+		hostSampler = host.NewSampler()
+		hostSampler.Sample(diskPath, time.Now())
+	}
+
+	// Workspaces are measured every workspaces.interval within the scan budget
+	var workspaces *workspace.Scanner
+	if !cfg.Workspaces.Disable {
+		workspaces = workspace.NewScanner(cfg.Workspaces.Interval, cfg.Workspaces.ScanBudget)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		utils.Error(fmt.Sprintf("Failed to get hostname: %v", err))
+	}
+
+	// The collector keeps per-PID CPU time between passes so CPU is measured over each interval
+	collector := process.NewCollector()
+	var cgroups *cgroup.Sampler
+	if !cfg.Cgroups.Disable {
+		cgroups = cgroup.NewSampler(cgroup.DefaultProcRoot, cfg.Cgroups.Root)
+		collector.UseCgroups(cgroups)
+	}
+	initial, err := collector.Collect()
+	if err != nil {
+		utils.Error(fmt.Sprintf("Error getting Jenkins processes: %v", err))
+	}
+
+	// Build processes that disappear are checked for OOM kills and crashes
+	var detector *exits.Detector
+	if !cfg.Exits.Disable {
+		detector = newExitDetector(cfg.Exits, cgroups)
+		defer detector.Close()
+		detector.Update(process.AggregateBuilds(initial), time.Now())
+	}
+
+	alerts := alert.NewManager(cfg.Alerting)
+	notifiers, err := notifier.New(cfg)
+	if err != nil {
+		utils.Fatal(fmt.Sprintf("Failed to configure notifiers: %v", err))
+	}
+	if len(notifiers) == 0 {
+		utils.Info("No notifiers configured. Alerts will only be logged.")
+	}
+	notify := notifier.Multi(m.countFailures(notifiers))
+	// Alerts are delivered to the notifiers and kept for the API
+	deliver := func(ev alert.Event) {
+		notify.Notify(ev)
+		if apiState != nil {
+			apiState.AddAlert(ev)
+		}
+	}
+
+	// Builds are tracked from their first process to their last for the per-build summaries
+	tracker := lifecycle.NewTracker(cfg.Builds.EndGrace)
+	summaryFile := summaryPath(outputFile, cfg.Builds)
+	var summarySlack *notifier.SlackNotifier
+	if cfg.Builds.Slack {
+		summarySlack = notifier.NewSlackNotifier(cfg.Slack, cfg.Thresholds)
+	}
+
+	// The actions of the threshold rules are taken on builds whose alerts keep firing
+	var remediator *remediation.Remediator
+	if cfg.Thresholds.HasActions() {
+		remediator = newRemediator(outputFile, cfg.Remediation, m, deliver)
+	}
+
 	// Create a channel to receive OS signals
 	sigs := make(chan os.Signal, 1)
 	// Register the channel to receive SIGINT and SIGTERM signals
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
-	// Create a ticker that ticks every 30 seconds
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	// Sample on a timer rather than a ticker so the interval can change between passes
+	interval := cfg.Interval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	m.sampleInterval.Set(interval.Seconds())
 
 	if !cfg.DisableCollection {
-		utils.Info(fmt.Sprintf("Starting process monitoring. Writing to %s", outputFile))
+		utils.Info(fmt.Sprintf("Starting process monitoring every %s. Writing to %s", interval, outputFile))
 	} else {
-		utils.Info("Starting process monitoring (Alerting Only).")
+		utils.Info(fmt.Sprintf("Starting process monitoring every %s (Alerting Only).", interval))
+	}
+	if summaryFile != "" {
+		utils.Info(fmt.Sprintf("Writing build summaries to %s", summaryFile))
 	}
 	utils.Info("Press Ctrl+C to stop...")
 
 	// Run the collection logic in a loop
 	for {
 		select {
-		case <-ticker.C:
-			processes, err := process.GetJenkinsProcesses()
+		case <-timer.C:
+			start := time.Now()
+			processes, err := collector.Collect()
+			elapsed := time.Since(start)
+			m.collectionDuration.Observe(elapsed.Seconds())
+			m.processesScanned.Set(float64(collector.Scanned()))
 			if err != nil {
 				utils.Error(fmt.Sprintf("Error getting Jenkins processes: %v", err))
+				if apiState != nil {
+					apiState.CollectionFailed(err, time.Now())
+				}
+				timer.Reset(interval)
 				continue
 			}
 
-			// Log Rotation Logic
+			// Rotate at the day boundary or size limit before writing this pass
 			if !cfg.DisableCollection {
-				now := time.Now()
-				if now.Day() != currentDay {
-					utils.Info("Rotating log file...")
-					file.Close()
-
-					// Rename old file
-					yesterday := now.AddDate(0, 0, -1)
-					rotatedName := fmt.Sprintf("%s.%s.csv", outputFile, yesterday.Format("2006-01-02"))
-					// Handle if outputFile is just a filename or path
-					ext := filepath.Ext(outputFile)
-					base := strings.TrimSuffix(outputFile, ext)
-					rotatedName = fmt.Sprintf("%s.%s%s", base, yesterday.Format("2006-01-02"), ext)
-
-					if err := os.Rename(outputFile, rotatedName); err != nil {
-						utils.Error(fmt.Sprintf("Failed to rotate log file: %v", err))
+				rotated, err := output.rotateIfNeeded(time.Now())
+				if err != nil {
+					utils.Fatal(err.Error())
+				}
+				for _, side := range []*sidecarOutput{hostOut, workspaceOut} {
+					if side == nil {
+						continue
 					}
-
-					// Re-open new file
-					var err error
-					file, err = os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-					if err != nil {
-						utils.Fatal(fmt.Sprintf("Failed to open output file: %v", err))
+					if err := side.rotateIfNeeded(time.Now(), rotated); err != nil {
+						utils.Fatal(err.Error())
 					}
-					writer = csv.NewWriter(file)
-					writer.Write([]string{"timestamp", "pid", "cpu", "mem", "build_path"})
-					writer.Flush()
+				}
+			}
 
-					currentDay = now.Day()
-					utils.Info(fmt.Sprintf("Log rotated. New file: %s", outputFile))
+			builds := process.AggregateBuilds(processes)
+			if workspaces != nil {
+				measured, err := workspaces.Scan(workspacePaths(builds), time.Now())
+				if err != nil {
+					utils.Error(fmt.Sprintf("Failed to measure workspaces: %v", err))
+				}
+				applyWorkspaces(builds, workspaces)
+				if workspaceOut != nil {
+					for _, sample := range workspaceSamples(measured, builds, hostname) {
+						if err := workspaceOut.write(sample.Fields()); err != nil {
+							utils.Error(fmt.Sprintf("Failed to write workspace samples file: %v", err))
+						}
+					}
 				}
 			}
+			m.setBuilds(series, builds, len(processes))
 
-			timestamp := time.Now().UTC().Format(time.RFC3339)
-			for _, p := range processes {
-				// Update Prometheus metrics
-				labels := prometheus.Labels{"job_name": p.BuildJobName, "pid": fmt.Sprintf("%d", p.PID)}
-				jenkinsCPUUsage.With(labels).Set(p.CPU)
-				jenkinsMemoryUsage.With(labels).Set(float64(p.Mem))
-
-				// Check for thresholds and send Slack notifications
-				if cfg.Thresholds.CPUPercent > 0 && p.CPU >= cfg.Thresholds.CPUPercent {
-					utils.Info(fmt.Sprintf("High CPU usage detected for job %s (PID %d): %.2f%% (Threshold: %.2f%%)", p.BuildJobName, p.PID, p.CPU, cfg.Thresholds.CPUPercent))
-					notifier.SendSlackNotification(cfg, "CPU_HIGH", &p)
+			// Host context, so a busy build can be told apart from an overloaded agent
+			var hostSnap *host.Snapshot
+			if hostSampler != nil {
+				if diskPath == "" {
+					diskPath = workspaceRoot(builds)
 				}
-				if cfg.Thresholds.MemPercent > 0 && float64(p.Mem) >= cfg.Thresholds.MemPercent {
-					utils.Info(fmt.Sprintf("High Memory usage detected for job %s (PID %d): %.2f%% (Threshold: %.2f%%)", p.BuildJobName, p.PID, p.Mem, cfg.Thresholds.MemPercent))
-					notifier.SendSlackNotification(cfg, "MEM_HIGH", &p)
+				snap := hostSampler.Sample(diskPath, time.Now())
+				hostSnap = &snap
+				m.setHost(snap)
+				if hostOut != nil {
+					if err := hostOut.write(snap.Fields(hostname)); err != nil {
+						utils.Error(fmt.Sprintf("Failed to write host samples file: %v", err))
+					}
 				}
+			}
+
+			timestamp := time.Now()
+			for _, p := range processes {
+				// Update Prometheus metrics, summed per build or job when aggregated
+				labels := jobLabelValues(p, cfg.Prometheus.Aggregation)
+				series.add(m.jobCPU, p.CPU, labels...)
+				series.add(m.jobMem, float64(p.Mem), labels...)
 
 				// Write to CSV if collection is enabled
 				if !cfg.DisableCollection {
-					record := []string{
-						timestamp,
-						fmt.Sprintf("%d", p.PID),
-						fmt.Sprintf("%.2f", p.CPU),
-						fmt.Sprintf("%.2f", p.Mem),
-						p.BuildJobName,
-					}
-					writer.Write(record)
+					output.write(history.NewRecord(p, hostname, timestamp))
+				}
+			}
+			// Series of exited processes and builds are removed
+			m.exportedSeries.Set(float64(series.finish()))
+
+			started, finished := tracker.Update(builds, time.Now())
+			for _, b := range started {
+				utils.Info(fmt.Sprintf("Build %s #%s started (workspace %s)", b.BuildJobName, b.BuildId, b.WorkSpace))
+			}
+			for _, sum := range finished {
+				m.buildDuration.WithLabelValues(sum.JobName).Observe(sum.Duration)
+				if err := reportSummary(sum, summaryFile, summarySlack); err != nil {
+					m.notificationFailures.WithLabelValues(summarySlack.Name()).Inc()
+				}
+			}
+
+			// Check thresholds per build and notify only on alert transitions
+			observations := alert.Observe(builds, cfg.Thresholds, time.Now())
+			events := alerts.Evaluate(observations)
+			for i := range events {
+				ev := &events[i]
+				ev.Host = hostSnap
+				logAlert(*ev)
+				m.observeAlert(*ev)
+				deliver(*ev)
+			}
+			if remediator != nil {
+				remediator.Update(events, observations, time.Now())
+			}
+
+			// Exits are one-off events, notified as soon as they are found
+			if detector != nil {
+				for _, e := range detector.Update(builds, time.Now()) {
+					m.abnormalExits.WithLabelValues(e.Process.BuildJobName, string(e.Cause)).Inc()
+					ev := alert.ExitEvent(e, cfg.Thresholds)
+					ev.Host = hostSnap
+					logAlert(ev)
+					m.observeAlert(ev)
+					deliver(ev)
 				}
 			}
+
 			if !cfg.DisableCollection {
-				writer.Flush()
-				utils.Info(fmt.Sprintf("Collected data for %d processes", len(processes)))
+				output.flush()
+				utils.Info(fmt.Sprintf("Collected data for %d processes in %s", len(processes), elapsed.Round(time.Millisecond)))
 			} else {
-				utils.Info(fmt.Sprintf("Monitored %d processes (Collection Disabled)", len(processes)))
+				utils.Info(fmt.Sprintf("Monitored %d processes in %s (Collection Disabled)", len(processes), elapsed.Round(time.Millisecond)))
 			}
 
+			next := nextInterval(cfg, observations)
+			if next != interval {
+				utils.Info(fmt.Sprintf("Sampling interval changed from %s to %s", interval, next))
+				interval = next
+				m.sampleInterval.Set(interval.Seconds())
+			}
+			if apiState != nil {
+				apiState.Collected(builds, interval, time.Now())
+			}
+			timer.Reset(interval)
+
 		case <-sigs:
 			utils.Info("Exiting...")
+			if remediator != nil {
+				remediator.Close()
+			}
 			return
 		}
 	}
 }
+
+// reportSummary logs the summary of a finished build and writes it to the
+// summary file and Slack when they are enabled. It returns the error of the
+// Slack delivery, which has already been logged.
+func reportSummary(sum lifecycle.Summary, summaryFile string, slack *notifier.SlackNotifier) error {
+	utils.Info(fmt.Sprintf("Build %s #%s finished after %s: CPU peak %.2f%% avg %.2f%%, memory peak %.2f%% avg %.2f%%, %.1f CPU-seconds, stages [%s]",
+		sum.JobName, sum.BuildID, time.Duration(sum.Duration*float64(time.Second)).Round(time.Second),
+		sum.PeakCPU, sum.AvgCPU, sum.PeakMem, sum.AvgMem, sum.CPUSeconds, strings.Join(sum.Stages, ", ")))
+
+	if summaryFile != "" {
+		if err := appendSummary(summaryFile, sum); err != nil {
+			utils.Error(err.Error())
+		}
+	}
+	if slack != nil {
+		if err := slack.NotifySummary(sum); err != nil {
+			utils.Error(fmt.Sprintf("Failed to send Slack build summary for job %s #%s: %v", sum.JobName, sum.BuildID, err))
+			return err
+		}
+	}
+	return nil
+}
+
+// logAlert records an alert transition in the application log
+func logAlert(ev alert.Event) {
+	b := ev.Build
+	switch {
+	case ev.Action != nil:
+		a := ev.Action
+		utils.Info(fmt.Sprintf("Remediation %s for job %s #%s on PIDs %v after %s (rule %s, dry run %t): %s %s",
+			a.Action, b.BuildJobName, b.BuildId, a.PIDs, a.Alert, a.Rule, a.DryRun, a.Result, a.Detail))
+	case ev.Exit != nil:
+		p := ev.Exit.Process
+		utils.Info(fmt.Sprintf("Alert %s for job %s #%s: process %s (PID %d) in stage %q exited, last seen with %s resident (rule %s, severity %s): %s",
+			ev.Type, b.BuildJobName, b.BuildId, p.Name, p.PID, p.StageName, utils.FormatBytes(p.RSS), ev.Rule.Name, ev.Rule.Severity, ev.Exit.Evidence))
+	case ev.State == alert.Resolved && ev.Exited:
+		utils.Info(fmt.Sprintf("Alert %s resolved for job %s #%s: build exited", ev.Type, b.BuildJobName, b.BuildId))
+	case ev.State == alert.Resolved:
+		utils.Info(fmt.Sprintf("Alert %s resolved for job %s #%s: %s (Threshold: %s, rule %s)", ev.Type, b.BuildJobName, b.BuildId,
+			alert.FormatValue(ev.Type, ev.Value), alert.FormatValue(ev.Type, ev.Threshold), ev.Rule.Name))
+	default:
+		utils.Info(fmt.Sprintf("Alert %s firing for job %s #%s (%d processes): %s (Threshold: %s, rule %s, severity %s)", ev.Type, b.BuildJobName, b.BuildId, len(b.Processes),
+			alert.FormatValue(ev.Type, ev.Value), alert.FormatValue(ev.Type, ev.Threshold), ev.Rule.Name, ev.Rule.Severity))
+	}
+}
+
+// nextInterval returns the adaptive interval while any build is above a
+// threshold and the regular interval otherwise
+func nextInterval(cfg *config.Config, observations []alert.Observation) time.Duration {
+	if cfg.AdaptiveInterval <= 0 {
+		return cfg.Interval
+	}
+	for _, o := range observations {
+		// A long running build is not a resource spike worth sampling faster for
+		if o.Breached && o.Type != alert.DurationHigh {
+			return cfg.AdaptiveInterval
+		}
+	}
+	return cfg.Interval
+}
@@ -0,0 +1,222 @@
+package monitor
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/history"
+	"jenkins-monitor/internal/utils"
+)
+
+// recordWriter appends the records of one collection pass to an output file
+type recordWriter interface {
+	Write(records []history.Record) error
+	Close() error
+}
+
+// csvWriter appends records to a CSV file, writing the header if the file is new
+type csvWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func openCSVWriter(path string) (recordWriter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if info.Size() == 0 {
+		if err := history.WriteHeader(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write header: %w", err)
+		}
+	}
+	return &csvWriter{file: file, writer: csv.NewWriter(file)}, nil
+}
+
+func (w *csvWriter) Write(records []history.Record) error {
+	for _, r := range records {
+		w.writer.Write(r.Fields())
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvWriter) Close() error {
+	return w.file.Close()
+}
+
+func openStoreWriter(path string) (recordWriter, error) {
+	return history.OpenStoreWriter(path)
+}
+
+// sampleOutput appends samples to the output file and rotates it at the day
+// boundary or once it grows past the configured size, applying the retention
+// settings to the rotated files
+type sampleOutput struct {
+	path      string
+	storage   string
+	retention config.RetentionConfig
+	writer    recordWriter
+	day       time.Time
+	pending   []history.Record
+}
+
+// openOutput opens the output file for appending, creating it and its directory if needed
+func openOutput(path, storage string, retention config.RetentionConfig) (*sampleOutput, error) {
+	outputDir := utils.GetDir(path)
+	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	o := &sampleOutput{path: path, storage: storage, retention: retention}
+	o.rotateOldSchema()
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	o.prune(time.Now())
+	return o, nil
+}
+
+// open opens the output file in the configured storage format
+func (o *sampleOutput) open() error {
+	// Keep the day of a file that was left over from an earlier run so it is
+	// still rotated under the day it was written
+	o.day = startOfDay(time.Now())
+	if info, err := os.Stat(o.path); err == nil && info.Size() > 0 {
+		o.day = startOfDay(info.ModTime())
+	}
+
+	open := openCSVWriter
+	if o.storage == config.StorageTSDB {
+		open = openStoreWriter
+	}
+	writer, err := open(o.path)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	o.writer = writer
+	return nil
+}
+
+// rotateOldSchema moves an existing output file written with an older schema
+// or store version out of the way, so new rows are never appended under a
+// stale header
+func (o *sampleOutput) rotateOldSchema() {
+	info, err := os.Stat(o.path)
+	if err != nil {
+		return
+	}
+	fileVersion, current := history.FileVersion, history.SchemaVersion
+	if o.storage == config.StorageTSDB {
+		fileVersion, current = history.StoreFileVersion, history.StoreVersion
+	}
+	version, err := fileVersion(o.path)
+	if err == nil && (version == 0 || version == current) {
+		return
+	}
+
+	rotatedName := history.NextRotatedName(o.path, startOfDay(info.ModTime()))
+	if err := os.Rename(o.path, rotatedName); err != nil {
+		utils.Error(fmt.Sprintf("Failed to rotate output file with an old schema: %v", err))
+		return
+	}
+	utils.Info(fmt.Sprintf("Output file %s uses an older schema; moved it to %s", o.path, rotatedName))
+}
+
+// rotateIfNeeded rotates the output file when the day has changed since it
+// was opened or it has reached the size limit, and reports whether it did
+func (o *sampleOutput) rotateIfNeeded(now time.Time) (bool, error) {
+	day := startOfDay(now)
+	newDay := day.After(o.day)
+	tooBig := false
+	if o.retention.MaxFileSizeMB > 0 {
+		if info, err := os.Stat(o.path); err == nil {
+			tooBig = info.Size() >= o.retention.MaxFileSizeMB*1024*1024
+		}
+	}
+	if !newDay && !tooBig {
+		return false, nil
+	}
+
+	utils.Info("Rotating log file...")
+	o.writer.Close()
+	rotatedName := rotateFile(o.path, o.day, o.retention)
+	if err := o.open(); err != nil {
+		return true, err
+	}
+	utils.Info(fmt.Sprintf("Log rotated to %s. New file: %s", rotatedName, o.path))
+	o.prune(now)
+	return true, nil
+}
+
+// prune deletes the rotated files that fall outside the retention limits
+func (o *sampleOutput) prune(now time.Time) {
+	pruneFiles(o.path, o.retention, now)
+}
+
+// rotateFile moves a closed output file aside under the day it was written,
+// compressing it if configured, and returns its new name. A file rotated at
+// the day boundary holds the previous day's samples.
+func rotateFile(path string, day time.Time, retention config.RetentionConfig) string {
+	rotatedName := history.NextRotatedName(path, day)
+	if err := os.Rename(path, rotatedName); err != nil {
+		utils.Error(fmt.Sprintf("Failed to rotate log file: %v", err))
+		return path
+	}
+	if retention.Compress {
+		compressed, err := history.Compress(rotatedName)
+		if err != nil {
+			utils.Error(fmt.Sprintf("Failed to compress rotated file: %v", err))
+			return rotatedName
+		}
+		return compressed
+	}
+	return rotatedName
+}
+
+// pruneFiles deletes the rotated copies of path that fall outside the retention limits
+func pruneFiles(path string, retention config.RetentionConfig, now time.Time) {
+	removed, err := history.Prune(path, retention, now)
+	for _, r := range removed {
+		utils.Info(fmt.Sprintf("Deleted rotated file %s (retention)", r))
+	}
+	if err != nil {
+		utils.Error(fmt.Sprintf("Failed to apply retention: %v", err))
+	}
+}
+
+// write buffers one sample until the next flush
+func (o *sampleOutput) write(record history.Record) {
+	o.pending = append(o.pending, record)
+}
+
+// flush writes the buffered samples of a collection pass to the file
+func (o *sampleOutput) flush() {
+	if err := o.writer.Write(o.pending); err != nil {
+		utils.Error(fmt.Sprintf("Failed to write output file: %v", err))
+	}
+	o.pending = o.pending[:0]
+}
+
+// close flushes and closes the output file
+func (o *sampleOutput) close() {
+	o.flush()
+	o.writer.Close()
+}
+
+// startOfDay truncates t to local midnight
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,38 @@
+package monitor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/remediation"
+	"jenkins-monitor/internal/utils"
+)
+
+// auditPath returns the file remediation actions are appended to
+func auditPath(outputFile string, cfg config.RemediationConfig) string {
+	if cfg.AuditLog != "" {
+		return cfg.AuditLog
+	}
+	return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".remediation.jsonl"
+}
+
+// newRemediator creates a remediator that logs, counts and delivers every action
+func newRemediator(outputFile string, cfg config.RemediationConfig, m *metrics, deliver func(alert.Event)) *remediation.Remediator {
+	path := auditPath(outputFile, cfg)
+	switch {
+	case len(cfg.AllowedJobs) == 0:
+		utils.Info("Remediation actions are configured but remediation allowed_jobs is empty. No action will be taken.")
+	case cfg.DryRun:
+		utils.Info(fmt.Sprintf("Remediation dry run: actions will only be recorded in %s", path))
+	default:
+		utils.Info(fmt.Sprintf("Remediation enabled for jobs %s. Recording actions in %s", strings.Join(cfg.AllowedJobs, ", "), path))
+	}
+	return remediation.New(cfg, path, func(ev alert.Event) {
+		logAlert(ev)
+		m.remediationActions.WithLabelValues(ev.Action.Action, ev.Action.Result).Inc()
+		deliver(ev)
+	})
+}
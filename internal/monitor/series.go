@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// seriesKey identifies one labelled series of a gauge vector
+type seriesKey struct {
+	vec    *prometheus.GaugeVec
+	labels string
+}
+
+// seriesTracker sets the labelled gauges of one collection pass. Series that
+// are not set again in the next pass belong to exited processes or builds and
+// are deleted, and series beyond the limit are dropped and counted, so the
+// number of exported series stays bounded on long-running agents.
+type seriesTracker struct {
+	limit   int
+	dropped prometheus.Counter
+	current map[seriesKey][]string
+	prev    map[seriesKey][]string
+}
+
+func newSeriesTracker(limit int, dropped prometheus.Counter) *seriesTracker {
+	return &seriesTracker{
+		limit:   limit,
+		dropped: dropped,
+		current: make(map[seriesKey][]string),
+		prev:    make(map[seriesKey][]string),
+	}
+}
+
+// set sets a series for this pass unless the limit has been reached. Series
+// are kept in the order they are set, so aggregates should be set first.
+func (t *seriesTracker) set(vec *prometheus.GaugeVec, value float64, labelValues ...string) {
+	key := seriesKey{vec: vec, labels: strings.Join(labelValues, "\xff")}
+	if _, ok := t.current[key]; !ok {
+		if len(t.current) >= t.limit {
+			t.dropped.Inc()
+			return
+		}
+		t.current[key] = labelValues
+	}
+	vec.WithLabelValues(labelValues...).Set(value)
+}
+
+// add adds to a series for this pass, for gauges summed over several processes
+func (t *seriesTracker) add(vec *prometheus.GaugeVec, value float64, labelValues ...string) {
+	key := seriesKey{vec: vec, labels: strings.Join(labelValues, "\xff")}
+	if _, ok := t.current[key]; ok {
+		vec.WithLabelValues(labelValues...).Add(value)
+		return
+	}
+	t.set(vec, value, labelValues...)
+}
+
+// finish deletes the series of the previous pass that were not set in this
+// one and returns the number of series exported
+func (t *seriesTracker) finish() int {
+	for key, labelValues := range t.prev {
+		if _, ok := t.current[key]; !ok {
+			key.vec.DeleteLabelValues(labelValues...)
+		}
+	}
+	t.prev, t.current = t.current, t.prev
+	clear(t.current)
+	return len(t.prev)
+}
@@ -0,0 +1,58 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesTracker(t *testing.T) {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_usage"}, []string{"job_name", "pid"})
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_dropped_total"})
+	series := newSeriesTracker(2, dropped)
+
+	passes := []struct {
+		pids        []string
+		wantSeries  int
+		wantDropped float64
+	}{
+		{pids: []string{"1", "2"}, wantSeries: 2},
+		// PID 1 exited; its series is deleted
+		{pids: []string{"2"}, wantSeries: 1},
+		// PID 5 is beyond the limit
+		{pids: []string{"2", "3", "5"}, wantSeries: 2, wantDropped: 1},
+		{pids: nil, wantSeries: 0, wantDropped: 1},
+	}
+
+	for i, pass := range passes {
+		for _, pid := range pass.pids {
+			series.set(vec, 1, "app", pid)
+		}
+		if got := series.finish(); got != pass.wantSeries {
+			t.Errorf("pass %d: finish() = %d, want %d", i, got, pass.wantSeries)
+		}
+		if got := testutil.CollectAndCount(vec); got != pass.wantSeries {
+			t.Errorf("pass %d: %d series collected, want %d", i, got, pass.wantSeries)
+		}
+		if got := testutil.ToFloat64(dropped); got != pass.wantDropped {
+			t.Errorf("pass %d: dropped = %.0f, want %.0f", i, got, pass.wantDropped)
+		}
+	}
+}
+
+func TestSeriesTrackerAdd(t *testing.T) {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_usage"}, []string{"job_name"})
+	series := newSeriesTracker(10, prometheus.NewCounter(prometheus.CounterOpts{Name: "test_dropped_total"}))
+
+	for pass, values := range [][]float64{{10, 20}, {5}} {
+		for _, v := range values {
+			series.add(vec, v, "app")
+		}
+		series.finish()
+		want := []float64{30, 5}[pass]
+		if got := testutil.ToFloat64(vec.WithLabelValues("app")); got != want {
+			t.Errorf("pass %d: value = %.0f, want %.0f", pass, got, want)
+		}
+	}
+}
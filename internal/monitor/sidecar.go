@@ -0,0 +1,82 @@
+package monitor
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+
+	"jenkins-monitor/internal/config"
+)
+
+// sidecarOutput appends rows to a CSV file written next to the output file,
+// such as the host and workspace samples. It is rotated and pruned together
+// with the output file.
+type sidecarOutput struct {
+	path      string
+	marker    string
+	columns   []string
+	retention config.RetentionConfig
+	file      *os.File
+	writer    *csv.Writer
+	day       time.Time
+}
+
+func openSidecarOutput(path, marker string, columns []string, retention config.RetentionConfig) (*sidecarOutput, error) {
+	s := &sidecarOutput{path: path, marker: marker, columns: columns, retention: retention}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	pruneFiles(path, retention, time.Now())
+	return s, nil
+}
+
+// open opens the file for appending, writing the schema marker and header when it is new
+func (s *sidecarOutput) open() error {
+	s.day = startOfDay(time.Now())
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	s.file = file
+	s.writer = csv.NewWriter(file)
+	if info.Size() > 0 {
+		s.day = startOfDay(info.ModTime())
+		return nil
+	}
+	fmt.Fprintln(file, s.marker)
+	s.writer.Write(s.columns)
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// write appends one row
+func (s *sidecarOutput) write(row []string) error {
+	s.writer.Write(row)
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// rotateIfNeeded rotates the file on a new day, or when the output file was
+// rotated for its size so both cover the same period
+func (s *sidecarOutput) rotateIfNeeded(now time.Time, outputRotated bool) error {
+	if !outputRotated && !startOfDay(now).After(s.day) {
+		return nil
+	}
+	s.file.Close()
+	rotateFile(s.path, s.day, s.retention)
+	if err := s.open(); err != nil {
+		return err
+	}
+	pruneFiles(s.path, s.retention, now)
+	return nil
+}
+
+func (s *sidecarOutput) close() {
+	s.file.Close()
+}
@@ -0,0 +1,57 @@
+package monitor
+
+import (
+	"path/filepath"
+
+	"jenkins-monitor/internal/process"
+	"jenkins-monitor/internal/workspace"
+)
+
+// workspaceRoot returns the directory holding the workspaces of the running
+// builds, the parent of the first workspace found
+func workspaceRoot(builds []process.BuildInfo) string {
+	for _, b := range builds {
+		if b.WorkSpace != "" {
+			return filepath.Dir(filepath.Clean(b.WorkSpace))
+		}
+	}
+	return ""
+}
+
+// workspacePaths returns the workspaces of the running builds
+func workspacePaths(builds []process.BuildInfo) []string {
+	paths := make([]string, 0, len(builds))
+	for _, b := range builds {
+		if b.WorkSpace != "" {
+			paths = append(paths, b.WorkSpace)
+		}
+	}
+	return paths
+}
+
+// applyWorkspaces copies the latest measurement of each build's workspace
+// into the build, so alerts and metrics see it between measurements
+func applyWorkspaces(builds []process.BuildInfo, scanner *workspace.Scanner) {
+	for i := range builds {
+		b := &builds[i]
+		if u, ok := scanner.Last(b.WorkSpace); ok {
+			b.WorkspaceBytes, b.WorkspaceGrowth, b.WorkspaceMeasured = u.Bytes, u.Growth, true
+		}
+	}
+}
+
+// workspaceSamples returns a row of the workspace samples file for every
+// build using a workspace measured in this pass
+func workspaceSamples(measured []workspace.Usage, builds []process.BuildInfo, hostname string) []workspace.Sample {
+	byPath := make(map[string]workspace.Usage, len(measured))
+	for _, u := range measured {
+		byPath[u.Path] = u
+	}
+	var samples []workspace.Sample
+	for _, b := range builds {
+		if u, ok := byPath[b.WorkSpace]; ok {
+			samples = append(samples, workspace.Sample{Host: hostname, JobName: b.BuildJobName, BuildID: b.BuildId, Usage: u})
+		}
+	}
+	return samples
+}
@@ -0,0 +1,143 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+)
+
+// EmailNotifier sends alert events as plain-text email over SMTP
+type EmailNotifier struct {
+	cfg     config.EmailConfig
+	timeout time.Duration
+}
+
+// NewEmailNotifier creates an SMTP backend
+func NewEmailNotifier(cfg config.EmailConfig) *EmailNotifier {
+	return &EmailNotifier{cfg: cfg, timeout: smtpTimeout}
+}
+
+// Name implements Notifier
+func (e *EmailNotifier) Name() string {
+	if e.cfg.Name != "" {
+		return "Email " + e.cfg.Name
+	}
+	return "Email"
+}
+
+// smtpTimeout bounds dialing and, as a deadline on the connection, the whole
+// SMTP session, so a server that stalls cannot stall the monitor loop
+const smtpTimeout = 10 * time.Second
+
+// Notify implements Notifier
+func (e *EmailNotifier) Notify(ev alert.Event) error {
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+	msg, err := e.message(ev)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{Timeout: e.timeout}
+	var conn net.Conn
+	if e.cfg.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: e.cfg.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(e.timeout)); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	client, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	// Upgrade plain connections with STARTTLS when the server offers it
+	if !e.cfg.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: e.cfg.Host}); err != nil {
+				return fmt.Errorf("SMTP STARTTLS failed: %w", err)
+			}
+		}
+	}
+	if e.cfg.Username != "" {
+		auth := smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range e.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// headerValue fails on line breaks, which would end the header and let the
+// value inject headers or body of its own
+func headerValue(name, v string) (string, error) {
+	if strings.ContainsAny(v, "\r\n") {
+		return "", fmt.Errorf("email %s must not contain line breaks: %q", name, v)
+	}
+	return v, nil
+}
+
+// message renders the RFC 5322 message for ev
+func (e *EmailNotifier) message(ev alert.Event) ([]byte, error) {
+	from, err := headerValue("from", e.cfg.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := headerValue("to", strings.Join(e.cfg.To, ", "))
+	if err != nil {
+		return nil, err
+	}
+	subject, err := headerValue("subject", fmt.Sprintf("%s - %s #%s", title(ev), ev.Build.BuildJobName, ev.Build.BuildId))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", ev.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+
+	fmt.Fprintf(&buf, "%s\r\n\r\n", title(ev))
+	for _, f := range facts(ev) {
+		fmt.Fprintf(&buf, "%-14s %s\r\n", f.Name+":", f.Value)
+	}
+	return buf.Bytes(), nil
+}
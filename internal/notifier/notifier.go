@@ -2,179 +2,258 @@ package notifier
 
 import (
 	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/cgroup"
 	"jenkins-monitor/internal/config"
-	"jenkins-monitor/internal/process"
+	"jenkins-monitor/internal/exits"
 	"jenkins-monitor/internal/utils"
 )
 
-// SlackMessage represents the structure of a Slack message
-type SlackMessage struct {
-	Channel     string       `json:"channel,omitempty"`
-	Username    string       `json:"username,omitempty"`
-	IconEmoji   string       `json:"icon_emoji,omitempty"`
-	Attachments []Attachment `json:"attachments,omitempty"`
+// Notifier delivers alert events to a single backend
+type Notifier interface {
+	// Name identifies the backend in logs
+	Name() string
+	// Notify delivers one alert event
+	Notify(ev alert.Event) error
 }
 
-// Attachment represents a Slack message attachment
-type Attachment struct {
-	Color  string  `json:"color,omitempty"`
-	Blocks []Block `json:"blocks,omitempty"`
-}
+// httpClient is shared by the HTTP based backends so a hung endpoint cannot stall the monitor loop
+var httpClient = &http.Client{Timeout: 10 * time.Second}
 
-// Block represents a generic Slack block
-type Block interface {
-	isBlock()
-}
+// New builds a Notifier for every backend enabled in the configuration
+func New(cfg *config.Config) ([]Notifier, error) {
+	var notifiers []Notifier
 
-// SectionBlock represents a section block
-type SectionBlock struct {
-	Type   string          `json:"type"`
-	Text   *MarkdownText   `json:"text,omitempty"`
-	Fields []*MarkdownText `json:"fields,omitempty"`
+	if cfg.Slack.WebhookURL != "" {
+		notifiers = append(notifiers, NewSlackNotifier(cfg.Slack, cfg.Thresholds))
+	}
+	for _, wc := range cfg.Notifiers.Webhooks {
+		n, err := NewWebhookNotifier(wc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	for _, tc := range cfg.Notifiers.Teams {
+		notifiers = append(notifiers, NewTeamsNotifier(tc))
+	}
+	for _, ec := range cfg.Notifiers.Email {
+		notifiers = append(notifiers, NewEmailNotifier(ec))
+	}
+
+	return notifiers, nil
 }
 
-func (b SectionBlock) isBlock() {}
+// Multi fans an alert event out to several notifiers
+type Multi []Notifier
 
-// HeaderBlock represents a header block
-type HeaderBlock struct {
-	Type string    `json:"type"`
-	Text PlainText `json:"text"`
+// Notify delivers ev to every backend, logging each failure, and returns the
+// combined error of the backends that failed
+func (m Multi) Notify(ev alert.Event) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ev); err != nil {
+			utils.Error(fmt.Sprintf("Failed to send %s notification for job %s #%s: %v", n.Name(), ev.Build.BuildJobName, ev.Build.BuildId, err))
+			errs = append(errs, fmt.Errorf("%s: %w", n.Name(), err))
+			continue
+		}
+		utils.Info(fmt.Sprintf("%s notification sent successfully for job %s #%s (%s %s)", n.Name(), ev.Build.BuildJobName, ev.Build.BuildId, ev.Type, ev.State))
+	}
+	return errors.Join(errs...)
 }
 
-func (b HeaderBlock) isBlock() {}
-
-// DividerBlock represents a divider block
-type DividerBlock struct {
-	Type string `json:"type"`
+// fact is a labelled value shown in a notification body
+type fact struct {
+	Name  string
+	Value string
 }
 
-func (b DividerBlock) isBlock() {}
+// title returns the headline for an alert event
+func title(ev alert.Event) string {
+	var t string
+	switch ev.Type {
+	case alert.CPUHigh:
+		t = "Jenkins Monitor Alert: High CPU Usage"
+	case alert.MemHigh:
+		t = "Jenkins Monitor Alert: High Memory Usage"
+	case alert.DurationHigh:
+		t = "Jenkins Monitor Alert: Long Running Build"
+	case alert.WorkspaceSizeHigh:
+		t = "Jenkins Monitor Alert: Large Workspace"
+	case alert.WorkspaceGrowthHigh:
+		t = "Jenkins Monitor Alert: Fast Growing Workspace"
+	case alert.RSSHigh:
+		t = "Jenkins Monitor Alert: High Resident Memory"
+	case alert.ThreadsHigh:
+		t = "Jenkins Monitor Alert: Too Many Threads"
+	case alert.FDsHigh:
+		t = "Jenkins Monitor Alert: Too Many Open Files"
+	case alert.FDLimitHigh:
+		t = "Jenkins Monitor Alert: Open File Limit Nearly Reached"
+	case alert.CgroupMemHigh:
+		t = "Jenkins Monitor Alert: Cgroup Memory Limit Nearly Reached"
+	case alert.CgroupThrottled:
+		t = "Jenkins Monitor Alert: CPU Throttled"
+	case alert.OOMKilled:
+		t = "Jenkins Monitor Alert: Build Process OOM-Killed"
+	case alert.ProcessCrashed:
+		t = "Jenkins Monitor Alert: Build Process Crashed"
+	case alert.Remediation:
+		t = "Jenkins Monitor: Remediation Action Taken"
+		if ev.Action.DryRun {
+			t = "[Dry Run] Jenkins Monitor: Remediation Action"
+		}
+	default:
+		t = "Jenkins Monitor Alert"
+	}
+	if ev.State == alert.Resolved {
+		t = "[Resolved] " + t
+	}
+	return t
+}
 
-// ContextBlock represents a context block
-type ContextBlock struct {
-	Type     string         `json:"type"`
-	Elements []MarkdownText `json:"elements"`
+// color returns the hex color (without '#') used to highlight an alert event
+func color(ev alert.Event) string {
+	if ev.State == alert.Resolved {
+		return "2EB67D" // Green
+	}
+	switch ev.Rule.Severity {
+	case config.SeverityCritical:
+		return "FF0000" // Red
+	case config.SeverityWarning:
+		return "FFA500" // Orange
+	case config.SeverityInfo:
+		return "439FE0" // Blue
+	default:
+		return "CCCCCC" // Grey
+	}
 }
 
-func (b ContextBlock) isBlock() {}
+// status describes where an alert event is in its lifecycle
+func status(ev alert.Event) string {
+	switch {
+	case ev.Action != nil:
+		return fmt.Sprintf("Action %s: %s", ev.Action.Action, ev.Action.Result)
+	case ev.Exit != nil && ev.Exit.Cause == exits.OOMKill:
+		return "Process killed by the OOM killer"
+	case ev.Exit != nil:
+		return "Process crashed"
+	case ev.State == alert.Resolved && ev.Exited:
+		return fmt.Sprintf("Resolved after %s (build finished)", ev.Time.Sub(ev.StartedAt).Round(time.Second))
+	case ev.State == alert.Resolved:
+		return fmt.Sprintf("Resolved after %s (usage back under threshold)", ev.Time.Sub(ev.StartedAt).Round(time.Second))
+	case ev.Repeat:
+		return fmt.Sprintf("Still firing since %s", ev.StartedAt.Format(time.RFC1123))
+	default:
+		return "Firing"
+	}
+}
 
-// MarkdownText represents a text object with markdown type
-type MarkdownText struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+// facts returns the details of an alert event in display order
+func facts(ev alert.Event) []fact {
+	b := ev.Build
+	facts := []fact{
+		{Name: "Job Name", Value: b.BuildJobName},
+		{Name: "Build ID", Value: b.BuildId},
+		{Name: "Stage Name", Value: b.StageNames()},
+		{Name: "Processes", Value: fmt.Sprintf("%d", len(b.Processes))},
+		{Name: "Workspace", Value: b.WorkSpace},
+		{Name: "Status", Value: status(ev)},
+		{Name: "Rule", Value: fmt.Sprintf("%s (%s)", ev.Rule.Name, ev.Rule.Severity)},
+		{Name: "Value", Value: valueText(ev)},
+		{Name: "CPU Usage", Value: fmt.Sprintf("%.2f%% of one core (%.2f%% of machine)", b.CPU, b.CPUMachine)},
+		{Name: "Memory Usage", Value: fmt.Sprintf("%.2f%%", b.Mem)},
+	}
+	if b.Cgroup != nil {
+		facts = append(facts, fact{Name: "Cgroup", Value: cgroupUsage(b.Cgroup)})
+	}
+	if e := ev.Exit; e != nil {
+		facts = append(facts, fact{Name: "Exited Process", Value: exitedProcess(e)}, fact{Name: "Evidence", Value: e.Evidence})
+	}
+	if a := ev.Action; a != nil {
+		facts = append(facts, fact{Name: "Action", Value: describeAction(a)})
+	}
+	return append(facts, fact{Name: "Timestamp", Value: ev.Time.Format(time.RFC1123)})
 }
 
-// PlainText represents a text object with plain_text type
-type PlainText struct {
-	Type  string `json:"type"`
-	Text  string `json:"text"`
-	Emoji bool   `json:"emoji,omitempty"`
+// valueText renders the value of an alert event against its threshold. An
+// exit has the last resident memory of the process as its value and the
+// memory limit of its cgroup, if any, as its threshold.
+func valueText(ev alert.Event) string {
+	if ev.Exit != nil && ev.Threshold == 0 {
+		return alert.FormatValue(ev.Type, ev.Value) + " (no memory limit)"
+	}
+	unit := ev.Type
+	if ev.Action != nil {
+		unit = ev.Action.Alert
+	}
+	return fmt.Sprintf("%s (Threshold: %s)", alert.FormatValue(unit, ev.Value), alert.FormatValue(unit, ev.Threshold))
 }
 
-// SendSlackNotification sends a structured notification to Slack
-func SendSlackNotification(cfg *config.Config, alertType string, p *process.ProcessInfo) {
-	if cfg.Slack.WebhookURL == "" {
-		utils.Info("Slack Webhook URL is not configured. Skipping notification.")
-		return
+// describeAction summarises a remediation action and its outcome
+func describeAction(a *alert.Action) string {
+	pids := make([]string, 0, len(a.PIDs))
+	for _, pid := range a.PIDs {
+		pids = append(pids, fmt.Sprintf("%d", pid))
+	}
+	s := fmt.Sprintf("%s of PID %s on %s (%s)", a.Action, strings.Join(pids, ", "), a.Alert, a.Result)
+	if a.Detail != "" {
+		s += ": " + a.Detail
 	}
+	return s
+}
 
-	var color string
-	var title string
+// exitedProcess describes a process that exited abnormally as last sampled
+func exitedProcess(e *exits.Exit) string {
+	p := e.Process
+	stage := p.StageName
+	if stage == "" {
+		stage = "-"
+	}
+	s := fmt.Sprintf("%s (PID %d, stage %s), last seen with %s resident, %.2f%% of memory, %d threads",
+		p.Name, p.PID, stage, utils.FormatBytes(p.RSS), p.Mem, p.NumThreads)
+	if p.Cgroup != nil {
+		s += "; cgroup " + cgroupUsage(p.Cgroup)
+	}
+	return s
+}
 
-	switch alertType {
-	case "CPU_HIGH":
-		color = "#FF0000" // Red
-		title = "Jenkins Monitor Alert: High CPU Usage"
-	case "MEM_HIGH":
-		color = "#FF0000" // Red
-		title = "Jenkins Monitor Alert: High Memory Usage"
-	default:
-		color = "#CCCCCC" // Grey
-		title = "Jenkins Monitor Alert"
-	}
-
-	// Construct Blocks
-	blocks := []Block{
-		HeaderBlock{
-			Type: "header",
-			Text: PlainText{
-				Type: "plain_text",
-				Text: title,
-			},
-		},
-		DividerBlock{Type: "divider"},
-		SectionBlock{
-			Type: "section",
-			Fields: []*MarkdownText{
-				{Type: "mrkdwn", Text: fmt.Sprintf("*Job Name:*\n%s", p.BuildJobName)},
-				{Type: "mrkdwn", Text: fmt.Sprintf("*PID:*\n%d", p.PID)},
-				{Type: "mrkdwn", Text: fmt.Sprintf("*Build ID:*\n%s", p.BuildId)},
-				{Type: "mrkdwn", Text: fmt.Sprintf("*Stage Name:*\n%s", p.StageName)},
-			},
-		},
-		SectionBlock{
-			Type: "section",
-			Fields: []*MarkdownText{
-				{Type: "mrkdwn", Text: fmt.Sprintf("*Workspace:*\n%s", p.WorkSpace)},
-			},
-		},
-		SectionBlock{
-			Type: "section",
-			Fields: []*MarkdownText{
-				{Type: "mrkdwn", Text: fmt.Sprintf("*CPU Usage:*\n%.2f%% (Threshold: %.2f%%)", p.CPU, cfg.Thresholds.CPUPercent)},
-				{Type: "mrkdwn", Text: fmt.Sprintf("*Memory Usage:*\n%.2f%% (Threshold: %.2f%%)", p.Mem, cfg.Thresholds.MemPercent)},
-			},
-		},
-		ContextBlock{
-			Type: "context",
-			Elements: []MarkdownText{
-				{Type: "mrkdwn", Text: fmt.Sprintf("Timestamp: %s", time.Now().Format(time.RFC1123))},
-			},
-		},
-	}
-
-	msg := SlackMessage{
-		Channel:  cfg.Slack.Channel,
-		Username: cfg.Slack.Username,
-		Attachments: []Attachment{
-			{
-				Color:  color,
-				Blocks: blocks,
-			},
-		},
-	}
-
-	jsonBytes, err := json.MarshalIndent(msg, "", "  ")
-	if err != nil {
-		utils.Error(fmt.Sprintf("Failed to marshal Slack message: %v", err))
-		return
+// cgroupUsage describes the memory and CPU pressure of a build's cgroup
+func cgroupUsage(cg *cgroup.Stats) string {
+	memory := utils.FormatBytes(cg.MemoryUsage) + " used, no limit"
+	if cg.MemoryLimit > 0 {
+		memory = fmt.Sprintf("%s of %s (%.1f%%)", utils.FormatBytes(cg.MemoryUsage), utils.FormatBytes(cg.MemoryLimit), cg.MemoryPercent())
 	}
+	return fmt.Sprintf("%s: %s, %d OOM kills, %.1f%% of CPU periods throttled", cg.Path, memory, cg.OOMKills, cg.ThrottledPercent)
+}
 
-	req, err := http.NewRequest("POST", cfg.Slack.WebhookURL, bytes.NewBuffer(jsonBytes))
+// post sends body to url and treats any non-2xx status as an error
+func post(method, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 	if err != nil {
-		utils.Error(fmt.Sprintf("Failed to create Slack request: %v", err))
-		return
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		utils.Error(fmt.Sprintf("Failed to send Slack notification: %v", err))
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		utils.Error(fmt.Sprintf("Received non-OK response from Slack (%d): %s", resp.StatusCode, string(body)))
-	} else {
-		utils.Info(fmt.Sprintf("Slack notification sent successfully for job %s (PID %d)", p.BuildJobName, p.PID))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("received non-OK response (%d): %s", resp.StatusCode, string(respBody))
 	}
+	return nil
 }
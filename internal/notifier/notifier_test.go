@@ -0,0 +1,263 @@
+package notifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/cgroup"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/exits"
+	"jenkins-monitor/internal/process"
+)
+
+func testEvent() alert.Event {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	return alert.Event{
+		Type:      alert.CPUHigh,
+		State:     alert.Firing,
+		Build:     process.BuildInfo{BuildJobName: `app "main"`, BuildId: "42", CPU: 95},
+		Value:     95,
+		Threshold: 90,
+		StartedAt: now,
+		Time:      now,
+	}
+}
+
+// capture starts a server that records the last request body
+func capture(t *testing.T) (*httptest.Server, *[]byte) {
+	t.Helper()
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &body
+}
+
+func TestWebhookNotifierTemplate(t *testing.T) {
+	srv, body := capture(t)
+
+	n, err := NewWebhookNotifier(config.WebhookConfig{
+		URL:          srv.URL,
+		Method:       http.MethodPost,
+		BodyTemplate: `{"summary": {{json .Title}}, "dedup_key": {{json .Key}}, "job": {{json .JobName}}}`,
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier() error: %v", err)
+	}
+	if err := n.Notify(testEvent()); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(*body, &got); err != nil {
+		t.Fatalf("rendered body is not valid JSON: %v\n%s", err, *body)
+	}
+	if got["job"] != `app "main"` || got["dedup_key"] != `app "main"#42/CPU_HIGH` {
+		t.Errorf("unexpected body: %v", got)
+	}
+}
+
+func TestWebhookNotifierDefaultPayload(t *testing.T) {
+	srv, body := capture(t)
+
+	n, err := NewWebhookNotifier(config.WebhookConfig{URL: srv.URL, Method: http.MethodPost})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier() error: %v", err)
+	}
+	ev := testEvent()
+	ev.Build.Cgroup = &cgroup.Stats{Path: "/docker/abc", MemoryLimit: 4 << 30, MemoryUsage: 3 << 30}
+	if err := n.Notify(ev); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+
+	var got Payload
+	if err := json.Unmarshal(*body, &got); err != nil {
+		t.Fatalf("body is not a Payload: %v", err)
+	}
+	if got.BuildID != "42" || got.State != "firing" || got.Threshold != 90 {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+	if got.Cgroup == nil || got.Cgroup.Path != "/docker/abc" || got.Cgroup.MemoryPercent != 75 {
+		t.Errorf("unexpected cgroup: %+v", got.Cgroup)
+	}
+}
+
+func TestWebhookNotifierExitPayload(t *testing.T) {
+	srv, body := capture(t)
+
+	n, err := NewWebhookNotifier(config.WebhookConfig{URL: srv.URL, Method: http.MethodPost})
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier() error: %v", err)
+	}
+	ev := alert.ExitEvent(exits.Exit{
+		Cause: exits.OOMKill, Evidence: "Out of memory: Killed process 7 (java)", Build: testEvent().Build, Time: time.Now(),
+		Process: process.ProcessInfo{PID: 7, Name: "java", StageName: "Test", RSS: 3 << 30},
+	}, config.ThresholdsConfig{Severity: config.SeverityCritical})
+	if err := n.Notify(ev); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+
+	var got Payload
+	if err := json.Unmarshal(*body, &got); err != nil {
+		t.Fatalf("body is not a Payload: %v", err)
+	}
+	if got.Type != alert.OOMKilled || got.Key != "app \"main\"#42/OOM_KILLED/7" || got.Status != "Process killed by the OOM killer" {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+	if got.Exit == nil || got.Exit.PID != 7 || got.Exit.Stage != "Test" || got.Exit.Cause != "oom_kill" || got.Exit.RSSBytes != 3<<30 {
+		t.Errorf("unexpected exit: %+v", got.Exit)
+	}
+}
+
+func TestWebhookNotifierInvalidTemplate(t *testing.T) {
+	if _, err := NewWebhookNotifier(config.WebhookConfig{BodyTemplate: "{{.Title"}); err == nil {
+		t.Fatal("expected an error for an unterminated template")
+	}
+}
+
+func TestTeamsNotifierFormats(t *testing.T) {
+	for _, format := range []string{"message_card", "adaptive_card"} {
+		t.Run(format, func(t *testing.T) {
+			srv, body := capture(t)
+
+			n := NewTeamsNotifier(config.TeamsConfig{WebhookURL: srv.URL, Format: format})
+			if err := n.Notify(testEvent()); err != nil {
+				t.Fatalf("Notify() error: %v", err)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(*body, &got); err != nil {
+				t.Fatalf("body is not valid JSON: %v", err)
+			}
+			if format == "message_card" && got["@type"] != "MessageCard" {
+				t.Errorf("@type = %v, want MessageCard", got["@type"])
+			}
+			if format == "adaptive_card" && got["type"] != "message" {
+				t.Errorf("type = %v, want message", got["type"])
+			}
+		})
+	}
+}
+
+func TestMultiReportsFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	m := Multi{NewTeamsNotifier(config.TeamsConfig{WebhookURL: srv.URL, Format: "message_card"})}
+	if err := m.Notify(testEvent()); err == nil {
+		t.Fatal("expected an error from a failing backend")
+	}
+}
+
+func TestEmailNotifierStalledServer(t *testing.T) {
+	// The server accepts the connection and never sends its greeting
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	e := NewEmailNotifier(config.EmailConfig{Host: "127.0.0.1", Port: addr.Port, From: "monitor@example.com", To: []string{"ops@example.com"}})
+	e.timeout = 100 * time.Millisecond
+	start := time.Now()
+	if err := e.Notify(testEvent()); err == nil {
+		t.Fatal("Notify() to a stalled server returned no error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Notify() took %v, want it bounded by the timeout", elapsed)
+	}
+}
+
+func TestEmailNotifierHeaderInjection(t *testing.T) {
+	ev := testEvent()
+	ev.Build.BuildJobName = "app\r\nBcc: victim@example.com"
+	e := NewEmailNotifier(config.EmailConfig{Host: "127.0.0.1", From: "monitor@example.com", To: []string{"ops@example.com"}})
+	if _, err := e.message(ev); err == nil {
+		t.Error("message() accepted a subject with a line break")
+	}
+
+	e = NewEmailNotifier(config.EmailConfig{Host: "127.0.0.1", From: "monitor@example.com\nBcc: x@example.com", To: []string{"ops@example.com"}})
+	if _, err := e.message(testEvent()); err == nil {
+		t.Error("message() accepted a from address with a line break")
+	}
+	if _, err := NewEmailNotifier(config.EmailConfig{From: "a@example.com", To: []string{"b@example.com"}}).message(testEvent()); err != nil {
+		t.Errorf("message() error = %v", err)
+	}
+}
+
+func TestEmailNotifierDelivers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	// A minimal SMTP server without STARTTLS or AUTH
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 test\r\n")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				fmt.Fprint(conn, "250-test\r\n250 8BITMIME\r\n")
+			case cmd == "DATA":
+				fmt.Fprint(conn, "354 go ahead\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				fmt.Fprint(conn, "250 queued\r\n")
+			case cmd == "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	e := NewEmailNotifier(config.EmailConfig{Host: "127.0.0.1", Port: addr.Port, From: "monitor@example.com", To: []string{"ops@example.com"}})
+	if err := e.Notify(testEvent()); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if msg := <-received; !strings.Contains(msg, "Subject: ") || !strings.Contains(msg, `app "main" #42`) {
+		t.Errorf("message = %q, want the subject with the job and build", msg)
+	}
+}
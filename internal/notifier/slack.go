@@ -0,0 +1,249 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/host"
+	"jenkins-monitor/internal/lifecycle"
+	"jenkins-monitor/internal/utils"
+)
+
+// SlackMessage represents the structure of a Slack message
+type SlackMessage struct {
+	Channel     string       `json:"channel,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	IconEmoji   string       `json:"icon_emoji,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment represents a Slack message attachment
+type Attachment struct {
+	Color  string  `json:"color,omitempty"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+// Block represents a generic Slack block
+type Block interface {
+	isBlock()
+}
+
+// SectionBlock represents a section block
+type SectionBlock struct {
+	Type   string          `json:"type"`
+	Text   *MarkdownText   `json:"text,omitempty"`
+	Fields []*MarkdownText `json:"fields,omitempty"`
+}
+
+func (b SectionBlock) isBlock() {}
+
+// HeaderBlock represents a header block
+type HeaderBlock struct {
+	Type string    `json:"type"`
+	Text PlainText `json:"text"`
+}
+
+func (b HeaderBlock) isBlock() {}
+
+// DividerBlock represents a divider block
+type DividerBlock struct {
+	Type string `json:"type"`
+}
+
+func (b DividerBlock) isBlock() {}
+
+// ContextBlock represents a context block
+type ContextBlock struct {
+	Type     string         `json:"type"`
+	Elements []MarkdownText `json:"elements"`
+}
+
+func (b ContextBlock) isBlock() {}
+
+// MarkdownText represents a text object with markdown type
+type MarkdownText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// PlainText represents a text object with plain_text type
+type PlainText struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// SlackNotifier posts Block Kit messages to a Slack incoming webhook
+type SlackNotifier struct {
+	cfg        config.SlackConfig
+	thresholds config.ThresholdsConfig
+}
+
+// NewSlackNotifier creates a Slack backend
+func NewSlackNotifier(cfg config.SlackConfig, thresholds config.ThresholdsConfig) *SlackNotifier {
+	return &SlackNotifier{cfg: cfg, thresholds: thresholds}
+}
+
+// Name implements Notifier
+func (s *SlackNotifier) Name() string {
+	return "Slack"
+}
+
+// Notify sends a structured notification for an alert event to Slack
+func (s *SlackNotifier) Notify(ev alert.Event) error {
+	b := ev.Build
+
+	// Construct Blocks
+	blocks := []Block{
+		HeaderBlock{
+			Type: "header",
+			Text: PlainText{
+				Type: "plain_text",
+				Text: title(ev),
+			},
+		},
+		DividerBlock{Type: "divider"},
+		SectionBlock{
+			Type: "section",
+			Fields: []*MarkdownText{
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Job Name:*\n%s", b.BuildJobName)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Build ID:*\n%s", b.BuildId)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Stage Name:*\n%s", b.StageNames())},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Processes:*\n%d", len(b.Processes))},
+			},
+		},
+		SectionBlock{
+			Type: "section",
+			Fields: []*MarkdownText{
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Workspace:*\n%s", b.WorkSpace)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Status:*\n%s", status(ev))},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Rule:*\n%s (%s)", ev.Rule.Name, ev.Rule.Severity)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Value:*\n%s", valueText(ev))},
+			},
+		},
+		SectionBlock{
+			Type: "section",
+			Fields: []*MarkdownText{
+				{Type: "mrkdwn", Text: fmt.Sprintf("*CPU Usage:*\n%.2f%% of one core, %.2f%% of machine (Threshold: %.2f%% %s)", b.CPU, b.CPUMachine, ev.Rule.CPUPercent, s.thresholds.CPUMode)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Memory Usage:*\n%.2f%% (Threshold: %.2f%%)", b.Mem, ev.Rule.MemPercent)},
+			},
+		},
+	}
+	if b.Cgroup != nil {
+		blocks = append(blocks, SectionBlock{
+			Type: "section",
+			Text: &MarkdownText{Type: "mrkdwn", Text: "*Cgroup:* " + cgroupUsage(b.Cgroup)},
+		})
+	}
+	if e := ev.Exit; e != nil {
+		blocks = append(blocks, SectionBlock{
+			Type: "section",
+			Text: &MarkdownText{Type: "mrkdwn", Text: fmt.Sprintf("*Exited process:* %s\n*Evidence:* `%s`", exitedProcess(e), e.Evidence)},
+		})
+	}
+	if a := ev.Action; a != nil {
+		blocks = append(blocks, SectionBlock{
+			Type: "section",
+			Text: &MarkdownText{Type: "mrkdwn", Text: "*Action:* " + describeAction(a)},
+		})
+	}
+	if ev.Host != nil {
+		blocks = append(blocks, SectionBlock{
+			Type: "section",
+			Text: &MarkdownText{Type: "mrkdwn", Text: hostPressure(ev.Host)},
+		})
+	}
+	blocks = append(blocks, ContextBlock{
+		Type: "context",
+		Elements: []MarkdownText{
+			{Type: "mrkdwn", Text: fmt.Sprintf("Timestamp: %s", ev.Time.Format(time.RFC1123))},
+		},
+	})
+
+	msg := SlackMessage{
+		Channel:  s.cfg.Channel,
+		Username: s.cfg.Username,
+		Attachments: []Attachment{
+			{
+				Color:  "#" + color(ev),
+				Blocks: blocks,
+			},
+		},
+	}
+
+	jsonBytes, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal Slack message: %w", err)
+	}
+
+	return post(http.MethodPost, s.cfg.WebhookURL, jsonBytes, nil)
+}
+
+// hostPressure describes the agent state so on-call can tell a noisy job from
+// an overloaded agent
+func hostPressure(h *host.Snapshot) string {
+	verdict := "Agent has headroom"
+	if h.Overloaded() {
+		verdict = ":warning: Agent is under pressure"
+	}
+	return fmt.Sprintf("*Host:* %s\n%s", verdict, h.Pressure())
+}
+
+// NotifySummary posts the resource summary of a finished build to Slack
+func (s *SlackNotifier) NotifySummary(sum lifecycle.Summary) error {
+	stages := "-"
+	if len(sum.Stages) > 0 {
+		stages = strings.Join(sum.Stages, ", ")
+	}
+
+	blocks := []Block{
+		HeaderBlock{
+			Type: "header",
+			Text: PlainText{
+				Type: "plain_text",
+				Text: fmt.Sprintf("Jenkins Build Finished: %s #%s", sum.JobName, sum.BuildID),
+			},
+		},
+		DividerBlock{Type: "divider"},
+		SectionBlock{
+			Type: "section",
+			Fields: []*MarkdownText{
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Duration:*\n%s", time.Duration(sum.Duration*float64(time.Second)).Round(time.Second))},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*CPU Time:*\n%.1fs", sum.CPUSeconds)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*CPU Usage:*\npeak %.2f%%, avg %.2f%%", sum.PeakCPU, sum.AvgCPU)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Memory Usage:*\npeak %.2f%% (%s), avg %.2f%%", sum.PeakMem, utils.FormatBytes(sum.PeakRSS), sum.AvgMem)},
+			},
+		},
+		SectionBlock{
+			Type: "section",
+			Fields: []*MarkdownText{
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Stages:*\n%s", stages)},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Processes:*\nup to %d", sum.MaxProcesses)},
+			},
+		},
+		ContextBlock{
+			Type: "context",
+			Elements: []MarkdownText{
+				{Type: "mrkdwn", Text: fmt.Sprintf("Started: %s, ended: %s", sum.StartedAt.Format(time.RFC1123), sum.EndedAt.Format(time.RFC1123))},
+			},
+		},
+	}
+
+	msg := SlackMessage{
+		Channel:     s.cfg.Channel,
+		Username:    s.cfg.Username,
+		Attachments: []Attachment{{Color: "#439FE0", Blocks: blocks}},
+	}
+
+	jsonBytes, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal Slack message: %w", err)
+	}
+
+	return post(http.MethodPost, s.cfg.WebhookURL, jsonBytes, nil)
+}
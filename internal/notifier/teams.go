@@ -0,0 +1,109 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+)
+
+// TeamsNotifier posts alert events to a Microsoft Teams incoming webhook,
+// either as a legacy MessageCard or as an Adaptive Card
+type TeamsNotifier struct {
+	cfg config.TeamsConfig
+}
+
+// NewTeamsNotifier creates a Teams backend
+func NewTeamsNotifier(cfg config.TeamsConfig) *TeamsNotifier {
+	return &TeamsNotifier{cfg: cfg}
+}
+
+// Name implements Notifier
+func (t *TeamsNotifier) Name() string {
+	if t.cfg.Name != "" {
+		return "Teams " + t.cfg.Name
+	}
+	return "Teams"
+}
+
+// Notify implements Notifier
+func (t *TeamsNotifier) Notify(ev alert.Event) error {
+	var card interface{}
+	if t.cfg.Format == "adaptive_card" {
+		card = adaptiveCard(ev)
+	} else {
+		card = messageCard(ev)
+	}
+
+	body, err := json.Marshal(card)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Teams card: %w", err)
+	}
+	return post(http.MethodPost, t.cfg.WebhookURL, body, nil)
+}
+
+// messageCard builds a legacy Office 365 connector card
+func messageCard(ev alert.Event) map[string]interface{} {
+	var cardFacts []map[string]string
+	for _, f := range facts(ev) {
+		cardFacts = append(cardFacts, map[string]string{"name": f.Name, "value": f.Value})
+	}
+
+	return map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"themeColor": color(ev),
+		"summary":    title(ev),
+		"sections": []map[string]interface{}{
+			{
+				"activityTitle": title(ev),
+				"facts":         cardFacts,
+				"markdown":      true,
+			},
+		},
+	}
+}
+
+// adaptiveCard builds a message wrapping an Adaptive Card, as accepted by
+// Teams workflow webhooks
+func adaptiveCard(ev alert.Event) map[string]interface{} {
+	var cardFacts []map[string]string
+	for _, f := range facts(ev) {
+		cardFacts = append(cardFacts, map[string]string{"title": f.Name, "value": f.Value})
+	}
+
+	titleColor := "Attention"
+	if ev.State == alert.Resolved {
+		titleColor = "Good"
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body": []map[string]interface{}{
+						{
+							"type":   "TextBlock",
+							"text":   title(ev),
+							"weight": "Bolder",
+							"size":   "Medium",
+							"color":  titleColor,
+							"wrap":   true,
+						},
+						{
+							"type":  "FactSet",
+							"facts": cardFacts,
+						},
+					},
+				},
+			},
+		},
+	}
+}
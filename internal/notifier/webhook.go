@@ -0,0 +1,186 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+)
+
+// Payload is the default JSON body of a webhook notification and the data
+// passed to a webhook body_template
+type Payload struct {
+	Key            string         `json:"key"`
+	Title          string         `json:"title"`
+	Type           string         `json:"type"`
+	Rule           string         `json:"rule"`
+	Severity       string         `json:"severity"`
+	State          string         `json:"state"`
+	Status         string         `json:"status"`
+	Repeat         bool           `json:"repeat"`
+	JobName        string         `json:"job_name"`
+	BuildID        string         `json:"build_id"`
+	Stages         []string       `json:"stages"`
+	Workspace      string         `json:"workspace"`
+	Processes      int            `json:"processes"`
+	Value          float64        `json:"value"`
+	Threshold      float64        `json:"threshold"`
+	CPUPercent     float64        `json:"cpu_percent"`
+	CPUMachine     float64        `json:"cpu_machine_percent"`
+	MemPercent     float64        `json:"mem_percent"`
+	RSSBytes       uint64         `json:"rss_bytes"`
+	Threads        int32          `json:"threads"`
+	OpenFDs        int32          `json:"open_fds"`
+	FDLimitPercent float64        `json:"fd_limit_percent"`
+	WorkspaceBytes uint64         `json:"workspace_bytes"`
+	Cgroup         *CgroupPayload `json:"cgroup,omitempty"`
+	Exit           *ExitPayload   `json:"exit,omitempty"`
+	Action         *alert.Action  `json:"action,omitempty"`
+	StartedAt      time.Time      `json:"started_at"`
+	Timestamp      time.Time      `json:"timestamp"`
+}
+
+// CgroupPayload is the cgroup accounting carried by a Payload
+type CgroupPayload struct {
+	Path             string  `json:"path"`
+	MemoryLimitBytes uint64  `json:"memory_limit_bytes"`
+	MemoryUsageBytes uint64  `json:"memory_usage_bytes"`
+	MemoryPercent    float64 `json:"memory_percent"`
+	OOMKills         uint64  `json:"oom_kills"`
+	ThrottledPercent float64 `json:"throttled_percent"`
+}
+
+// ExitPayload is the process an OOM_KILLED or PROCESS_CRASHED alert reports,
+// as last sampled before it exited
+type ExitPayload struct {
+	PID        int32   `json:"pid"`
+	Command    string  `json:"command"`
+	Stage      string  `json:"stage"`
+	Cause      string  `json:"cause"`
+	Evidence   string  `json:"evidence"`
+	RSSBytes   uint64  `json:"rss_bytes"`
+	MemPercent float64 `json:"mem_percent"`
+}
+
+// NewPayload flattens an alert event into a Payload
+func NewPayload(ev alert.Event) Payload {
+	b := ev.Build
+	p := Payload{
+		Key:            ev.Key(),
+		Title:          title(ev),
+		Type:           ev.Type,
+		Rule:           ev.Rule.Name,
+		Severity:       ev.Rule.Severity,
+		State:          string(ev.State),
+		Status:         status(ev),
+		Repeat:         ev.Repeat,
+		JobName:        b.BuildJobName,
+		BuildID:        b.BuildId,
+		Stages:         b.Stages,
+		Workspace:      b.WorkSpace,
+		Processes:      len(b.Processes),
+		Value:          ev.Value,
+		Threshold:      ev.Threshold,
+		CPUPercent:     b.CPU,
+		CPUMachine:     b.CPUMachine,
+		MemPercent:     float64(b.Mem),
+		RSSBytes:       b.RSS,
+		Threads:        b.NumThreads,
+		OpenFDs:        b.NumFDs,
+		FDLimitPercent: b.FDPercent,
+		WorkspaceBytes: b.WorkspaceBytes,
+		Action:         ev.Action,
+		StartedAt:      ev.StartedAt,
+		Timestamp:      ev.Time,
+	}
+	if cg := b.Cgroup; cg != nil {
+		p.Cgroup = &CgroupPayload{
+			Path:             cg.Path,
+			MemoryLimitBytes: cg.MemoryLimit,
+			MemoryUsageBytes: cg.MemoryUsage,
+			MemoryPercent:    cg.MemoryPercent(),
+			OOMKills:         cg.OOMKills,
+			ThrottledPercent: cg.ThrottledPercent,
+		}
+	}
+	if e := ev.Exit; e != nil {
+		p.Exit = &ExitPayload{
+			PID:        e.Process.PID,
+			Command:    e.Process.Name,
+			Stage:      e.Process.StageName,
+			Cause:      string(e.Cause),
+			Evidence:   e.Evidence,
+			RSSBytes:   e.Process.RSS,
+			MemPercent: float64(e.Process.Mem),
+		}
+	}
+	return p
+}
+
+// templateFuncs are available inside webhook body templates. `json` renders
+// any value as a JSON literal so strings are quoted and escaped correctly.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// WebhookNotifier sends alert events to an arbitrary HTTP endpoint, such as
+// PagerDuty or Opsgenie, with an optionally templated JSON body
+type WebhookNotifier struct {
+	cfg  config.WebhookConfig
+	tmpl *template.Template
+}
+
+// NewWebhookNotifier creates a webhook backend, parsing its body template if set
+func NewWebhookNotifier(cfg config.WebhookConfig) (*WebhookNotifier, error) {
+	n := &WebhookNotifier{cfg: cfg}
+	if cfg.BodyTemplate != "" {
+		tmpl, err := template.New(n.Name()).Funcs(templateFuncs).Parse(cfg.BodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_template for webhook %s: %w", n.Name(), err)
+		}
+		n.tmpl = tmpl
+	}
+	return n, nil
+}
+
+// Name implements Notifier
+func (w *WebhookNotifier) Name() string {
+	if w.cfg.Name != "" {
+		return "Webhook " + w.cfg.Name
+	}
+	return "Webhook"
+}
+
+// Notify implements Notifier
+func (w *WebhookNotifier) Notify(ev alert.Event) error {
+	body, err := w.render(ev)
+	if err != nil {
+		return err
+	}
+	return post(w.cfg.Method, w.cfg.URL, body, w.cfg.Headers)
+}
+
+// render produces the request body for ev
+func (w *WebhookNotifier) render(ev alert.Event) ([]byte, error) {
+	payload := NewPayload(ev)
+	if w.tmpl == nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal webhook payload: %w", err)
+		}
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	if err := w.tmpl.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("failed to render webhook body_template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,236 @@
+package process
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"jenkins-monitor/internal/cgroup"
+)
+
+// BuildProcess is a single process within a build's process tree
+type BuildProcess struct {
+	ProcessInfo
+	Depth int // 0 for processes whose parent is not part of the build
+}
+
+// BuildInfo holds the aggregated resource usage of every process belonging
+// to one Jenkins build, identified by (JOB_NAME, BUILD_ID)
+type BuildInfo struct {
+	BuildJobName string
+	BuildId      string
+	WorkSpace    string
+	NodeName     string
+	NodeLabels   []string
+	StartTime    time.Time // creation time of the oldest process in the build
+	Stages       []string
+	CPU          float64
+	CPUMachine   float64
+	Mem          float32
+	RSS          uint64
+	NumThreads   int32
+	NumFDs       int32
+	ReadBytes    uint64         // bytes read by the running processes of the build
+	WriteBytes   uint64         // bytes written by the running processes of the build
+	Processes    []BuildProcess // per-PID detail in parent-before-child order
+	StageUsage   []StageUsage   // usage per pipeline stage, sorted by stage name
+
+	// FDPercent is the highest share of its open file limit used by any
+	// process of the build, since descriptor limits apply per process
+	FDPercent float64
+	// Context switches of the running processes of the build since they started
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+	// Cgroup is the most constrained cgroup the build's processes run in:
+	// the one closest to its memory limit, or the most throttled when none
+	// has a limit. Nil when no cgroup was read.
+	Cgroup *cgroup.Stats
+
+	// Workspace size at its last measurement and its growth in bytes per
+	// second, set by the monitor; WorkspaceMeasured is false until then
+	WorkspaceBytes    uint64
+	WorkspaceGrowth   float64
+	WorkspaceMeasured bool
+}
+
+// StageUsage is the resource usage of the processes of one pipeline stage
+// within a build. Processes outside any stage are grouped under an empty name.
+type StageUsage struct {
+	Name      string
+	CPU       float64
+	Mem       float32
+	RSS       uint64
+	Processes int
+}
+
+// Key uniquely identifies a build across samples
+func (b *BuildInfo) Key() string {
+	return BuildKey(b.BuildJobName, b.BuildId)
+}
+
+// AgentLabels returns the agent name and labels used to match threshold rules
+func (b *BuildInfo) AgentLabels() []string {
+	if b.NodeName == "" {
+		return b.NodeLabels
+	}
+	return append([]string{b.NodeName}, b.NodeLabels...)
+}
+
+// StageNames returns the stages seen in the build as a single display string
+func (b *BuildInfo) StageNames() string {
+	return strings.Join(b.Stages, ", ")
+}
+
+// BuildKey returns the identifier used for a (JOB_NAME, BUILD_ID) pair
+func BuildKey(jobName, buildID string) string {
+	return jobName + "#" + buildID
+}
+
+// AggregateBuilds groups processes by (JOB_NAME, BUILD_ID), sums their resource
+// usage and orders each build's processes along the parent/child tree.
+// Builds are returned sorted by job name and build ID.
+func AggregateBuilds(processes []ProcessInfo) []BuildInfo {
+	grouped := make(map[string][]ProcessInfo)
+	var keys []string
+	for _, p := range processes {
+		key := BuildKey(p.BuildJobName, p.BuildId)
+		if _, ok := grouped[key]; !ok {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], p)
+	}
+
+	builds := make([]BuildInfo, 0, len(keys))
+	for _, key := range keys {
+		builds = append(builds, newBuildInfo(grouped[key]))
+	}
+
+	sort.Slice(builds, func(i, j int) bool {
+		if builds[i].BuildJobName != builds[j].BuildJobName {
+			return builds[i].BuildJobName < builds[j].BuildJobName
+		}
+		return builds[i].BuildId < builds[j].BuildId
+	})
+
+	return builds
+}
+
+// newBuildInfo aggregates the processes of a single build
+func newBuildInfo(procs []ProcessInfo) BuildInfo {
+	b := BuildInfo{
+		BuildJobName: procs[0].BuildJobName,
+		BuildId:      procs[0].BuildId,
+	}
+
+	stages := make(map[string]bool)
+	usage := make(map[string]*StageUsage)
+	for _, p := range procs {
+		u, ok := usage[p.StageName]
+		if !ok {
+			u = &StageUsage{Name: p.StageName}
+			usage[p.StageName] = u
+		}
+		u.CPU += p.CPU
+		u.Mem += p.Mem
+		u.RSS += p.RSS
+		u.Processes++
+
+		b.CPU += p.CPU
+		b.CPUMachine += p.CPUMachine
+		b.Mem += p.Mem
+		b.RSS += p.RSS
+		b.NumThreads += p.NumThreads
+		b.NumFDs += p.NumFDs
+		b.ReadBytes += p.ReadBytes
+		b.WriteBytes += p.WriteBytes
+		b.VoluntaryCtxSwitches += p.VoluntaryCtxSwitches
+		b.InvoluntaryCtxSwitches += p.InvoluntaryCtxSwitches
+		b.FDPercent = max(b.FDPercent, p.FDPercent())
+		if p.Cgroup != nil && (b.Cgroup == nil || moreConstrained(*p.Cgroup, *b.Cgroup)) {
+			b.Cgroup = p.Cgroup
+		}
+		if b.WorkSpace == "" {
+			b.WorkSpace = p.WorkSpace
+		}
+		if b.NodeName == "" {
+			b.NodeName = p.NodeName
+			b.NodeLabels = p.NodeLabels
+		}
+		if !p.StartTime.IsZero() && (b.StartTime.IsZero() || p.StartTime.Before(b.StartTime)) {
+			b.StartTime = p.StartTime
+		}
+		if p.StageName != "" && !stages[p.StageName] {
+			stages[p.StageName] = true
+			b.Stages = append(b.Stages, p.StageName)
+		}
+	}
+	sort.Strings(b.Stages)
+	for _, u := range usage {
+		b.StageUsage = append(b.StageUsage, *u)
+	}
+	sort.Slice(b.StageUsage, func(i, j int) bool { return b.StageUsage[i].Name < b.StageUsage[j].Name })
+
+	b.Processes = processTree(procs)
+	return b
+}
+
+// moreConstrained reports whether cgroup a is closer to its memory limit than
+// b, or more throttled when neither has a limit
+func moreConstrained(a, b cgroup.Stats) bool {
+	if a.MemoryPercent() != b.MemoryPercent() {
+		return a.MemoryPercent() > b.MemoryPercent()
+	}
+	return a.ThrottledPercent > b.ThrottledPercent
+}
+
+// processTree orders procs depth-first so every process follows its parent
+func processTree(procs []ProcessInfo) []BuildProcess {
+	inBuild := make(map[int32]bool, len(procs))
+	for _, p := range procs {
+		inBuild[p.PID] = true
+	}
+
+	children := make(map[int32][]ProcessInfo)
+	var roots []ProcessInfo
+	for _, p := range procs {
+		if p.PPID != p.PID && inBuild[p.PPID] {
+			children[p.PPID] = append(children[p.PPID], p)
+		} else {
+			roots = append(roots, p)
+		}
+	}
+
+	byPID := func(list []ProcessInfo) {
+		sort.Slice(list, func(i, j int) bool { return list[i].PID < list[j].PID })
+	}
+	byPID(roots)
+
+	tree := make([]BuildProcess, 0, len(procs))
+	visited := make(map[int32]bool, len(procs))
+	var walk func(p ProcessInfo, depth int)
+	walk = func(p ProcessInfo, depth int) {
+		if visited[p.PID] {
+			return
+		}
+		visited[p.PID] = true
+		tree = append(tree, BuildProcess{ProcessInfo: p, Depth: depth})
+		kids := children[p.PID]
+		byPID(kids)
+		for _, c := range kids {
+			walk(c, depth+1)
+		}
+	}
+	for _, r := range roots {
+		walk(r, 0)
+	}
+
+	// A parent cycle (PID reuse mid-scan) leaves processes unreachable from
+	// any root; keep them rather than silently dropping their usage.
+	for _, p := range procs {
+		if !visited[p.PID] {
+			walk(p, 0)
+		}
+	}
+
+	return tree
+}
@@ -0,0 +1,77 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+
+	"jenkins-monitor/internal/cgroup"
+)
+
+func TestAggregateBuilds(t *testing.T) {
+	processes := []ProcessInfo{
+		{PID: 12, PPID: 10, BuildJobName: "app", BuildId: "41", StageName: "test", CPU: 30, Mem: 2, RSS: 300, NumThreads: 3, NumFDs: 30, FDLimit: 40, VoluntaryCtxSwitches: 9},
+		{PID: 10, PPID: 1, BuildJobName: "app", BuildId: "41", StageName: "build", CPU: 10, Mem: 1, RSS: 100, NumThreads: 1, NumFDs: 10, FDLimit: 1024, VoluntaryCtxSwitches: 1, InvoluntaryCtxSwitches: 2},
+		{PID: 11, PPID: 10, BuildJobName: "app", BuildId: "41", StageName: "build", CPU: 20, Mem: 1, RSS: 200, NumThreads: 2, NumFDs: 20},
+		{PID: 13, PPID: 11, BuildJobName: "app", BuildId: "41", StageName: "build", CPU: 5, Mem: 1, RSS: 50, NumThreads: 1, NumFDs: 5},
+		{PID: 20, PPID: 1, BuildJobName: "app", BuildId: "42", CPU: 1, Mem: 1},
+	}
+
+	builds := AggregateBuilds(processes)
+	if len(builds) != 2 {
+		t.Fatalf("AggregateBuilds() returned %d builds, want 2", len(builds))
+	}
+
+	b := builds[0]
+	if b.Key() != "app#41" {
+		t.Fatalf("first build = %s, want app#41", b.Key())
+	}
+	if b.CPU != 65 || b.Mem != 5 || b.RSS != 650 || b.NumThreads != 7 || b.NumFDs != 65 {
+		t.Errorf("unexpected totals: %+v", b)
+	}
+	if b.FDPercent != 75 {
+		t.Errorf("FDPercent = %v, want 75 (the fullest process, not the build total)", b.FDPercent)
+	}
+	if b.VoluntaryCtxSwitches != 10 || b.InvoluntaryCtxSwitches != 2 {
+		t.Errorf("context switches = %d/%d, want 10/2", b.VoluntaryCtxSwitches, b.InvoluntaryCtxSwitches)
+	}
+	if !reflect.DeepEqual(b.Stages, []string{"build", "test"}) {
+		t.Errorf("Stages = %v, want [build test]", b.Stages)
+	}
+	wantUsage := []StageUsage{
+		{Name: "build", CPU: 35, Mem: 3, RSS: 350, Processes: 3},
+		{Name: "test", CPU: 30, Mem: 2, RSS: 300, Processes: 1},
+	}
+	if !reflect.DeepEqual(b.StageUsage, wantUsage) {
+		t.Errorf("StageUsage = %+v, want %+v", b.StageUsage, wantUsage)
+	}
+
+	var order []int32
+	var depths []int
+	for _, p := range b.Processes {
+		order = append(order, p.PID)
+		depths = append(depths, p.Depth)
+	}
+	if !reflect.DeepEqual(order, []int32{10, 11, 13, 12}) {
+		t.Errorf("process order = %v, want [10 11 13 12]", order)
+	}
+	if !reflect.DeepEqual(depths, []int{0, 1, 2, 1}) {
+		t.Errorf("process depths = %v, want [0 1 2 1]", depths)
+	}
+
+	if builds[1].Key() != "app#42" || len(builds[1].Processes) != 1 {
+		t.Errorf("unexpected second build: %+v", builds[1])
+	}
+}
+
+func TestAggregateBuildsPicksMostConstrainedCgroup(t *testing.T) {
+	agent := &cgroup.Stats{Path: "/jenkins.service", MemoryLimit: 8 << 30, MemoryUsage: 2 << 30}
+	container := &cgroup.Stats{Path: "/docker/abc", MemoryLimit: 4 << 30, MemoryUsage: 3 << 30}
+	builds := AggregateBuilds([]ProcessInfo{
+		{PID: 10, BuildJobName: "app", BuildId: "1", Cgroup: agent},
+		{PID: 11, BuildJobName: "app", BuildId: "1", Cgroup: container},
+		{PID: 12, BuildJobName: "app", BuildId: "1"},
+	})
+	if got := builds[0].Cgroup; got != container {
+		t.Errorf("Cgroup = %+v, want the container cgroup at 75%% of its limit", got)
+	}
+}
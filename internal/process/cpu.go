@@ -0,0 +1,50 @@
+package process
+
+import (
+	"time"
+)
+
+// cpuSnapshot is the cumulative CPU time of a process at a point in time
+type cpuSnapshot struct {
+	createTime int64 // distinguishes a reused PID from the process we saw before
+	seconds    float64
+	at         time.Time
+}
+
+// cpuPercent returns the CPU usage of p as a percentage of one core since the
+// previous snapshot of the same process, or since the process started when
+// there is no usable snapshot
+func (c *Collector) cpuPercent(p processProvider, createTime int64, now time.Time) (float64, error) {
+	times, err := p.Times()
+	if err != nil {
+		return 0, err
+	}
+
+	seconds := times.User + times.System
+	current := cpuSnapshot{createTime: createTime, seconds: seconds, at: now}
+	prev, ok := c.prev[p.Pid()]
+	c.prev[p.Pid()] = current
+
+	var usedSeconds, elapsed float64
+	if ok && prev.createTime == createTime && now.After(prev.at) {
+		usedSeconds = seconds - prev.seconds
+		elapsed = now.Sub(prev.at).Seconds()
+	} else {
+		usedSeconds = seconds
+		elapsed = now.Sub(time.UnixMilli(createTime)).Seconds()
+	}
+
+	if elapsed <= 0 || usedSeconds <= 0 {
+		return 0, nil
+	}
+	return usedSeconds / elapsed * 100, nil
+}
+
+// prune drops the snapshots of processes that were not seen in the last pass
+func (c *Collector) prune(seen map[int32]bool) {
+	for pid := range c.prev {
+		if !seen[pid] {
+			delete(c.prev, pid)
+		}
+	}
+}
@@ -1,29 +1,74 @@
 package process
 
 import (
+	"math"
+	"runtime"
 	"strings"
+	"time"
 
+	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/process"
+
+	"jenkins-monitor/internal/cgroup"
 )
 
+// maxAncestorDepth bounds the parent walk used to attach untagged children to a build
+const maxAncestorDepth = 64
+
 // ProcessInfo holds information about a Jenkins process
 type ProcessInfo struct {
 	Timestamp    string
 	PID          int32
+	PPID         int32
+	Name         string // command name
 	BuildJobName string
 	BuildId      string
 	StageName    string
 	WorkSpace    string
-	CPU          float64
+	NodeName     string
+	NodeLabels   []string
+	StartTime    time.Time
+	CPU          float64 // percent of one core over the last sampling interval
+	CPUMachine   float64 // percent of the whole machine over the last sampling interval
 	Mem          float32
+	RSS          uint64
+	NumThreads   int32
+	NumFDs       int32
+	FDLimit      uint64 // soft RLIMIT_NOFILE; 0 when unknown or unlimited
+	ReadBytes    uint64 // bytes read from storage since the process started
+	WriteBytes   uint64 // bytes written to storage since the process started
+	// Context switches since the process started
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+	// Cgroup is the accounting of the process's cgroup; nil when cgroups are
+	// not read or the process's cgroup could not be found
+	Cgroup *cgroup.Stats
+}
+
+// FDPercent returns the open file descriptors as a percentage of the limit,
+// or 0 when the limit is unknown
+func (p *ProcessInfo) FDPercent() float64 {
+	if p.FDLimit == 0 {
+		return 0
+	}
+	return float64(p.NumFDs) / float64(p.FDLimit) * 100
 }
 
 // processProvider defines what methods we need from gopsutil.Process.
 // This makes it mockable for testing.
 type processProvider interface {
 	Environ() ([]string, error)
-	CPUPercent() (float64, error)
+	Times() (*cpu.TimesStat, error)
+	CreateTime() (int64, error)
 	MemoryPercent() (float32, error)
+	MemoryInfo() (*process.MemoryInfoStat, error)
+	NumThreads() (int32, error)
+	NumFDs() (int32, error)
+	Rlimit() ([]process.RlimitStat, error)
+	NumCtxSwitches() (*process.NumCtxSwitchesStat, error)
+	IOCounters() (*process.IOCountersStat, error)
+	Ppid() (int32, error)
+	Name() (string, error)
 	Pid() int32
 }
 
@@ -36,54 +81,196 @@ func (rp *realProcess) Pid() int32 {
 	return rp.Process.Pid
 }
 
+// jobLabels holds the Jenkins build identity read from a process environment
+type jobLabels struct {
+	BuildJobName string
+	BuildId      string
+	StageName    string
+	WorkSpace    string
+	NodeName     string
+	NodeLabels   []string
+}
+
+// Collector samples Jenkins processes and remembers each process's CPU time
+// between calls, so CPU usage reflects the last interval rather than the
+// lifetime average reported by the kernel counters.
+type Collector struct {
+	prev    map[int32]cpuSnapshot
+	numCPU  int
+	now     func() time.Time
+	scanned int
+	cgroups *cgroup.Sampler
+}
+
+// NewCollector creates a Collector with no CPU history
+func NewCollector() *Collector {
+	return &Collector{
+		prev:   make(map[int32]cpuSnapshot),
+		numCPU: runtime.NumCPU(),
+		now:    time.Now,
+	}
+}
+
+// UseCgroups makes the Collector attach the accounting of each process's
+// cgroup, read with s
+func (c *Collector) UseCgroups(s *cgroup.Sampler) {
+	c.cgroups = s
+}
+
+// GetJenkinsProcesses takes a single snapshot of the Jenkins processes. With no
+// previous sample to compare against, CPU is averaged over each process's lifetime;
+// use a Collector to measure CPU over an interval.
 func GetJenkinsProcesses() ([]ProcessInfo, error) {
+	return NewCollector().Collect()
+}
+
+// Collect scans all processes and returns the Jenkins ones, with CPU measured
+// since the previous call to Collect
+func (c *Collector) Collect() ([]ProcessInfo, error) {
 	procs, err := process.Processes()
 	if err != nil {
 		return nil, err
 	}
 
-	var jenkinsProcesses []ProcessInfo
+	providers := make([]processProvider, 0, len(procs))
+	for _, p := range procs {
+		providers = append(providers, &realProcess{p})
+	}
+
+	return c.collect(providers), nil
+}
+
+// collect returns every process that carries JOB_NAME, plus any
+// descendant of such a process whose environment was scrubbed or cannot be
+// read, labelled with the build identity of its nearest Jenkins ancestor.
+func (c *Collector) collect(procs []processProvider) []ProcessInfo {
+	now := c.now()
+	seen := make(map[int32]bool)
+
+	parents := make(map[int32]int32, len(procs))
+	labels := make(map[int32]jobLabels)
+	var untagged []processProvider
+
 	for _, p := range procs {
-		rp := &realProcess{p}
+		if ppid, err := p.Ppid(); err == nil {
+			parents[p.Pid()] = ppid
+		}
 
-		environ, err := rp.Environ()
+		// An environment that cannot be read (setuid tools, processes exiting
+		// meanwhile) carries no labels, but the build may still be inherited
+		environ, err := p.Environ()
 		if err != nil {
+			untagged = append(untagged, p)
 			continue
 		}
 
-		info := extractJenkinsInfo(rp, environ)
-		if info != nil {
+		l := parseJobLabels(environ)
+		if l.BuildJobName == "" {
+			untagged = append(untagged, p)
+			continue
+		}
+		labels[p.Pid()] = l
+	}
+
+	var jenkinsProcesses []ProcessInfo
+	for _, p := range procs {
+		l, ok := labels[p.Pid()]
+		if !ok {
+			continue
+		}
+		if info := c.sampleProcess(p, l, now); info != nil {
+			jenkinsProcesses = append(jenkinsProcesses, *info)
+			seen[info.PID] = true
+		}
+	}
+
+	for _, p := range untagged {
+		l, ok := inheritedLabels(p.Pid(), parents, labels)
+		if !ok {
+			continue
+		}
+		if info := c.sampleProcess(p, l, now); info != nil {
 			jenkinsProcesses = append(jenkinsProcesses, *info)
+			seen[info.PID] = true
 		}
 	}
 
-	return jenkinsProcesses, nil
+	c.prune(seen)
+	if c.cgroups != nil {
+		c.cgroups.Finish()
+	}
+	c.scanned = len(procs)
+	return jenkinsProcesses
+}
+
+// Scanned returns the number of processes examined by the last Collect,
+// Jenkins or not
+func (c *Collector) Scanned() int {
+	return c.scanned
 }
 
-// extractJenkinsInfo parses environment variables for Jenkins process info
-func extractJenkinsInfo(p processProvider, environ []string) *ProcessInfo {
-	var buildJobName, buildID, stageName, workSpace string
+// inheritedLabels walks up the parent chain of pid until it finds a process
+// with Jenkins job labels
+func inheritedLabels(pid int32, parents map[int32]int32, labels map[int32]jobLabels) (jobLabels, bool) {
+	current := pid
+	for i := 0; i < maxAncestorDepth; i++ {
+		ppid, ok := parents[current]
+		if !ok || ppid <= 0 || ppid == current {
+			return jobLabels{}, false
+		}
+		if l, ok := labels[ppid]; ok {
+			return l, true
+		}
+		current = ppid
+	}
+	return jobLabels{}, false
+}
+
+// parseJobLabels extracts the Jenkins build variables from an environment
+func parseJobLabels(environ []string) jobLabels {
+	var l jobLabels
 
 	for _, env := range environ {
 		if strings.HasPrefix(env, "JOB_NAME=") {
-			buildJobName = strings.TrimPrefix(env, "JOB_NAME=")
+			l.BuildJobName = strings.TrimPrefix(env, "JOB_NAME=")
 		}
 		if strings.HasPrefix(env, "BUILD_ID=") {
-			buildID = strings.TrimPrefix(env, "BUILD_ID=")
+			l.BuildId = strings.TrimPrefix(env, "BUILD_ID=")
 		}
 		if strings.HasPrefix(env, "STAGE_NAME=") {
-			stageName = strings.TrimPrefix(env, "STAGE_NAME=")
+			l.StageName = strings.TrimPrefix(env, "STAGE_NAME=")
 		}
 		if strings.HasPrefix(env, "WORKSPACE=") {
-			workSpace = strings.TrimPrefix(env, "WORKSPACE=")
+			l.WorkSpace = strings.TrimPrefix(env, "WORKSPACE=")
+		}
+		if strings.HasPrefix(env, "NODE_NAME=") {
+			l.NodeName = strings.TrimPrefix(env, "NODE_NAME=")
+		}
+		if strings.HasPrefix(env, "NODE_LABELS=") {
+			l.NodeLabels = strings.Fields(strings.TrimPrefix(env, "NODE_LABELS="))
 		}
 	}
 
-	if buildJobName == "" {
+	return l
+}
+
+// extractJenkinsInfo parses environment variables for Jenkins process info
+func (c *Collector) extractJenkinsInfo(p processProvider, environ []string) *ProcessInfo {
+	l := parseJobLabels(environ)
+	if l.BuildJobName == "" {
 		return nil
 	}
 
-	cpu, err := p.CPUPercent()
+	return c.sampleProcess(p, l, c.now())
+}
+
+// sampleProcess reads the resource usage of p and labels it with the given build identity
+func (c *Collector) sampleProcess(p processProvider, l jobLabels, now time.Time) *ProcessInfo {
+	createTime, err := p.CreateTime()
+	if err != nil {
+		return nil
+	}
+	cpuPercent, err := c.cpuPercent(p, createTime, now)
 	if err != nil {
 		return nil
 	}
@@ -92,13 +279,64 @@ func extractJenkinsInfo(p processProvider, environ []string) *ProcessInfo {
 		return nil
 	}
 
-	return &ProcessInfo{
+	info := &ProcessInfo{
 		PID:          p.Pid(),
-		BuildJobName: buildJobName,
-		BuildId:      buildID,
-		StageName:    stageName,
-		WorkSpace:    workSpace,
-		CPU:          cpu,
+		BuildJobName: l.BuildJobName,
+		BuildId:      l.BuildId,
+		StageName:    l.StageName,
+		WorkSpace:    l.WorkSpace,
+		NodeName:     l.NodeName,
+		NodeLabels:   l.NodeLabels,
+		StartTime:    time.UnixMilli(createTime),
+		CPU:          cpuPercent,
+		CPUMachine:   cpuPercent / float64(c.numCPU),
 		Mem:          mem,
 	}
+
+	// The remaining counters are best effort; a process that exits mid-scan
+	// or hides /proc/<pid>/fd should still be reported.
+	if ppid, err := p.Ppid(); err == nil {
+		info.PPID = ppid
+	}
+	if name, err := p.Name(); err == nil {
+		info.Name = name
+	}
+	if mi, err := p.MemoryInfo(); err == nil && mi != nil {
+		info.RSS = mi.RSS
+	}
+	if n, err := p.NumThreads(); err == nil {
+		info.NumThreads = n
+	}
+	if n, err := p.NumFDs(); err == nil {
+		info.NumFDs = n
+	}
+	if limits, err := p.Rlimit(); err == nil {
+		info.FDLimit = fdLimit(limits)
+	}
+	if cs, err := p.NumCtxSwitches(); err == nil && cs != nil {
+		info.VoluntaryCtxSwitches = cs.Voluntary
+		info.InvoluntaryCtxSwitches = cs.Involuntary
+	}
+	if c.cgroups != nil {
+		if st, ok := c.cgroups.Stats(info.PID); ok {
+			info.Cgroup = &st
+		}
+	}
+	if io, err := p.IOCounters(); err == nil && io != nil {
+		info.ReadBytes = io.ReadBytes
+		info.WriteBytes = io.WriteBytes
+	}
+
+	return info
+}
+
+// fdLimit returns the soft open file limit among limits, or 0 if it is
+// missing or unlimited
+func fdLimit(limits []process.RlimitStat) uint64 {
+	for _, l := range limits {
+		if l.Resource == process.RLIMIT_NOFILE && l.Soft != math.MaxUint64 {
+			return l.Soft
+		}
+	}
+	return 0
 }
@@ -1,33 +1,107 @@
 package process
 
 import (
+	"errors"
+	"math"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/process"
 )
 
+// testNow is the fixed clock used by test collectors
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestCollector returns a single-core collector whose clock is pinned to testNow
+func newTestCollector() *Collector {
+	c := NewCollector()
+	c.numCPU = 1
+	c.now = func() time.Time { return testNow }
+	return c
+}
+
 // mockProcess implements processProvider for testing
 type mockProcess struct {
-	pid     int32
-	environ []string
-	cpu     float64
-	mem     float32
-	envErr  error
-	cpuErr  error
-	memErr  error
+	pid        int32
+	ppid       int32
+	name       string
+	environ    []string
+	cpu        float64 // cumulative CPU seconds
+	created    int64   // creation time in epoch milliseconds; defaults to 100s before testNow
+	mem        float32
+	rss        uint64
+	threads    int32
+	fds        int32
+	fdLimit    uint64 // soft open file limit; unlimited when zero
+	readBytes  uint64
+	writeBytes uint64
+	ctxVol     int64
+	ctxInvol   int64
+	envErr     error
+	cpuErr     error
+	memErr     error
 }
 
 func (m *mockProcess) Environ() ([]string, error) {
 	return m.environ, m.envErr
 }
 
-func (m *mockProcess) CPUPercent() (float64, error) {
-	return m.cpu, m.cpuErr
+func (m *mockProcess) Times() (*cpu.TimesStat, error) {
+	return &cpu.TimesStat{User: m.cpu}, m.cpuErr
+}
+
+func (m *mockProcess) CreateTime() (int64, error) {
+	if m.created == 0 {
+		return testNow.Add(-100 * time.Second).UnixMilli(), nil
+	}
+	return m.created, nil
 }
 
 func (m *mockProcess) MemoryPercent() (float32, error) {
 	return m.mem, m.memErr
 }
 
+func (m *mockProcess) MemoryInfo() (*process.MemoryInfoStat, error) {
+	return &process.MemoryInfoStat{RSS: m.rss}, nil
+}
+
+func (m *mockProcess) NumThreads() (int32, error) {
+	return m.threads, nil
+}
+
+func (m *mockProcess) NumFDs() (int32, error) {
+	return m.fds, nil
+}
+
+func (m *mockProcess) Rlimit() ([]process.RlimitStat, error) {
+	soft := m.fdLimit
+	if soft == 0 {
+		soft = math.MaxUint64
+	}
+	return []process.RlimitStat{
+		{Resource: process.RLIMIT_CPU, Soft: math.MaxUint64, Hard: math.MaxUint64},
+		{Resource: process.RLIMIT_NOFILE, Soft: soft, Hard: math.MaxUint64},
+	}, nil
+}
+
+func (m *mockProcess) NumCtxSwitches() (*process.NumCtxSwitchesStat, error) {
+	return &process.NumCtxSwitchesStat{Voluntary: m.ctxVol, Involuntary: m.ctxInvol}, nil
+}
+
+func (m *mockProcess) IOCounters() (*process.IOCountersStat, error) {
+	return &process.IOCountersStat{ReadBytes: m.readBytes, WriteBytes: m.writeBytes}, nil
+}
+
+func (m *mockProcess) Ppid() (int32, error) {
+	return m.ppid, nil
+}
+
+func (m *mockProcess) Name() (string, error) {
+	return m.name, nil
+}
+
 func (m *mockProcess) Pid() int32 {
 	return m.pid
 }
@@ -42,24 +116,49 @@ func TestExtractJenkinsInfo(t *testing.T) {
 		{
 			name: "valid Jenkins process",
 			proc: &mockProcess{
-				pid: 1234,
-				cpu: 10.5,
-				mem: 20.2,
+				pid:        1234,
+				ppid:       1,
+				cpu:        10.5,
+				mem:        20.2,
+				rss:        4096,
+				threads:    12,
+				fds:        30,
+				fdLimit:    1024,
+				readBytes:  1 << 20,
+				writeBytes: 2 << 20,
+				ctxVol:     500,
+				ctxInvol:   7,
 			},
 			environ: []string{
 				"JOB_NAME=build_app",
 				"BUILD_ID=42",
 				"STAGE_NAME=test",
 				"WORKSPACE=/var/lib/jenkins/workspace/build_app",
+				"NODE_NAME=agent-1",
+				"NODE_LABELS=agent-1 linux docker",
 			},
 			want: &ProcessInfo{
 				PID:          1234,
+				PPID:         1,
 				BuildJobName: "build_app",
 				BuildId:      "42",
 				StageName:    "test",
 				WorkSpace:    "/var/lib/jenkins/workspace/build_app",
+				NodeName:     "agent-1",
+				NodeLabels:   []string{"agent-1", "linux", "docker"},
+				StartTime:    time.UnixMilli(testNow.Add(-100 * time.Second).UnixMilli()),
 				CPU:          10.5,
+				CPUMachine:   10.5,
 				Mem:          20.2,
+				RSS:          4096,
+				NumThreads:   12,
+				NumFDs:       30,
+				FDLimit:      1024,
+				ReadBytes:    1 << 20,
+				WriteBytes:   2 << 20,
+
+				VoluntaryCtxSwitches:   500,
+				InvoluntaryCtxSwitches: 7,
 			},
 		},
 		{
@@ -79,10 +178,86 @@ func TestExtractJenkinsInfo(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := extractJenkinsInfo(tt.proc, tt.environ)
+			got := newTestCollector().extractJenkinsInfo(tt.proc, tt.environ)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("extractJenkinsInfo() = %+v, want %+v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestCollectProcessesInheritsBuildFromAncestor(t *testing.T) {
+	jobEnv := []string{"JOB_NAME=build_app", "BUILD_ID=7"}
+	procs := []processProvider{
+		&mockProcess{pid: 1, ppid: 0, environ: []string{"PATH=/usr/bin"}},
+		&mockProcess{pid: 100, ppid: 1, environ: jobEnv, cpu: 5},
+		&mockProcess{pid: 101, ppid: 100, environ: jobEnv, cpu: 50},
+		&mockProcess{pid: 102, ppid: 101, environ: []string{}, cpu: 20},
+		&mockProcess{pid: 103, ppid: 102, environ: nil, cpu: 1},
+		// Environments that cannot be read are inherited into as well
+		&mockProcess{pid: 104, ppid: 101, envErr: errors.New("permission denied"), cpu: 10},
+		&mockProcess{pid: 105, ppid: 104, environ: []string{}, cpu: 2},
+		&mockProcess{pid: 200, ppid: 1, environ: []string{"USER=root"}, cpu: 99},
+		&mockProcess{pid: 201, ppid: 200, envErr: errors.New("permission denied"), cpu: 3},
+	}
+
+	got := newTestCollector().collect(procs)
+
+	pids := make(map[int32]ProcessInfo)
+	for _, p := range got {
+		pids[p.PID] = p
+	}
+	if len(pids) != 6 {
+		t.Fatalf("collectProcesses() returned %d processes, want 6: %+v", len(pids), got)
+	}
+	for _, pid := range []int32{100, 101, 102, 103, 104, 105} {
+		p, ok := pids[pid]
+		if !ok {
+			t.Errorf("PID %d missing from result", pid)
+			continue
+		}
+		if p.BuildJobName != "build_app" || p.BuildId != "7" {
+			t.Errorf("PID %d labelled %q #%q, want build_app #7", pid, p.BuildJobName, p.BuildId)
+		}
+	}
+	for _, pid := range []int32{200, 201} {
+		if _, ok := pids[pid]; ok {
+			t.Errorf("unrelated PID %d should not be collected", pid)
+		}
+	}
+}
+
+func TestCollectorMeasuresCPUSincePreviousSample(t *testing.T) {
+	c := newTestCollector()
+	c.numCPU = 4
+	now := testNow
+	c.now = func() time.Time { return now }
+
+	// Mostly idle for its first 100 seconds of life...
+	p := &mockProcess{pid: 10, environ: []string{"JOB_NAME=app"}, cpu: 1}
+	first := c.collect([]processProvider{p})
+	if len(first) != 1 || first[0].CPU != 1 {
+		t.Fatalf("first sample = %+v, want lifetime average of 1%%", first)
+	}
+
+	// ...then it pegs two cores for the next 10 seconds.
+	now = now.Add(10 * time.Second)
+	p.cpu += 20
+	second := c.collect([]processProvider{p})
+	if len(second) != 1 || second[0].CPU != 200 || second[0].CPUMachine != 50 {
+		t.Fatalf("second sample = %+v, want 200%% of one core and 50%% of machine", second)
+	}
+
+	// A reused PID must not be diffed against the old process's counters.
+	now = now.Add(10 * time.Second)
+	reused := &mockProcess{pid: 10, environ: []string{"JOB_NAME=app"}, cpu: 5, created: now.Add(-10 * time.Second).UnixMilli()}
+	third := c.collect([]processProvider{reused})
+	if len(third) != 1 || third[0].CPU != 50 {
+		t.Fatalf("reused PID sample = %+v, want 50%% since process start", third)
+	}
+
+	c.collect(nil)
+	if len(c.prev) != 0 {
+		t.Errorf("snapshots of exited processes were not pruned")
+	}
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package remediation
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"jenkins-monitor/internal/config"
+)
+
+// ioprio_set arguments, from linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// setNice sets the niceness of every thread of the process, as Linux keeps
+// one per thread
+func setNice(pid int32, nice int) error {
+	return eachThread(pid, func(tid int) error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// setIOPriority sets the IO scheduling class and level of every thread of the process
+func setIOPriority(pid int32, class string, level int) error {
+	prio := ioprioClassBE<<ioprioClassShift | level
+	if class == config.IOClassIdle {
+		prio = ioprioClassIdle << ioprioClassShift
+	}
+	return eachThread(pid, func(tid int) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// eachThread applies f to the threads listed in /proc/<pid>/task, or to the
+// process alone when they cannot be listed
+func eachThread(pid int32, f func(tid int) error) error {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return f(int(pid))
+	}
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		// Threads may exit while the priority is changed
+		if err := f(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package remediation
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setNice is only supported on Linux
+func setNice(pid int32, nice int) error {
+	return fmt.Errorf("renice is not supported on %s", runtime.GOOS)
+}
+
+// setIOPriority is only supported on Linux
+func setIOPriority(pid int32, class string, level int) error {
+	return fmt.Errorf("ionice is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,475 @@
+package remediation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/process"
+	"jenkins-monitor/internal/utils"
+)
+
+// Results of an action
+const (
+	ResultOK         = "ok"
+	ResultFailed     = "failed"
+	ResultDryRun     = "dry_run"
+	ResultNotAllowed = "not_allowed"
+	// ResultAbandoned marks a SIGKILL escalation the monitor stopped before sending
+	ResultAbandoned = "abandoned"
+)
+
+// ActionKill is the SIGKILL a terminate action escalates to after its grace period
+const ActionKill = "kill"
+
+// maxHookOutput bounds the hook output kept in the audit log
+const maxHookOutput = 512
+
+// firingAlert is an alert whose rule has actions, tracked from the pass it
+// fired in until it resolves
+type firingAlert struct {
+	event alert.Event
+	taken []bool // per action of the rule
+}
+
+// Remediator takes the actions of the threshold rules on the builds whose
+// alerts keep firing. Every action, including the ones only recorded in
+// dry-run mode or refused by the job allowlist, is appended to the audit log
+// and reported.
+type Remediator struct {
+	cfg      config.RemediationConfig
+	auditLog string
+	report   func(alert.Event)
+	sys      system
+	firing   map[string]*firingAlert
+
+	// Escalations and hooks finish in the background; mu serialises their
+	// audit log writes and reports with the ones of the monitor loop
+	mu    sync.Mutex
+	after func(d time.Duration, f func()) (stop func() bool)
+	async func(f func())
+
+	// escalations are the SIGKILLs waiting for their grace period, guarded by
+	// tasksMu; running counts them and the hooks in flight for Close
+	tasksMu     sync.Mutex
+	escalations map[*escalation]bool
+	running     sync.WaitGroup
+}
+
+// escalation is a pending SIGKILL of a terminate action
+type escalation struct {
+	trigger alert.Event
+	rec     alert.Action
+	grace   time.Duration
+	stop    func() bool
+}
+
+// New creates a Remediator appending to auditLog and passing every action to report
+func New(cfg config.RemediationConfig, auditLog string, report func(alert.Event)) *Remediator {
+	return &Remediator{
+		cfg:      cfg,
+		auditLog: auditLog,
+		report:   report,
+		sys:      realSystem{},
+		firing:   make(map[string]*firingAlert),
+		after:    func(d time.Duration, f func()) func() bool { return time.AfterFunc(d, f).Stop },
+		async:    func(f func()) { go f() },
+
+		escalations: make(map[*escalation]bool),
+	}
+}
+
+// Close waits for the hooks in flight and records the escalations still
+// waiting for their grace period as abandoned, as their SIGKILL will not be
+// sent once the monitor has stopped. No action may be taken after Close.
+func (r *Remediator) Close() {
+	r.tasksMu.Lock()
+	pending := make([]*escalation, 0, len(r.escalations))
+	for e := range r.escalations {
+		pending = append(pending, e)
+	}
+	r.escalations = make(map[*escalation]bool)
+	r.tasksMu.Unlock()
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].rec.Time.Before(pending[j].rec.Time) })
+	for _, e := range pending {
+		e.stop()
+		abandoned := e.rec
+		abandoned.Time, abandoned.Action = time.Now(), ActionKill
+		abandoned.Result = ResultAbandoned
+		abandoned.Detail = fmt.Sprintf("monitor stopped within the %s grace period after SIGTERM, SIGKILL not sent", e.grace)
+		r.record(e.trigger, abandoned)
+		r.running.Done()
+	}
+	r.running.Wait()
+}
+
+// Update takes the events and observations of a pass and the actions that
+// became due. Alerts are tracked from their firing event and dropped once
+// their observation is no longer breached; actions are taken on the build as
+// it was sampled in this pass.
+func (r *Remediator) Update(events []alert.Event, observations []alert.Observation, now time.Time) {
+	for _, ev := range events {
+		if ev.State == alert.Firing && !ev.Repeat && ev.Exit == nil && ev.Action == nil && len(ev.Rule.Actions) > 0 {
+			r.firing[ev.Key()] = &firingAlert{event: ev, taken: make([]bool, len(ev.Rule.Actions))}
+		}
+	}
+
+	breached := make(map[string]alert.Observation, len(observations))
+	for _, o := range observations {
+		if o.Breached {
+			breached[o.Key()] = o
+		}
+	}
+	var keys []string
+	for key, fa := range r.firing {
+		o, ok := breached[key]
+		if !ok {
+			delete(r.firing, key)
+			continue
+		}
+		fa.event.Build, fa.event.Value = o.Build, o.Value
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fa := r.firing[key]
+		for i, a := range fa.event.Rule.Actions {
+			if fa.taken[i] || !a.Triggers(fa.event.Type) || now.Sub(fa.event.StartedAt) < a.After {
+				continue
+			}
+			fa.taken[i] = true
+			r.take(fa.event, a, now)
+		}
+	}
+}
+
+// take runs one action, or only records it in dry-run mode or when the job is not allowed
+func (r *Remediator) take(ev alert.Event, a config.ActionConfig, now time.Time) {
+	targets := Targets(ev, a.Target)
+	rec := alert.Action{
+		Time:    now,
+		Action:  a.Type,
+		Alert:   ev.Type,
+		Rule:    ev.Rule.Name,
+		JobName: ev.Build.BuildJobName,
+		BuildID: ev.Build.BuildId,
+		PIDs:    pids(targets),
+		DryRun:  r.cfg.DryRun,
+	}
+	switch {
+	case len(targets) == 0:
+		return
+	case !r.cfg.Allows(ev.Build.BuildJobName):
+		rec.Result, rec.Detail = ResultNotAllowed, "job is not in remediation allowed_jobs"
+		r.record(ev, rec)
+		return
+	case r.cfg.DryRun:
+		rec.Result, rec.Detail = ResultDryRun, describe(a)
+		r.record(ev, rec)
+		return
+	}
+
+	switch a.Type {
+	case config.ActionTerminate:
+		r.terminate(ev, a, targets, rec)
+	case config.ActionRenice:
+		r.record(ev, r.renice(a, targets, rec))
+	case config.ActionHook:
+		r.running.Add(1)
+		r.async(func() {
+			defer r.running.Done()
+			r.record(ev, r.hook(ev, a, targets, rec))
+		})
+	}
+}
+
+// terminate sends SIGTERM to the targets and SIGKILL to the ones still
+// running after the grace period
+func (r *Remediator) terminate(ev alert.Event, a config.ActionConfig, targets []process.ProcessInfo, rec alert.Action) {
+	var signalled []process.ProcessInfo
+	var errs []string
+	for _, p := range targets {
+		if !r.sys.alive(p) {
+			continue
+		}
+		if err := r.sys.signal(p.PID, syscall.SIGTERM); err != nil {
+			errs = append(errs, fmt.Sprintf("PID %d: %v", p.PID, err))
+			continue
+		}
+		signalled = append(signalled, p)
+	}
+	rec.PIDs = pids(signalled)
+	rec.Result, rec.Detail = result(errs, fmt.Sprintf("sent SIGTERM, SIGKILL after %s", a.Grace))
+	r.record(ev, rec)
+	if len(signalled) == 0 {
+		return
+	}
+
+	e := &escalation{trigger: ev, rec: rec, grace: a.Grace}
+	r.tasksMu.Lock()
+	r.escalations[e] = true
+	r.tasksMu.Unlock()
+	r.running.Add(1)
+	e.stop = r.after(a.Grace, func() {
+		// Close may have recorded the escalation as abandoned already
+		r.tasksMu.Lock()
+		pending := r.escalations[e]
+		delete(r.escalations, e)
+		r.tasksMu.Unlock()
+		if !pending {
+			return
+		}
+		defer r.running.Done()
+
+		var killed []process.ProcessInfo
+		var errs []string
+		for _, p := range signalled {
+			if !r.sys.alive(p) {
+				continue
+			}
+			if err := r.sys.signal(p.PID, syscall.SIGKILL); err != nil {
+				errs = append(errs, fmt.Sprintf("PID %d: %v", p.PID, err))
+				continue
+			}
+			killed = append(killed, p)
+		}
+		if len(killed) == 0 && len(errs) == 0 {
+			utils.Info(fmt.Sprintf("Processes of job %s #%s exited within %s of SIGTERM", rec.JobName, rec.BuildID, a.Grace))
+			return
+		}
+		kill := rec
+		kill.Time, kill.Action, kill.PIDs = time.Now(), ActionKill, pids(killed)
+		kill.Result, kill.Detail = result(errs, fmt.Sprintf("still running %s after SIGTERM, sent SIGKILL", a.Grace))
+		r.record(ev, kill)
+	})
+}
+
+// renice lowers the CPU and IO priority of the targets still running
+func (r *Remediator) renice(a config.ActionConfig, targets []process.ProcessInfo, rec alert.Action) alert.Action {
+	var errs []string
+	var reniced, exited []process.ProcessInfo
+	for _, p := range targets {
+		// The PID of a process that exited may belong to another one by now
+		if !r.sys.alive(p) {
+			exited = append(exited, p)
+			continue
+		}
+		reniced = append(reniced, p)
+		if a.Nice != nil {
+			if err := r.sys.renice(p.PID, *a.Nice); err != nil {
+				errs = append(errs, fmt.Sprintf("PID %d: %v", p.PID, err))
+			}
+		}
+		if a.IOClass != "" {
+			if err := r.sys.ionice(p.PID, a.IOClass, a.IOLevel); err != nil {
+				errs = append(errs, fmt.Sprintf("PID %d: %v", p.PID, err))
+			}
+		}
+	}
+	rec.PIDs = pids(reniced)
+	rec.Result, rec.Detail = result(errs, describe(a))
+	rec.Detail = withSkipped(rec.Detail, exited)
+	return rec
+}
+
+// hook runs the hook command once per target still running
+func (r *Remediator) hook(ev alert.Event, a config.ActionConfig, targets []process.ProcessInfo, rec alert.Action) alert.Action {
+	var errs, outputs []string
+	var hooked, exited []process.ProcessInfo
+	for _, p := range targets {
+		// Hooks run in the background, so targets are checked right before each run
+		if !r.sys.alive(p) {
+			exited = append(exited, p)
+			continue
+		}
+		hooked = append(hooked, p)
+		out, err := r.sys.runHook(a.Command, a.Timeout, HookEnv(p, ev))
+		if out = strings.TrimSpace(out); out != "" {
+			outputs = append(outputs, fmt.Sprintf("PID %d: %s", p.PID, out))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("PID %d: %v", p.PID, err))
+		}
+	}
+	detail := strings.Join(outputs, "; ")
+	if len(detail) > maxHookOutput {
+		detail = detail[:maxHookOutput] + "..."
+	}
+	rec.PIDs = pids(hooked)
+	rec.Result, rec.Detail = result(errs, detail)
+	rec.Detail = withSkipped(rec.Detail, exited)
+	rec.Time = time.Now()
+	return rec
+}
+
+// record appends an action to the audit log and reports it
+func (r *Remediator) record(trigger alert.Event, rec alert.Action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.auditLog != "" {
+		if err := appendAudit(r.auditLog, rec); err != nil {
+			utils.Error(err.Error())
+		}
+	}
+	ev := trigger
+	ev.Type, ev.State, ev.Repeat, ev.Time = alert.Remediation, alert.Firing, false, rec.Time
+	ev.Action = &rec
+	r.report(ev)
+}
+
+// appendAudit writes one action as a JSON line
+func appendAudit(path string, rec alert.Action) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode remediation action: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open remediation audit log: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write remediation audit log: %w", err)
+	}
+	return nil
+}
+
+// Targets returns the processes of the event's build an action is taken on:
+// the one contributing most to the alert for top, and every process, children
+// before their parents, for build
+func Targets(ev alert.Event, target string) []process.ProcessInfo {
+	procs := ev.Build.Processes
+	if len(procs) == 0 {
+		return nil
+	}
+	if target == config.TargetBuild {
+		targets := make([]process.ProcessInfo, 0, len(procs))
+		for i := len(procs) - 1; i >= 0; i-- {
+			targets = append(targets, procs[i].ProcessInfo)
+		}
+		return targets
+	}
+
+	contribution := func(p *process.ProcessInfo) float64 {
+		switch ev.Type {
+		case alert.CPUHigh, alert.CgroupThrottled:
+			return p.CPU
+		case alert.ThreadsHigh:
+			return float64(p.NumThreads)
+		case alert.FDsHigh:
+			return float64(p.NumFDs)
+		case alert.FDLimitHigh:
+			return p.FDPercent()
+		case alert.WorkspaceSizeHigh, alert.WorkspaceGrowthHigh:
+			return float64(p.WriteBytes)
+		}
+		return float64(p.RSS)
+	}
+	top := procs[0].ProcessInfo
+	for _, p := range procs[1:] {
+		if contribution(&p.ProcessInfo) > contribution(&top) {
+			top = p.ProcessInfo
+		}
+	}
+	return []process.ProcessInfo{top}
+}
+
+// HookEnv returns the environment of a hook run for one process: the
+// monitor's own, with the fields of the process and the alert prefixed by
+// JENKINS_MONITOR_
+func HookEnv(p process.ProcessInfo, ev alert.Event) []string {
+	vars := [][2]string{
+		{"PID", fmt.Sprint(p.PID)},
+		{"PPID", fmt.Sprint(p.PPID)},
+		{"NAME", p.Name},
+		{"JOB_NAME", p.BuildJobName},
+		{"BUILD_ID", p.BuildId},
+		{"STAGE_NAME", p.StageName},
+		{"WORKSPACE", p.WorkSpace},
+		{"NODE_NAME", p.NodeName},
+		{"NODE_LABELS", strings.Join(p.NodeLabels, " ")},
+		{"START_TIME", p.StartTime.Format(time.RFC3339)},
+		{"CPU", fmt.Sprintf("%.2f", p.CPU)},
+		{"CPU_MACHINE", fmt.Sprintf("%.2f", p.CPUMachine)},
+		{"MEM", fmt.Sprintf("%.2f", p.Mem)},
+		{"RSS", fmt.Sprint(p.RSS)},
+		{"THREADS", fmt.Sprint(p.NumThreads)},
+		{"FDS", fmt.Sprint(p.NumFDs)},
+		{"FD_LIMIT", fmt.Sprint(p.FDLimit)},
+		{"READ_BYTES", fmt.Sprint(p.ReadBytes)},
+		{"WRITE_BYTES", fmt.Sprint(p.WriteBytes)},
+		{"ALERT", ev.Type},
+		{"ALERT_VALUE", alert.FormatValue(ev.Type, ev.Value)},
+		{"ALERT_THRESHOLD", alert.FormatValue(ev.Type, ev.Threshold)},
+		{"RULE", ev.Rule.Name},
+		{"SEVERITY", ev.Rule.Severity},
+	}
+	env := os.Environ()
+	for _, v := range vars {
+		env = append(env, "JENKINS_MONITOR_"+v[0]+"="+v[1])
+	}
+	return env
+}
+
+// describe summarises what an action does
+func describe(a config.ActionConfig) string {
+	switch a.Type {
+	case config.ActionTerminate:
+		return fmt.Sprintf("SIGTERM, SIGKILL after %s", a.Grace)
+	case config.ActionRenice:
+		var parts []string
+		if a.Nice != nil {
+			parts = append(parts, fmt.Sprintf("nice %d", *a.Nice))
+		}
+		switch a.IOClass {
+		case config.IOClassBestEffort:
+			parts = append(parts, fmt.Sprintf("ionice %s %d", a.IOClass, a.IOLevel))
+		case config.IOClassIdle:
+			parts = append(parts, "ionice "+a.IOClass)
+		}
+		return strings.Join(parts, ", ")
+	}
+	return "run " + a.Command
+}
+
+// withSkipped appends the targets that exited before the action to its detail
+func withSkipped(detail string, exited []process.ProcessInfo) string {
+	if len(exited) == 0 {
+		return detail
+	}
+	ids := make([]string, 0, len(exited))
+	for _, p := range exited {
+		ids = append(ids, fmt.Sprint(p.PID))
+	}
+	skipped := "skipped exited PIDs " + strings.Join(ids, ", ")
+	if detail == "" {
+		return skipped
+	}
+	return detail + "; " + skipped
+}
+
+// result returns failed with the errors, or ok with the detail
+func result(errs []string, detail string) (string, string) {
+	if len(errs) > 0 {
+		return ResultFailed, strings.Join(errs, "; ")
+	}
+	return ResultOK, detail
+}
+
+func pids(procs []process.ProcessInfo) []int32 {
+	ids := make([]int32, 0, len(procs))
+	for _, p := range procs {
+		ids = append(ids, p.PID)
+	}
+	return ids
+}
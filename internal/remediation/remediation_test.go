@@ -0,0 +1,293 @@
+package remediation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"jenkins-monitor/internal/alert"
+	"jenkins-monitor/internal/config"
+	"jenkins-monitor/internal/process"
+)
+
+// fakeSystem records the calls made on it; processes in exited are gone
+type fakeSystem struct {
+	calls  []string
+	exited map[int32]bool
+}
+
+func (f *fakeSystem) alive(p process.ProcessInfo) bool { return !f.exited[p.PID] }
+
+func (f *fakeSystem) signal(pid int32, sig syscall.Signal) error {
+	f.calls = append(f.calls, fmt.Sprintf("signal %d %d", pid, sig))
+	return nil
+}
+
+func (f *fakeSystem) renice(pid int32, nice int) error {
+	f.calls = append(f.calls, fmt.Sprintf("renice %d %d", pid, nice))
+	return nil
+}
+
+func (f *fakeSystem) ionice(pid int32, class string, level int) error {
+	f.calls = append(f.calls, fmt.Sprintf("ionice %d %s %d", pid, class, level))
+	return nil
+}
+
+func (f *fakeSystem) runHook(command string, timeout time.Duration, env []string) (string, error) {
+	f.calls = append(f.calls, "hook "+command)
+	return "done", nil
+}
+
+func newBuild(job string, procs ...process.ProcessInfo) process.BuildInfo {
+	b := process.BuildInfo{BuildJobName: job, BuildId: "1"}
+	for _, p := range procs {
+		p.BuildJobName, p.BuildId = job, "1"
+		b.Processes = append(b.Processes, process.BuildProcess{ProcessInfo: p})
+		b.RSS += p.RSS
+	}
+	return b
+}
+
+func newRemediator(t *testing.T, cfg config.RemediationConfig) (*Remediator, *fakeSystem, *[]alert.Event) {
+	var reported []alert.Event
+	r := New(cfg, filepath.Join(t.TempDir(), "audit.jsonl"), func(ev alert.Event) {
+		reported = append(reported, ev)
+	})
+	sys := &fakeSystem{exited: make(map[int32]bool)}
+	r.sys = sys
+	r.after = func(d time.Duration, f func()) func() bool {
+		f()
+		return func() bool { return false }
+	}
+	r.async = func(f func()) { f() }
+	return r, sys, &reported
+}
+
+func TestRemediatorUpdate(t *testing.T) {
+	nice := 10
+	rule := config.ThresholdRule{Name: "leaky", Actions: []config.ActionConfig{
+		{Type: config.ActionRenice, Target: config.TargetBuild, Nice: &nice},
+		{Type: config.ActionTerminate, Target: config.TargetTop, After: time.Minute, Grace: time.Second},
+		{Type: config.ActionHook, On: []string{alert.CPUHigh}, Command: "notify.sh"},
+	}}
+	parent := process.ProcessInfo{PID: 10, Name: "java", RSS: 1 << 30}
+	child := process.ProcessInfo{PID: 11, Name: "java", RSS: 3 << 30}
+	start := time.Unix(1700000000, 0)
+
+	pass := func(r *Remediator, job string, breached bool, now time.Time, events bool) {
+		b := newBuild(job, parent, child)
+		o := alert.Observation{Type: alert.RSSHigh, Build: b, Rule: rule, Value: float64(b.RSS), Breached: breached}
+		var evs []alert.Event
+		if events {
+			evs = []alert.Event{{Type: alert.RSSHigh, State: alert.Firing, Build: b, Rule: rule, StartedAt: now, Time: now}}
+		}
+		r.Update(evs, []alert.Observation{o}, now)
+	}
+
+	t.Run("actions taken once when due", func(t *testing.T) {
+		r, sys, reported := newRemediator(t, config.RemediationConfig{AllowedJobs: []string{"app-*"}})
+		pass(r, "app-main", true, start, true)
+		if want := []string{"renice 11 10", "renice 10 10"}; !slices.Equal(sys.calls, want) {
+			t.Fatalf("calls after firing = %v, want %v", sys.calls, want)
+		}
+
+		pass(r, "app-main", true, start.Add(30*time.Second), false)
+		sys.exited[10] = true
+		pass(r, "app-main", true, start.Add(time.Minute), false)
+		pass(r, "app-main", true, start.Add(2*time.Minute), false)
+		want := []string{"renice 11 10", "renice 10 10",
+			fmt.Sprintf("signal 11 %d", syscall.SIGTERM), fmt.Sprintf("signal 11 %d", syscall.SIGKILL)}
+		if !slices.Equal(sys.calls, want) {
+			t.Fatalf("calls = %v, want %v", sys.calls, want)
+		}
+
+		var actions []string
+		for _, ev := range *reported {
+			if ev.Type != alert.Remediation || ev.Action.Alert != alert.RSSHigh || ev.Action.Result != ResultOK {
+				t.Errorf("reported %+v, want a successful remediation of RSS_HIGH", ev)
+			}
+			actions = append(actions, ev.Action.Action)
+		}
+		if want := []string{config.ActionRenice, config.ActionTerminate, ActionKill}; !slices.Equal(actions, want) {
+			t.Errorf("reported actions = %v, want %v", actions, want)
+		}
+
+		audit := readAudit(t, r.auditLog)
+		if len(audit) != 3 || !slices.Equal(audit[1].PIDs, []int32{11}) || audit[2].JobName != "app-main" {
+			t.Errorf("audit log = %+v, want the three actions", audit)
+		}
+	})
+
+	t.Run("resolved alert takes no further action", func(t *testing.T) {
+		r, sys, _ := newRemediator(t, config.RemediationConfig{AllowedJobs: []string{"app-*"}})
+		pass(r, "app-main", true, start, true)
+		pass(r, "app-main", false, start.Add(30*time.Second), false)
+		pass(r, "app-main", true, start.Add(time.Minute), false)
+		if len(sys.calls) != 2 {
+			t.Errorf("calls = %v, want only the renice", sys.calls)
+		}
+	})
+
+	t.Run("job not allowed", func(t *testing.T) {
+		r, sys, reported := newRemediator(t, config.RemediationConfig{AllowedJobs: []string{"app-*"}})
+		pass(r, "web", true, start, true)
+		if len(sys.calls) != 0 || len(*reported) != 1 || (*reported)[0].Action.Result != ResultNotAllowed {
+			t.Errorf("calls = %v, reported = %+v, want the renice refused", sys.calls, *reported)
+		}
+	})
+
+	t.Run("exited targets are skipped", func(t *testing.T) {
+		hookRule := config.ThresholdRule{Name: "hooked", Actions: []config.ActionConfig{
+			{Type: config.ActionRenice, Target: config.TargetBuild, Nice: &nice},
+			{Type: config.ActionHook, Target: config.TargetBuild, Command: "notify.sh"},
+		}}
+		r, sys, reported := newRemediator(t, config.RemediationConfig{AllowedJobs: []string{"*"}})
+		sys.exited[11] = true
+		b := newBuild("app", parent, child)
+		ev := alert.Event{Type: alert.RSSHigh, State: alert.Firing, Build: b, Rule: hookRule, StartedAt: start, Time: start}
+		r.Update([]alert.Event{ev}, []alert.Observation{{Type: alert.RSSHigh, Build: b, Rule: hookRule, Breached: true}}, start)
+
+		if want := []string{"renice 10 10", "hook notify.sh"}; !slices.Equal(sys.calls, want) {
+			t.Fatalf("calls = %v, want only the running parent acted on", sys.calls)
+		}
+		for _, ev := range *reported {
+			if a := ev.Action; !slices.Equal(a.PIDs, []int32{10}) || !strings.HasSuffix(a.Detail, "skipped exited PIDs 11") {
+				t.Errorf("action = %+v, want PID 10 acted on and 11 skipped", a)
+			}
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		r, sys, reported := newRemediator(t, config.RemediationConfig{DryRun: true, AllowedJobs: []string{"*"}})
+		pass(r, "app-main", true, start, true)
+		pass(r, "app-main", true, start.Add(time.Minute), false)
+		if len(sys.calls) != 0 || len(*reported) != 2 {
+			t.Fatalf("calls = %v, reported = %+v, want two dry runs", sys.calls, *reported)
+		}
+		if a := (*reported)[1].Action; a.Result != ResultDryRun || !a.DryRun || a.Detail != "SIGTERM, SIGKILL after 1s" {
+			t.Errorf("action = %+v, want a dry-run terminate", a)
+		}
+	})
+}
+
+func TestRemediatorClose(t *testing.T) {
+	rule := config.ThresholdRule{Name: "leaky", Actions: []config.ActionConfig{
+		{Type: config.ActionTerminate, Target: config.TargetTop, Grace: 30 * time.Second},
+		{Type: config.ActionHook, Command: "notify.sh"},
+	}}
+	r, sys, reported := newRemediator(t, config.RemediationConfig{AllowedJobs: []string{"*"}})
+	// The escalation waits for its grace period and the hook is still running
+	stopped := false
+	r.after = func(d time.Duration, f func()) func() bool {
+		return func() bool { stopped = true; return true }
+	}
+	release := make(chan struct{})
+	r.async = func(f func()) {
+		go func() {
+			<-release
+			f()
+		}()
+	}
+
+	now := time.Unix(1700000000, 0)
+	b := newBuild("app", process.ProcessInfo{PID: 10, RSS: 1 << 30})
+	ev := alert.Event{Type: alert.RSSHigh, State: alert.Firing, Build: b, Rule: rule, StartedAt: now, Time: now}
+	r.Update([]alert.Event{ev}, []alert.Observation{{Type: alert.RSSHigh, Build: b, Rule: rule, Breached: true}}, now)
+
+	closed := make(chan struct{})
+	go func() {
+		r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() returned while a hook was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+
+	if !stopped {
+		t.Error("Close() did not stop the pending SIGKILL")
+	}
+	if want := []string{fmt.Sprintf("signal 10 %d", syscall.SIGTERM), "hook notify.sh"}; !slices.Equal(sys.calls, want) {
+		t.Errorf("calls = %v, want %v", sys.calls, want)
+	}
+	var results []string
+	for _, ev := range *reported {
+		results = append(results, ev.Action.Action+" "+ev.Action.Result)
+	}
+	want := []string{"terminate ok", "kill abandoned", "hook ok"}
+	if !slices.Equal(results, want) {
+		t.Errorf("reported = %v, want %v", results, want)
+	}
+	if audit := readAudit(t, r.auditLog); len(audit) != 3 || audit[1].Result != ResultAbandoned || !slices.Equal(audit[1].PIDs, []int32{10}) {
+		t.Errorf("audit log = %+v, want the abandoned SIGKILL recorded", audit)
+	}
+}
+
+func readAudit(t *testing.T, path string) []alert.Action {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer file.Close()
+	var actions []alert.Action
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var a alert.Action
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			t.Fatalf("decode audit line %q: %v", scanner.Text(), err)
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+func TestTargets(t *testing.T) {
+	b := newBuild("app",
+		process.ProcessInfo{PID: 1, CPU: 90, RSS: 100, NumThreads: 5},
+		process.ProcessInfo{PID: 2, CPU: 10, RSS: 900, NumThreads: 2},
+		process.ProcessInfo{PID: 3, CPU: 20, RSS: 50, NumThreads: 40},
+	)
+	tests := []struct {
+		alertType string
+		target    string
+		want      []int32
+	}{
+		{alert.CPUHigh, config.TargetTop, []int32{1}},
+		{alert.MemHigh, config.TargetTop, []int32{2}},
+		{alert.ThreadsHigh, config.TargetTop, []int32{3}},
+		{alert.DurationHigh, config.TargetTop, []int32{2}},
+		{alert.CPUHigh, config.TargetBuild, []int32{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alertType+"/"+tt.target, func(t *testing.T) {
+			got := pids(Targets(alert.Event{Type: tt.alertType, Build: b}, tt.target))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Targets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	p := process.ProcessInfo{PID: 42, Name: "java", BuildJobName: "app", RSS: 1024}
+	env := HookEnv(p, alert.Event{Type: alert.RSSHigh, Value: 2048})
+
+	out, err := realSystem{}.runHook(`echo "$JENKINS_MONITOR_PID $JENKINS_MONITOR_JOB_NAME $JENKINS_MONITOR_ALERT"`, time.Second, env)
+	if err != nil || strings.TrimSpace(out) != "42 app RSS_HIGH" {
+		t.Errorf("runHook() = %q, %v, want the process fields", out, err)
+	}
+	if _, err := (realSystem{}).runHook("sleep 5", 50*time.Millisecond, env); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("runHook() error = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,67 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	gopsutil "github.com/shirou/gopsutil/v3/process"
+
+	"jenkins-monitor/internal/process"
+)
+
+// system acts on processes; tests replace it
+type system interface {
+	// alive reports whether the process is still the one sampled, not a
+	// later one reusing its PID
+	alive(p process.ProcessInfo) bool
+	signal(pid int32, sig syscall.Signal) error
+	renice(pid int32, nice int) error
+	ionice(pid int32, class string, level int) error
+	runHook(command string, timeout time.Duration, env []string) (string, error)
+}
+
+type realSystem struct{}
+
+func (realSystem) alive(p process.ProcessInfo) bool {
+	proc, err := gopsutil.NewProcess(p.PID)
+	if err != nil {
+		return false
+	}
+	created, err := proc.CreateTime()
+	return err == nil && created == p.StartTime.UnixMilli()
+}
+
+func (realSystem) signal(pid int32, sig syscall.Signal) error {
+	proc, err := os.FindProcess(int(pid))
+	if err != nil {
+		return err
+	}
+	return proc.Signal(sig)
+}
+
+func (realSystem) renice(pid int32, nice int) error {
+	return setNice(pid, nice)
+}
+
+func (realSystem) ionice(pid int32, class string, level int) error {
+	return setIOPriority(pid, class, level)
+}
+
+// runHook runs the command with the shell and returns its combined output
+func (realSystem) runHook(command string, timeout time.Duration, env []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = env
+	// Children of the shell may hold its output open after it is killed
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(out), fmt.Errorf("timed out after %s", timeout)
+	}
+	return string(out), err
+}
@@ -115,3 +115,17 @@ func GetDir(path string) string {
 func PrintLine(length int) {
 	fmt.Println(strings.Repeat("-", length))
 }
+
+// FormatBytes renders a byte count using binary units (e.g. 1.5GiB)
+func FormatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -72,3 +72,23 @@ func TestGetDir(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatBytes(t *testing.T) {
+	testCases := []struct {
+		input    uint64
+		expected string
+	}{
+		{input: 512, expected: "512B"},
+		{input: 1536, expected: "1.5KiB"},
+		{input: 4 * 1024 * 1024 * 1024, expected: "4.0GiB"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			actual := utils.FormatBytes(tc.input)
+			if actual != tc.expected {
+				t.Errorf("Expected: %q, but got: %q", tc.expected, actual)
+			}
+		})
+	}
+}
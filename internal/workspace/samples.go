@@ -0,0 +1,105 @@
+package workspace
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns is the header of the workspace samples file
+var Columns = []string{
+	"timestamp", "host", "job_name", "build_id", "workspace",
+	"size_bytes", "files", "complete", "growth_bytes_per_second",
+}
+
+// SchemaMarker is the first line of workspace samples files, which versions their layout
+const SchemaMarker = "# jenkins-monitor workspace-schema=1"
+
+// Sample is one row of the workspace samples file: a measurement of the
+// workspace of a build
+type Sample struct {
+	Host    string
+	JobName string
+	BuildID string
+	Usage
+}
+
+// Fields returns the sample as a row of the workspace samples file, in Columns order
+func (s Sample) Fields() []string {
+	return []string{
+		s.Time.UTC().Format(time.RFC3339),
+		s.Host,
+		s.JobName,
+		s.BuildID,
+		s.Path,
+		strconv.FormatUint(s.Bytes, 10),
+		strconv.Itoa(s.Files),
+		strconv.FormatBool(s.Complete),
+		strconv.FormatFloat(s.Growth, 'f', 2, 64),
+	}
+}
+
+// ErrMalformed is wrapped by the errors of rows that cannot be parsed
+var ErrMalformed = errors.New("malformed workspace sample")
+
+// Reader reads the rows of a workspace samples file
+type Reader struct {
+	csv *csv.Reader
+}
+
+// NewReader checks the schema marker and header of a workspace samples file
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	marker, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if strings.TrimSpace(marker) != SchemaMarker {
+		return nil, fmt.Errorf("not a workspace samples file")
+	}
+	cr := csv.NewReader(br)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if len(header) < len(Columns) {
+		return nil, fmt.Errorf("unexpected header %s", strings.Join(header, ","))
+	}
+	return &Reader{csv: cr}, nil
+}
+
+// Read returns the next sample. Rows that cannot be parsed return an error
+// wrapping ErrMalformed; reading may continue after them.
+func (r *Reader) Read() (Sample, error) {
+	row, err := r.csv.Read()
+	if err != nil {
+		return Sample{}, err
+	}
+	if len(row) < len(Columns) {
+		return Sample{}, fmt.Errorf("%w: %d fields", ErrMalformed, len(row))
+	}
+
+	s := Sample{Host: row[1], JobName: row[2], BuildID: row[3], Usage: Usage{Path: row[4]}}
+	var errs []error
+	var e error
+	s.Time, e = time.Parse(time.RFC3339, row[0])
+	errs = append(errs, e)
+	s.Bytes, e = strconv.ParseUint(row[5], 10, 64)
+	errs = append(errs, e)
+	s.Files, e = strconv.Atoi(row[6])
+	errs = append(errs, e)
+	s.Complete, e = strconv.ParseBool(row[7])
+	errs = append(errs, e)
+	s.Growth, e = strconv.ParseFloat(row[8], 64)
+	errs = append(errs, e)
+	if err := errors.Join(errs...); err != nil {
+		return Sample{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,191 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Usage is the measured size of one workspace
+type Usage struct {
+	Path  string
+	Time  time.Time // when it was measured
+	Bytes uint64    // apparent size of the regular files in the workspace
+	Files int
+	// Complete is false while the first walk of the workspace is still in
+	// progress when the scan budget ran out, so Bytes is a lower bound
+	Complete bool
+	// Growth is the change in bytes per second between the last two complete
+	// measurements; zero until there are two
+	Growth float64
+}
+
+// state is what the Scanner remembers about one workspace between passes
+type state struct {
+	last     Usage
+	base     Usage // last complete measurement, the base of the growth rate
+	hasBase  bool
+	measured bool
+	walk     *walk // measurement in progress, nil between measurements
+}
+
+// walk is a measurement of one workspace carried over passes until the whole
+// tree has been visited
+type walk struct {
+	pending []string // entries still to visit, the next one last
+	bytes   uint64
+	files   int
+}
+
+// Scanner measures workspace sizes. Each workspace is measured at most once
+// per interval, and a pass visits at most budget files and directories over
+// all workspaces, least recently measured first, so large or many workspaces
+// do not thrash the disk. When the budget runs out, the walk of a workspace
+// continues where it stopped in the next pass, so trees larger than the budget
+// are measured completely over several passes.
+type Scanner struct {
+	interval time.Duration
+	budget   int
+	states   map[string]*state
+}
+
+// NewScanner creates a Scanner. A budget of zero or less is unlimited.
+func NewScanner(interval time.Duration, budget int) *Scanner {
+	return &Scanner{interval: interval, budget: budget, states: make(map[string]*state)}
+}
+
+// Scan measures the workspaces among paths that are due and returns the
+// measurements taken in this pass. Workspaces no longer in paths are
+// forgotten. Workspaces that cannot be read are reported in the error.
+func (s *Scanner) Scan(paths []string, now time.Time) ([]Usage, error) {
+	active := make(map[string]bool, len(paths))
+	var due []string
+	for _, p := range paths {
+		if p == "" || active[p] {
+			continue
+		}
+		active[p] = true
+		st, ok := s.states[p]
+		if !ok {
+			st = &state{}
+			s.states[p] = st
+		}
+		if st.walk != nil || !st.measured || now.Sub(st.last.Time) >= s.interval {
+			due = append(due, p)
+		}
+	}
+	for p := range s.states {
+		if !active[p] {
+			delete(s.states, p)
+		}
+	}
+
+	// Never measured first, then least recently measured
+	sort.SliceStable(due, func(i, j int) bool {
+		a, b := s.states[due[i]], s.states[due[j]]
+		if a.measured != b.measured {
+			return !a.measured
+		}
+		return a.last.Time.Before(b.last.Time)
+	})
+
+	remaining := s.budget
+	var measured []Usage
+	var errs []error
+	for _, p := range due {
+		if s.budget > 0 && remaining <= 0 {
+			break
+		}
+		st := s.states[p]
+		if err := checkRoot(p); err != nil {
+			remaining--
+			st.walk = nil
+			errs = append(errs, err)
+			continue
+		}
+		if st.walk == nil {
+			st.walk = &walk{pending: []string{p}}
+		}
+		w := st.walk
+		remaining -= w.step(remaining)
+		u := Usage{Path: p, Time: now, Bytes: w.bytes, Files: w.files, Complete: len(w.pending) == 0}
+		if u.Complete {
+			st.walk = nil
+		} else if st.hasBase {
+			// Until the walk finishes, the last complete measurement stands
+			continue
+		}
+		measured = append(measured, s.record(u))
+	}
+	return measured, errors.Join(errs...)
+}
+
+// record stores a measurement and fills in its growth rate
+func (s *Scanner) record(u Usage) Usage {
+	st := s.states[u.Path]
+	u.Growth = st.last.Growth
+	if u.Complete {
+		if st.hasBase {
+			if elapsed := u.Time.Sub(st.base.Time).Seconds(); elapsed > 0 {
+				u.Growth = (float64(u.Bytes) - float64(st.base.Bytes)) / elapsed
+			}
+		}
+		st.base, st.hasBase = u, true
+	}
+	st.last, st.measured = u, true
+	return u
+}
+
+// Last returns the latest measurement of a workspace, if it has been measured
+func (s *Scanner) Last(path string) (Usage, bool) {
+	st, ok := s.states[path]
+	if !ok || !st.measured {
+		return Usage{}, false
+	}
+	return st.last, true
+}
+
+// checkRoot fails when root is no longer a readable directory
+func checkRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("failed to measure workspace %s: %w", root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("failed to measure workspace %s: not a directory", root)
+	}
+	return nil
+}
+
+// step continues the walk without following symbolic links, summing the sizes
+// of regular files, and returns the number of entries visited: at most budget
+// when budget is positive. Entries that cannot be read are skipped.
+func (w *walk) step(budget int) int {
+	visited := 0
+	for len(w.pending) > 0 && (budget <= 0 || visited < budget) {
+		path := w.pending[len(w.pending)-1]
+		w.pending = w.pending[:len(w.pending)-1]
+		visited++
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if info.Mode().IsRegular() {
+			w.bytes += uint64(info.Size())
+			w.files++
+			continue
+		}
+		if !info.IsDir() {
+			continue
+		}
+		// ReadDir returns the entries read before an error, which are still walked
+		entries, _ := os.ReadDir(path)
+		for i := len(entries) - 1; i >= 0; i-- {
+			w.pending = append(w.pending, filepath.Join(path, entries[i].Name()))
+		}
+	}
+	return visited
+}
@@ -0,0 +1,167 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeWorkspace creates a directory holding files of the given sizes
+func makeWorkspace(t *testing.T, sizes ...int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i, size := range sizes {
+		sub := filepath.Join(dir, "sub")
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(sub, "f"+strings.Repeat("x", i))
+		if err := os.WriteFile(name, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestScanner(t *testing.T) {
+	ws := makeWorkspace(t, 100, 200)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewScanner(time.Minute, 0)
+
+	got, err := s.Scan([]string{ws, ws}, now)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Bytes != 300 || got[0].Files != 2 || !got[0].Complete || got[0].Growth != 0 {
+		t.Fatalf("first Scan() = %+v, want one complete measurement of 300 bytes", got)
+	}
+
+	// Not due again before the interval
+	if got, _ := s.Scan([]string{ws}, now.Add(30*time.Second)); len(got) != 0 {
+		t.Fatalf("Scan() within interval = %+v, want none", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(ws, "big"), make([]byte, 6000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Scan([]string{ws}, now.Add(time.Minute))
+	if len(got) != 1 || got[0].Bytes != 6300 || got[0].Growth != 100 {
+		t.Fatalf("second Scan() = %+v, want 6300 bytes growing 100 B/s", got)
+	}
+	if last, ok := s.Last(ws); !ok || last.Bytes != 6300 {
+		t.Errorf("Last() = %+v, %v", last, ok)
+	}
+
+	// Workspaces of builds that are gone are forgotten
+	s.Scan(nil, now.Add(2*time.Minute))
+	if _, ok := s.Last(ws); ok {
+		t.Errorf("Last() still knows a workspace no longer scanned")
+	}
+}
+
+func TestScannerBudget(t *testing.T) {
+	a := makeWorkspace(t, 10, 10, 10)
+	b := makeWorkspace(t, 10)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Each workspace is its root, "sub" and its files: a has 5 entries, b has 3
+	s := NewScanner(time.Minute, 4)
+
+	got, err := s.Scan([]string{a, b}, now)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Path != a || got[0].Complete {
+		t.Fatalf("Scan() = %+v, want a partial measurement of the first workspace only", got)
+	}
+
+	// b was left over, so it goes first in the next pass, and the walk of a
+	// continues with the budget left
+	got, _ = s.Scan([]string{a, b}, now.Add(time.Second))
+	if len(got) != 2 || got[0].Path != b || !got[0].Complete || got[0].Bytes != 10 {
+		t.Fatalf("next Scan() = %+v, want a complete measurement of the second workspace first", got)
+	}
+	if got[1].Path != a || !got[1].Complete || got[1].Bytes != 30 || got[1].Files != 3 {
+		t.Fatalf("next Scan() = %+v, want the walk of the first workspace finished", got)
+	}
+
+	if _, err := s.Scan([]string{filepath.Join(a, "missing")}, now); err == nil {
+		t.Error("Scan() of a missing workspace returned no error")
+	}
+}
+
+func TestScannerGrowthBeyondBudget(t *testing.T) {
+	ws := makeWorkspace(t, 100, 100, 100, 100, 100, 100)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// The workspace has 8 entries, so each walk takes three passes
+	s := NewScanner(time.Minute, 3)
+
+	scan := func(at time.Time) []Usage {
+		t.Helper()
+		got, err := s.Scan([]string{ws}, at)
+		if err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+		return got
+	}
+	if got := scan(now); len(got) != 1 || got[0].Complete || got[0].Bytes != 100 {
+		t.Fatalf("first Scan() = %+v, want a partial lower bound", got)
+	}
+	scan(now.Add(time.Second))
+	if got := scan(now.Add(2 * time.Second)); len(got) != 1 || !got[0].Complete || got[0].Bytes != 600 {
+		t.Fatalf("third Scan() = %+v, want the first walk finished at 600 bytes", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(ws, "big"), make([]byte, 6000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The next walk is due after the interval; while it runs the last
+	// complete measurement stands
+	start := now.Add(2*time.Second + time.Minute)
+	for i := 0; i < 2; i++ {
+		if got := scan(start.Add(time.Duration(i) * time.Second)); len(got) != 0 {
+			t.Fatalf("Scan() during the second walk = %+v, want none", got)
+		}
+		if last, _ := s.Last(ws); last.Bytes != 600 || !last.Complete {
+			t.Fatalf("Last() during the second walk = %+v, want the first complete measurement", last)
+		}
+	}
+	got := scan(start.Add(2 * time.Second))
+	// Growth is measured between the ends of the two walks, 62s apart
+	if len(got) != 1 || !got[0].Complete || got[0].Bytes != 6600 || got[0].Growth != 6000.0/62 {
+		t.Fatalf("Scan() finishing the second walk = %+v, want 6600 bytes growing 6000 B per 62s", got)
+	}
+}
+
+func TestSamplesRoundTrip(t *testing.T) {
+	want := Sample{
+		Host: "agent-1", JobName: "app", BuildID: "7",
+		Usage: Usage{Path: "/ws/app", Time: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), Bytes: 4096, Files: 3, Complete: true, Growth: 12.5},
+	}
+	var buf bytes.Buffer
+	buf.WriteString(SchemaMarker + "\n")
+	w := csv.NewWriter(&buf)
+	w.Write(Columns)
+	w.Write(want.Fields())
+	w.Write([]string{"bad", "agent-1", "app", "7", "/ws/app", "x", "1", "true", "0"})
+	w.Flush()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	got, err := r.Read()
+	if err != nil || got != want {
+		t.Fatalf("Read() = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := r.Read(); err == nil {
+		t.Error("Read() accepted a malformed row")
+	}
+
+	if _, err := NewReader(strings.NewReader("timestamp,host\n")); err == nil {
+		t.Error("NewReader() accepted a file without the schema marker")
+	}
+}